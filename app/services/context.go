@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps the values of its parent but is never canceled, so
+// the result of a processor call that already succeeded is still persisted
+// when the client disconnects.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+const EventPaymentCreated = "payment.created"
+
+var (
+	ErrSubscriptionNotFound = database.ErrSubscriptionNotFound
+	ErrEventNotFound        = database.ErrEventNotFound
+)
+
+var EventTypes = []string{
+	EventPaymentCreated,
+	processors.EventPaymentAuthorized,
+	processors.EventPaymentCaptured,
+	processors.EventPaymentRefunded,
+	processors.EventPaymentVoided,
+	processors.EventPaymentFailed,
+	processors.EventPaymentExpired,
+}
+
+var statusEvents = map[string]string{
+	processors.StatusCreated:           EventPaymentCreated,
+	processors.StatusAuthorized:        processors.EventPaymentAuthorized,
+	processors.StatusPartiallyCaptured: processors.EventPaymentCaptured,
+	processors.StatusCaptured:          processors.EventPaymentCaptured,
+	processors.StatusPartiallyRefunded: processors.EventPaymentRefunded,
+	processors.StatusRefunded:          processors.EventPaymentRefunded,
+	processors.StatusVoided:            processors.EventPaymentVoided,
+	processors.StatusFailed:            processors.EventPaymentFailed,
+	processors.StatusExpired:           processors.EventPaymentExpired,
+}
+
+// publish queues the event matching the payment's new status for merchant
+// webhooks. The status change is already stored, so failures are only logged.
+func (s *Services) publish(ctx context.Context, paymentId string, status string) {
+	eventType, isOk := statusEvents[status]
+	if s.Webhooks == nil || !isOk {
+		return
+	}
+
+	payment, err := s.Database.FindById(ctx, paymentId)
+	if err != nil {
+		slog.Error("error loading the payment for a webhook event", "payment", paymentId, "detail", err)
+		return
+	}
+
+	payload, err := json.Marshal(payment)
+	if err != nil {
+		slog.Error("error encoding the webhook event", "payment", paymentId, "detail", err)
+		return
+	}
+
+	_, err = s.Webhooks.SaveEvent(ctx, database.WebhookEvent{
+		Type:       eventType,
+		PaymentId:  paymentId,
+		Payload:    payload,
+		MerchantId: payment.MerchantId,
+		Mode:       payment.Mode,
+	})
+	if err != nil {
+		slog.Error("error queueing the webhook event", "payment", paymentId, "event", eventType, "detail", err)
+	}
+}
+
+func (s *Services) CreateSubscription(ctx context.Context, subscription database.WebhookSubscription) (*database.WebhookSubscription, error) {
+	validation := &ValidationError{}
+
+	target, err := url.Parse(subscription.Url)
+	if err != nil || target.Scheme != "https" || target.Host == "" {
+		validation.add("url", "must be an absolute https url")
+	}
+
+	if len(subscription.Events) == 0 {
+		validation.add("events", "must contain at least one event type")
+	}
+
+	for _, event := range subscription.Events {
+		if !knownEvent(event) {
+			validation.add("events", "unknown event type "+event)
+		}
+	}
+
+	if len(validation.Fields) > 0 {
+		return nil, validation
+	}
+
+	if subscription.Secret == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+
+		subscription.Secret = "whsec_" + hex.EncodeToString(secret)
+	}
+
+	id, err := s.Webhooks.SaveSubscription(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Webhooks.FindSubscription(ctx, id)
+}
+
+func (s *Services) ListSubscriptions(ctx context.Context) ([]database.WebhookSubscription, error) {
+	return s.Webhooks.ListSubscriptions(ctx)
+}
+
+func (s *Services) DeleteSubscription(ctx context.Context, id string) error {
+	return s.Webhooks.DeleteSubscription(ctx, id)
+}
+
+func (s *Services) ListDeliveries(ctx context.Context, status string) ([]database.WebhookDelivery, error) {
+	return s.Webhooks.ListDeliveries(ctx, status)
+}
+
+func (s *Services) ReplayEvent(ctx context.Context, eventId string) error {
+	return s.Webhooks.ReplayEvent(ctx, eventId)
+}
+
+func knownEvent(event string) bool {
+	for _, known := range EventTypes {
+		if known == event {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,43 @@
+package services
+
+import "sync"
+
+// paymentLocks serializes the operations that move money on a payment, so
+// concurrent captures and refunds see each other's balance.
+type paymentLocks struct {
+	mu    sync.Mutex
+	locks map[string]*paymentLock
+}
+
+type paymentLock struct {
+	sync.Mutex
+	holders int
+}
+
+func (pl *paymentLocks) lock(paymentId string) func() {
+	pl.mu.Lock()
+	if pl.locks == nil {
+		pl.locks = map[string]*paymentLock{}
+	}
+
+	lock, found := pl.locks[paymentId]
+	if !found {
+		lock = &paymentLock{}
+		pl.locks[paymentId] = lock
+	}
+	lock.holders++
+	pl.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		pl.mu.Lock()
+		defer pl.mu.Unlock()
+
+		lock.holders--
+		if lock.holders == 0 {
+			delete(pl.locks, paymentId)
+		}
+	}
+}
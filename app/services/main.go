@@ -1,11 +1,90 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+
 	"payment-processor.gary94746/main/lib/database"
 	"payment-processor.gary94746/main/lib/processors"
 )
 
+var (
+	ErrTestModeUnavailable = errors.New("test mode is not configured, use a live key")
+	ErrUnknownProcessor    = errors.New("processor not exists")
+)
+
 type Services struct {
-	Database         database.Database
-	PaymentProcessor processors.PaymentConnector
+	Database   database.Database
+	Webhooks   database.WebhookStore
+	Merchants  database.MerchantStore
+	Processors processors.Registry
+	// TestProcessors serve test mode payments, with sandbox credentials.
+	TestProcessors    processors.Registry
+	DefaultProcessor  string
+	FailoverProcessor string
+
+	locks paymentLocks
+}
+
+// registry returns the processors for payments in mode. Payments without a
+// mode predate merchant accounts and are live.
+func (s *Services) registry(mode string) (processors.Registry, error) {
+	if mode != database.ModeTest {
+		return s.Processors, nil
+	}
+
+	if len(s.TestProcessors) == 0 {
+		return nil, ErrTestModeUnavailable
+	}
+
+	return s.TestProcessors, nil
+}
+
+func (s *Services) connector(mode string, name string) (processors.PaymentConnector, string, error) {
+	if name == "" {
+		name = s.DefaultProcessor
+	}
+
+	registry, err := s.registry(mode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A processor with live credentials only is not available to test keys.
+	connector, err := registry.Get(name)
+	if err != nil && mode == database.ModeTest {
+		return nil, "", ErrTestModeUnavailable
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownProcessor, name)
+	}
+
+	return connector, name, nil
+}
+
+// route picks the connector for a new payment. Payments without an explicit
+// processor go to the failover processor while the default one is unavailable.
+func (s *Services) route(mode string, name string) (processors.PaymentConnector, string, error) {
+	connector, processorName, err := s.connector(mode, name)
+	if err != nil || name != "" || s.FailoverProcessor == "" || s.FailoverProcessor == processorName {
+		return connector, processorName, err
+	}
+
+	if available(connector) {
+		return connector, processorName, nil
+	}
+
+	failover, _, err := s.connector(mode, s.FailoverProcessor)
+	if err != nil || !available(failover) {
+		return connector, processorName, nil
+	}
+
+	slog.Warn("payment routed to the failover processor", "processor", processorName, "failover", s.FailoverProcessor)
+	return failover, s.FailoverProcessor, nil
+}
+
+func available(connector processors.PaymentConnector) bool {
+	breaker, isOk := connector.(interface{ Available() bool })
+	return !isOk || breaker.Available()
 }
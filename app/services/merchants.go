@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"payment-processor.gary94746/main/lib/database"
+)
+
+var (
+	ErrMerchantNotFound = database.ErrMerchantNotFound
+	ErrApiKeyNotFound   = database.ErrApiKeyNotFound
+	ErrInvalidApiKey    = errors.New("invalid api key")
+)
+
+var keyPrefixes = map[string]string{
+	database.KeySecret:      "sk_",
+	database.KeyPublishable: "pk_",
+}
+
+// IssuedApiKey carries the key value, which is only available when the key
+// is issued.
+type IssuedApiKey struct {
+	database.ApiKey
+	Key string `json:"key"`
+}
+
+// CreateMerchant creates the merchant with a secret and a publishable key for
+// each mode.
+func (s *Services) CreateMerchant(ctx context.Context, name string) (*database.Merchant, []IssuedApiKey, error) {
+	if strings.TrimSpace(name) == "" {
+		validation := &ValidationError{}
+		validation.add("name", "must not be empty")
+		return nil, nil, validation
+	}
+
+	id, err := s.Merchants.SaveMerchant(ctx, database.Merchant{Name: name})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := []IssuedApiKey{}
+	for _, mode := range []string{database.ModeTest, database.ModeLive} {
+		for _, kind := range []string{database.KeySecret, database.KeyPublishable} {
+			key, err := s.IssueApiKey(ctx, id, kind, mode)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			keys = append(keys, *key)
+		}
+	}
+
+	merchant, err := s.Merchants.FindMerchant(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return merchant, keys, nil
+}
+
+func (s *Services) IssueApiKey(ctx context.Context, merchantId string, kind string, mode string) (*IssuedApiKey, error) {
+	validation := &ValidationError{}
+
+	prefix, isOk := keyPrefixes[kind]
+	if !isOk {
+		validation.add("kind", "must be secret or publishable")
+	}
+
+	if mode != database.ModeTest && mode != database.ModeLive {
+		validation.add("mode", "must be test or live")
+	}
+
+	if len(validation.Fields) > 0 {
+		return nil, validation
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	value := prefix + mode + "_" + hex.EncodeToString(random)
+	key := database.ApiKey{
+		MerchantId: merchantId,
+		Kind:       kind,
+		Mode:       mode,
+		Hash:       hashApiKey(value),
+		Hint:       prefix + mode + "_..." + value[len(value)-4:],
+	}
+
+	if _, err := s.Merchants.SaveApiKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.Merchants.FindApiKey(ctx, key.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IssuedApiKey{ApiKey: *stored, Key: value}, nil
+}
+
+func (s *Services) ListApiKeys(ctx context.Context, merchantId string) ([]database.ApiKey, error) {
+	return s.Merchants.ListApiKeys(ctx, merchantId)
+}
+
+func (s *Services) RevokeApiKey(ctx context.Context, merchantId string, id string) error {
+	return s.Merchants.RevokeApiKey(ctx, merchantId, id)
+}
+
+// Authenticate returns the active key matching value.
+func (s *Services) Authenticate(ctx context.Context, value string) (*database.ApiKey, error) {
+	if value == "" {
+		return nil, ErrInvalidApiKey
+	}
+
+	key, err := s.Merchants.FindApiKey(ctx, hashApiKey(value))
+	if errors.Is(err, database.ErrApiKeyNotFound) {
+		return nil, ErrInvalidApiKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, ErrInvalidApiKey
+	}
+
+	return key, nil
+}
+
+// Keys are long random values, so a plain SHA-256 is enough to keep them
+// out of the database while still allowing lookups by hash.
+func hashApiKey(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
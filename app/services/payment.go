@@ -1,88 +1,357 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"payment-processor.gary94746/main/lib/database"
 	"payment-processor.gary94746/main/lib/processors"
 )
 
-func (s *Services) CreatePayment(payment processors.Payment) (*processors.PaymentDetail, error) {
-	paymentCreation, err := s.PaymentProcessor.Create(payment)
+var (
+	ErrPaymentNotFound      = database.ErrPaymentNotFound
+	ErrInvalidCursor        = database.ErrInvalidCursor
+	ErrDuplicateReference   = database.ErrDuplicateReference
+	ErrPaymentNotRefundable = errors.New("payment is not captured")
+	ErrRefundExceedsBalance = errors.New("refund amount exceeds the refundable balance")
+	ErrCaptureExceedsAmount = errors.New("capture amount exceeds the uncaptured amount")
+	ErrPartialCaptureManual = errors.New("partial captures require a manual capture method")
+)
+
+func (s *Services) CreatePayment(ctx context.Context, payment processors.Payment) (*processors.PaymentDetail, error) {
+	if err := validatePayment(&payment); err != nil {
+		return nil, err
+	}
+
+	if payment.ReferenceId != "" {
+		_, err := s.Database.FindByReference(ctx, payment.ReferenceId)
+		if err == nil {
+			return nil, ErrDuplicateReference
+		}
+		if !errors.Is(err, database.ErrPaymentNotFound) {
+			return nil, err
+		}
+	}
+
+	scope, _ := database.ScopeFrom(ctx)
+	connector, processorName, err := s.route(scope.Mode, payment.Processor)
 	if err != nil {
-		return nil, errors.New("error creating the payment")
+		return nil, err
+	}
+
+	if payment.CaptureMethod == "" {
+		payment.CaptureMethod = processors.CaptureAutomatic
+	}
+
+	paymentCreation, err := connector.Create(ctx, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the payment: %w", err)
 	}
 
 	items := []database.LineItem{}
 	for _, item := range payment.LineItems {
 		items = append(items, database.LineItem{
-			Name:     item.Name,
-			Amount:   item.Amount,
-			Quantity: item.Quantity,
+			Name:        item.Name,
+			Amount:      item.Amount,
+			Quantity:    item.Quantity,
+			Sku:         item.Sku,
+			Description: item.Description,
+			Tax:         item.Tax,
 		})
 	}
 
+	var shipping *database.Shipping
+	if payment.Shipping != nil {
+		address := payment.Shipping.Address
+		shipping = &database.Shipping{
+			Name:   payment.Shipping.Name,
+			Amount: payment.Shipping.Amount,
+			Address: database.Address{
+				Line1:      address.Line1,
+				Line2:      address.Line2,
+				City:       address.City,
+				State:      address.State,
+				PostalCode: address.PostalCode,
+				Country:    address.Country,
+			},
+		}
+	}
+
+	var customer *database.Customer
+	if payment.Customer != nil && *payment.Customer != (processors.Customer{}) {
+		customer = &database.Customer{
+			Name:  payment.Customer.Name,
+			Email: payment.Customer.Email,
+			Phone: payment.Customer.Phone,
+		}
+	}
+
 	databasePayment := database.Payment{
-		Currency:    payment.Currency,
-		Amount:      payment.Amount,
-		Status:      processors.StatusCreated,
-		RedirectUrl: payment.RedirectUrl,
-		CancelUrl:   payment.CancelUrl,
-		PrivateId:   paymentCreation.PrivateId,
-		Id:          payment.Id,
-		LineItems:   items,
-		Refunds:     []database.RefundResponse{},
-	}
-
-	paymentId := s.Database.Save(databasePayment)
+		Currency:      payment.Currency,
+		Amount:        payment.Amount,
+		Status:        processors.StatusCreated,
+		RedirectUrl:   payment.RedirectUrl,
+		CancelUrl:     payment.CancelUrl,
+		PrivateId:     paymentCreation.PrivateId,
+		Id:            payment.Id,
+		LineItems:     items,
+		Shipping:      shipping,
+		Discount:      payment.Discount,
+		Refunds:       []database.Refund{},
+		Captures:      []database.Capture{},
+		Processor:     processorName,
+		CaptureMethod: payment.CaptureMethod,
+		ReferenceId:   payment.ReferenceId,
+		Description:   payment.Description,
+		Customer:      customer,
+		Metadata:      payment.Metadata,
+	}
+
+	paymentId, err := s.Database.Save(detach(ctx), databasePayment)
+	if errors.Is(err, database.ErrDuplicateReference) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("error saving the payment")
+	}
+
 	payment.Id = paymentId
 	paymentCreation.Id = paymentId
+	paymentCreation.Processor = processorName
+	s.publish(detach(ctx), paymentId, processors.StatusCreated)
 
 	return paymentCreation, nil
 }
 
-func (s *Services) CapturePayment(paymentId string) error {
-	payment, err := s.Database.FindById(paymentId)
+func (s *Services) CapturePayment(ctx context.Context, paymentId string, capture processors.CaptureRequest) (*database.Capture, error) {
+	unlock := s.locks.lock(paymentId)
+	defer unlock()
+
+	payment, err := s.Database.FindById(ctx, paymentId)
 	if err != nil {
-		return errors.New("payment not found")
+		return nil, err
 	}
 
-	_, captureErr := s.PaymentProcessor.Capture(payment.PrivateId)
+	var captured int64
+	for _, previous := range payment.Captures {
+		captured += previous.Amount
+	}
+
+	remaining := payment.Amount - captured
+	amount := capture.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+
+	if amount <= 0 || amount > remaining {
+		return nil, ErrCaptureExceedsAmount
+	}
+
+	isPartial := amount < remaining || len(payment.Captures) > 0
+	if isPartial && payment.CaptureMethod != processors.CaptureManual {
+		return nil, ErrPartialCaptureManual
+	}
+
+	status := processors.StatusPartiallyCaptured
+	if capture.Final || amount == remaining {
+		status = processors.StatusCaptured
+		capture.Final = true
+	}
+
+	if err := checkTransition(payment, status); err != nil {
+		return nil, err
+	}
+
+	connector, _, err := s.connector(payment.Mode, payment.Processor)
+	if err != nil {
+		return nil, err
+	}
+
+	if isPartial {
+		capture.Amount = amount
+	}
+
+	captureRes, captureErr := connector.Capture(ctx, payment.PrivateId, capture)
 	if captureErr != nil {
-		return errors.New(captureErr.Error())
+		return nil, captureErr
+	}
+
+	if captureRes.Amount > 0 {
+		amount = captureRes.Amount
+	}
+
+	recorded := database.Capture{
+		Id:         captureRes.Id,
+		Amount:     amount,
+		Final:      capture.Final,
+		CapturedAt: time.Now(),
+	}
+
+	err = s.Database.RecordCapture(detach(ctx), paymentId, recorded, status)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(detach(ctx), paymentId, status)
+
+	return &recorded, nil
+}
+
+func (s *Services) VoidPayment(ctx context.Context, paymentId string, void processors.VoidRequest) error {
+	unlock := s.locks.lock(paymentId)
+	defer unlock()
+
+	payment, err := s.Database.FindById(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	status := processors.StatusVoided
+	if payment.Status == processors.StatusPartiallyCaptured {
+		status = processors.StatusCaptured
 	}
 
-	s.Database.UpdateStatus(paymentId, processors.StatusCaptured)
+	if err := checkTransition(payment, status); err != nil {
+		return err
+	}
+
+	connector, _, err := s.connector(payment.Mode, payment.Processor)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	_, err = connector.Void(ctx, payment.PrivateId, void)
+	if err != nil {
+		return err
+	}
+
+	return s.transition(detach(ctx), payment, status)
 }
 
-func (s *Services) GetPayment(paymentId string) (*database.Payment, error) {
-	payment, err := s.Database.FindById(paymentId)
+func (s *Services) GetPayment(ctx context.Context, paymentId string) (*database.Payment, error) {
+	payment, err := s.Database.FindById(ctx, paymentId)
 
 	if err != nil {
-		return nil, errors.New("error getting payment")
+		return nil, err
 	}
 
 	return payment, nil
 }
 
-func (s *Services) RefundPayment(paymentId string, refund processors.PartialRefund) (*processors.RefundResponse, error) {
-	order, err := s.Database.FindById(paymentId)
+func (s *Services) GetPaymentByReference(ctx context.Context, referenceId string) (*database.Payment, error) {
+	return s.Database.FindByReference(ctx, referenceId)
+}
+
+func (s *Services) ListPayments(ctx context.Context, filter database.PaymentFilter) (*database.PaymentPage, error) {
+	validation := &ValidationError{}
+	if filter.MinAmount > 0 && filter.MaxAmount > 0 && filter.MinAmount > filter.MaxAmount {
+		validation.add("minAmount", "must not be greater than maxAmount")
+	}
+
+	if !filter.CreatedAfter.IsZero() && !filter.CreatedBefore.IsZero() && !filter.CreatedAfter.Before(filter.CreatedBefore) {
+		validation.add("createdAfter", "must be before createdBefore")
+	}
+
+	if len(validation.Fields) > 0 {
+		return nil, validation
+	}
+
+	return s.Database.List(ctx, filter)
+}
+
+func (s *Services) RefundPayment(ctx context.Context, paymentId string, refund processors.PartialRefund) (*database.Refund, error) {
+	unlock := s.locks.lock(paymentId)
+	defer unlock()
+
+	order, err := s.Database.FindById(ctx, paymentId)
 	if err != nil {
 		return nil, err
 	}
 
-	refundRes, err1 := s.PaymentProcessor.Refund(order.PrivateId, refund)
+	isRefundable := order.Status == processors.StatusCaptured || order.Status == processors.StatusPartiallyRefunded
+	if !isRefundable {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	balance := refundableAmount(order)
+	if refund.Amount == 0 {
+		refund.Amount = balance
+	}
+
+	if refund.Amount <= 0 || refund.Amount > balance {
+		return nil, ErrRefundExceedsBalance
+	}
+
+	status := refundStatus(order, refund.Amount)
+	if err := checkTransition(order, status); err != nil {
+		return nil, err
+	}
+
+	connector, _, err := s.connector(order.Mode, order.Processor)
+	if err != nil {
+		return nil, err
+	}
+
+	// A refund the processor only completed in part is still recorded, as that
+	// money has left the account, before reporting the failure.
+	refundRes, err1 := connector.Refund(ctx, order.PrivateId, refund)
+	var partial *processors.PartialRefundError
+	if errors.As(err1, &partial) {
+		refundRes = &partial.Refund
+		status = refundStatus(order, partial.Refund.Amount)
+	} else if err1 != nil {
+		return nil, err1
+	}
+
+	recorded := refundRecord(order, *refundRes, refund.Amount)
+	recorded.Reason = refund.Reason
+
+	if recorded.Status == processors.RefundFailed {
+		err = s.Database.AttachRefund(detach(ctx), paymentId, recorded)
+	} else {
+		err = s.Database.RecordRefund(detach(ctx), paymentId, recorded, status)
+	}
+	if errors.Is(err, database.ErrDuplicateRefund) && err1 == nil {
+		// The processor's notification about this refund got in first.
+		return &recorded, nil
+	}
+	if errors.Is(err, database.ErrDuplicateRefund) {
+		return nil, err1
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recorded.Status != processors.RefundFailed {
+		s.publish(detach(ctx), paymentId, status)
+	}
 	if err1 != nil {
 		return nil, err1
 	}
 
-	s.Database.UpdateStatus(paymentId, processors.StatusRefunded)
-	s.Database.AttachRefund(paymentId, database.RefundResponse{
-		Id:     refundRes.Id,
-		Amount: refundRes.Amount,
-	})
+	return &recorded, nil
+}
+
+func refundRecord(payment *database.Payment, refund processors.RefundResponse, amount int64) database.Refund {
+	recorded := database.Refund{
+		Id:        refund.Id,
+		Amount:    refund.Amount,
+		Currency:  refund.Currency,
+		Status:    refund.Status,
+		CreatedAt: time.Now(),
+	}
+
+	if recorded.Amount == 0 {
+		recorded.Amount = amount
+	}
+
+	if recorded.Currency == "" {
+		recorded.Currency = payment.Currency
+	}
+
+	if recorded.Status == "" {
+		recorded.Status = processors.RefundSucceeded
+	}
 
-	return refundRes, nil
+	return recorded
 }
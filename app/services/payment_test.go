@@ -0,0 +1,413 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/app/services"
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/processors"
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+var liveMerchant = database.WithScope(context.Background(), database.Scope{MerchantId: "merchant", Mode: database.ModeLive})
+
+// lenientConnector accepts every request after a short delay, so only the
+// services layer keeps the totals of a payment in check.
+type lenientConnector struct {
+	counter  int64
+	captured int64
+	refunded int64
+}
+
+func (c *lenientConnector) Init(settings processors.PaymentSettings) error {
+	return nil
+}
+
+func (c *lenientConnector) Create(ctx context.Context, payment processors.Payment) (*processors.PaymentDetail, error) {
+	return &processors.PaymentDetail{PrivateId: c.next("pi"), Status: processors.StatusCreated}, nil
+}
+
+func (c *lenientConnector) Capture(ctx context.Context, paymentId string, capture processors.CaptureRequest) (*processors.CaptureResponse, error) {
+	time.Sleep(time.Millisecond)
+	atomic.AddInt64(&c.captured, capture.Amount)
+	return &processors.CaptureResponse{Id: c.next("ch"), Amount: capture.Amount, Final: capture.Final}, nil
+}
+
+func (c *lenientConnector) Refund(ctx context.Context, paymentId string, refund processors.PartialRefund) (*processors.RefundResponse, error) {
+	time.Sleep(time.Millisecond)
+	atomic.AddInt64(&c.refunded, refund.Amount)
+	return &processors.RefundResponse{Id: c.next("re"), Amount: refund.Amount, Currency: "USD", Status: processors.RefundSucceeded}, nil
+}
+
+func (c *lenientConnector) Void(ctx context.Context, paymentId string, void processors.VoidRequest) (bool, error) {
+	return true, nil
+}
+
+func (c *lenientConnector) next(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, atomic.AddInt64(&c.counter, 1))
+}
+
+func TestConcurrentCaptureAndRefund(t *testing.T) {
+	const workers = 20
+	const step = 300
+
+	connector := &lenientConnector{}
+	s := &services.Services{
+		Database:         database.NewInMemory(),
+		Processors:       processors.Registry{"lenient": connector},
+		DefaultProcessor: "lenient",
+	}
+
+	payment := processortest.NewPayment()
+	payment.CaptureMethod = processors.CaptureManual
+	created, err := s.CreatePayment(liveMerchant, payment)
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var captures, refunds int64
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			if _, err := s.CreatePayment(liveMerchant, processortest.NewPayment()); err != nil {
+				t.Errorf("CreatePayment: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if _, err := s.CapturePayment(liveMerchant, created.Id, processors.CaptureRequest{Amount: step}); err == nil {
+				atomic.AddInt64(&captures, 1)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if _, err := s.RefundPayment(liveMerchant, created.Id, processors.PartialRefund{Amount: step}); err == nil {
+				atomic.AddInt64(&refunds, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := s.CapturePayment(liveMerchant, created.Id, processors.CaptureRequest{Final: true}); err != nil {
+		t.Fatalf("final CapturePayment: %v", err)
+	}
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.RefundPayment(liveMerchant, created.Id, processors.PartialRefund{Amount: step}); err == nil {
+				atomic.AddInt64(&refunds, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stored, err := s.GetPayment(liveMerchant, created.Id)
+	if err != nil {
+		t.Fatalf("GetPayment: %v", err)
+	}
+
+	var captured, refunded int64
+	for _, capture := range stored.Captures {
+		captured += capture.Amount
+	}
+	for _, refund := range stored.Refunds {
+		refunded += refund.Amount
+	}
+
+	if captured != payment.Amount || connector.captured != captured {
+		t.Errorf("captured = %d, processor captured %d, want %d", captured, connector.captured, payment.Amount)
+	}
+
+	if refunded > captured || connector.refunded != refunded {
+		t.Errorf("refunded = %d, processor refunded %d, want at most %d", refunded, connector.refunded, captured)
+	}
+
+	if int64(len(stored.Refunds)) != refunds || refunds != captured/step {
+		t.Errorf("refunds = %d recorded, %d succeeded, want %d", len(stored.Refunds), refunds, captured/step)
+	}
+
+	if captures > payment.Amount/step {
+		t.Errorf("captures = %d, want at most %d", captures, payment.Amount/step)
+	}
+}
+
+func TestTestModeUsesSandboxProcessors(t *testing.T) {
+	live := &lenientConnector{}
+	s := &services.Services{
+		Database:         database.NewInMemory(),
+		Processors:       processors.Registry{"lenient": live},
+		DefaultProcessor: "lenient",
+	}
+
+	ctx := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant", Mode: database.ModeTest})
+	if _, err := s.CreatePayment(ctx, processortest.NewPayment()); !errors.Is(err, services.ErrTestModeUnavailable) {
+		t.Fatalf("CreatePayment without sandbox processors = %v, want ErrTestModeUnavailable", err)
+	}
+
+	sandbox := &lenientConnector{}
+	s.TestProcessors = processors.Registry{"lenient": sandbox}
+	if _, err := s.CreatePayment(ctx, processortest.NewPayment()); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	if live.counter != 0 || sandbox.counter != 1 {
+		t.Errorf("live processor called %d times, sandbox %d, want 0 and 1", live.counter, sandbox.counter)
+	}
+
+	s.Processors["other"] = &lenientConnector{}
+	payment := processortest.NewPayment()
+	payment.Processor = "other"
+	if _, err := s.CreatePayment(ctx, payment); !errors.Is(err, services.ErrTestModeUnavailable) {
+		t.Errorf("CreatePayment with a live only processor = %v, want ErrTestModeUnavailable", err)
+	}
+}
+
+// partialConnector refunds half of what it is asked for before failing.
+type partialConnector struct {
+	lenientConnector
+}
+
+func (c *partialConnector) Refund(ctx context.Context, paymentId string, refund processors.PartialRefund) (*processors.RefundResponse, error) {
+	return nil, &processors.PartialRefundError{
+		Refund: processors.RefundResponse{Id: c.next("re"), Amount: refund.Amount / 2, Currency: "USD", Status: processors.RefundSucceeded},
+		Err:    processors.ErrUnavailable,
+	}
+}
+
+func TestPartialRefundIsRecorded(t *testing.T) {
+	s := &services.Services{
+		Database:         database.NewInMemory(),
+		Processors:       processors.Registry{"partial": &partialConnector{}},
+		DefaultProcessor: "partial",
+	}
+
+	created, err := s.CreatePayment(liveMerchant, processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	if _, err := s.CapturePayment(liveMerchant, created.Id, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("CapturePayment: %v", err)
+	}
+
+	if _, err := s.RefundPayment(liveMerchant, created.Id, processors.PartialRefund{Amount: 2000}); !errors.Is(err, processors.ErrUnavailable) {
+		t.Fatalf("RefundPayment = %v, want the processor failure", err)
+	}
+
+	stored, err := s.GetPayment(liveMerchant, created.Id)
+	if err != nil {
+		t.Fatalf("GetPayment: %v", err)
+	}
+
+	if len(stored.Refunds) != 1 || stored.Refunds[0].Amount != 1000 || stored.Status != processors.StatusPartiallyRefunded {
+		t.Errorf("payment = %s with refunds %+v, want partially refunded by 1000", stored.Status, stored.Refunds)
+	}
+}
+
+// notifyingConnector announces its refunds by webhook while Refund is still
+// running, as processors often do.
+type notifyingConnector struct {
+	lenientConnector
+	privateId string
+	refunding chan struct{}
+}
+
+func (c *notifyingConnector) Create(ctx context.Context, payment processors.Payment) (*processors.PaymentDetail, error) {
+	return &processors.PaymentDetail{PrivateId: c.privateId, Status: processors.StatusCreated}, nil
+}
+
+func (c *notifyingConnector) Refund(ctx context.Context, paymentId string, refund processors.PartialRefund) (*processors.RefundResponse, error) {
+	close(c.refunding)
+	time.Sleep(20 * time.Millisecond)
+	return &processors.RefundResponse{Id: "re_notified", Amount: refund.Amount, Currency: "USD", Status: processors.RefundSucceeded}, nil
+}
+
+func (c *notifyingConnector) ParseWebhook(ctx context.Context, payload []byte, headers http.Header) (*processors.WebhookEvent, error) {
+	return &processors.WebhookEvent{
+		Type:      processors.EventPaymentRefunded,
+		PrivateId: c.privateId,
+		Refunds:   []processors.RefundResponse{{Id: "re_notified", Amount: 2500, Currency: "USD", Status: processors.RefundSucceeded}},
+	}, nil
+}
+
+func TestRefundWebhookDuringRefund(t *testing.T) {
+	connector := &notifyingConnector{privateId: "pi_notified", refunding: make(chan struct{})}
+	s := &services.Services{
+		Database:         database.NewInMemory(),
+		Processors:       processors.Registry{"notifying": connector},
+		DefaultProcessor: "notifying",
+	}
+
+	created, err := s.CreatePayment(liveMerchant, processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	if _, err := s.CapturePayment(liveMerchant, created.Id, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("CapturePayment: %v", err)
+	}
+
+	notified := make(chan error, 1)
+	go func() {
+		<-connector.refunding
+		notified <- s.HandleWebhook(context.Background(), database.ModeLive, "notifying", nil, nil)
+	}()
+
+	if _, err := s.RefundPayment(liveMerchant, created.Id, processors.PartialRefund{}); err != nil {
+		t.Fatalf("RefundPayment: %v", err)
+	}
+
+	if err := <-notified; err != nil {
+		t.Fatalf("HandleWebhook: %v", err)
+	}
+
+	stored, err := s.GetPayment(liveMerchant, created.Id)
+	if err != nil {
+		t.Fatalf("GetPayment: %v", err)
+	}
+
+	if len(stored.Refunds) != 1 || stored.Status != processors.StatusRefunded {
+		t.Errorf("payment = %s with refunds %+v, want a single refund, refunded", stored.Status, stored.Refunds)
+	}
+}
+
+// eventConnector reports whatever event the test sets next.
+type eventConnector struct {
+	lenientConnector
+	event processors.WebhookEvent
+}
+
+func (c *eventConnector) ParseWebhook(ctx context.Context, payload []byte, headers http.Header) (*processors.WebhookEvent, error) {
+	event := c.event
+	return &event, nil
+}
+
+func TestRefundStatusUpdates(t *testing.T) {
+	connector := &eventConnector{}
+	s := &services.Services{
+		Database:         database.NewInMemory(),
+		Processors:       processors.Registry{"events": connector},
+		DefaultProcessor: "events",
+	}
+
+	created, err := s.CreatePayment(liveMerchant, processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	if _, err := s.CapturePayment(liveMerchant, created.Id, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("CapturePayment: %v", err)
+	}
+
+	stored, _ := s.GetPayment(liveMerchant, created.Id)
+	notify := func(status string) *database.Payment {
+		t.Helper()
+
+		connector.event = processors.WebhookEvent{
+			Type:      processors.EventPaymentRefunded,
+			PrivateId: stored.PrivateId,
+			Refunds:   []processors.RefundResponse{{Id: "re_slow", Amount: 2500, Currency: "USD", Status: status}},
+		}
+		if err := s.HandleWebhook(context.Background(), database.ModeLive, "events", nil, nil); err != nil {
+			t.Fatalf("HandleWebhook(%s): %v", status, err)
+		}
+
+		payment, err := s.GetPayment(liveMerchant, created.Id)
+		if err != nil {
+			t.Fatalf("GetPayment: %v", err)
+		}
+
+		return payment
+	}
+
+	if payment := notify(processors.RefundPending); payment.Status != processors.StatusRefunded {
+		t.Errorf("status after a pending refund = %s, want refunded", payment.Status)
+	}
+
+	payment := notify(processors.RefundFailed)
+	if len(payment.Refunds) != 1 || payment.Refunds[0].Status != processors.RefundFailed {
+		t.Errorf("refunds = %+v, want re_slow failed", payment.Refunds)
+	}
+	if payment.Status != processors.StatusCaptured {
+		t.Errorf("status after the refund failed = %s, want captured", payment.Status)
+	}
+
+	if _, err := s.RefundPayment(liveMerchant, created.Id, processors.PartialRefund{Amount: 2500}); err != nil {
+		t.Errorf("RefundPayment after the failed refund: %v", err)
+	}
+}
+
+func TestWebhookForUnknownProcessor(t *testing.T) {
+	s := &services.Services{
+		Database:   database.NewInMemory(),
+		Processors: processors.Registry{"lenient": &lenientConnector{}},
+	}
+
+	err := s.HandleWebhook(context.Background(), database.ModeLive, "missing", []byte("{}"), http.Header{})
+	if !errors.Is(err, services.ErrUnknownProcessor) {
+		t.Errorf("unknown processor = %v, want ErrUnknownProcessor", err)
+	}
+
+	err = s.HandleWebhook(context.Background(), database.ModeLive, "lenient", []byte("{}"), http.Header{})
+	if !errors.Is(err, services.ErrWebhooksNotSupported) {
+		t.Errorf("processor without webhooks = %v, want ErrWebhooksNotSupported", err)
+	}
+}
+
+// downConnector is a processor in the middle of an outage.
+type downConnector struct {
+	lenientConnector
+}
+
+func (c *downConnector) Create(ctx context.Context, payment processors.Payment) (*processors.PaymentDetail, error) {
+	atomic.AddInt64(&c.counter, 1)
+	return nil, processors.ErrUnavailable
+}
+
+func TestFailoverWhileBreakerIsOpen(t *testing.T) {
+	down := &downConnector{}
+	backup := &lenientConnector{}
+	s := &services.Services{
+		Database: database.NewInMemory(),
+		Processors: processors.Registry{
+			"primary": processors.NewBreaker("failover_primary", down, processors.BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Hour, HalfOpenRequests: 1}),
+			"backup":  backup,
+		},
+		DefaultProcessor:  "primary",
+		FailoverProcessor: "backup",
+	}
+
+	if _, err := s.CreatePayment(liveMerchant, processortest.NewPayment()); !errors.Is(err, processors.ErrUnavailable) {
+		t.Fatalf("CreatePayment during the outage = %v, want the outage", err)
+	}
+
+	created, err := s.CreatePayment(liveMerchant, processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("CreatePayment while the breaker is open: %v", err)
+	}
+
+	stored, err := s.GetPayment(liveMerchant, created.Id)
+	if err != nil || stored.Processor != "backup" || backup.counter != 1 || down.counter != 1 {
+		t.Errorf("payment = %+v, %v with %d backup and %d primary calls, want it created on backup", stored, err, backup.counter, down.counter)
+	}
+
+	payment := processortest.NewPayment()
+	payment.Processor = "primary"
+	if _, err := s.CreatePayment(liveMerchant, payment); !errors.Is(err, processors.ErrCircuitOpen) {
+		t.Errorf("CreatePayment on an explicit processor = %v, want circuit_open without failover", err)
+	}
+}
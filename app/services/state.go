@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+var transitions = map[string][]string{
+	processors.StatusCreated: {
+		processors.StatusApproved,
+		processors.StatusAuthorized,
+		processors.StatusPartiallyCaptured,
+		processors.StatusCaptured,
+		processors.StatusVoided,
+		processors.StatusFailed,
+		processors.StatusExpired,
+	},
+	processors.StatusApproved: {
+		processors.StatusAuthorized,
+		processors.StatusPartiallyCaptured,
+		processors.StatusCaptured,
+		processors.StatusVoided,
+		processors.StatusFailed,
+		processors.StatusExpired,
+	},
+	processors.StatusAuthorized: {
+		processors.StatusPartiallyCaptured,
+		processors.StatusCaptured,
+		processors.StatusVoided,
+		processors.StatusFailed,
+		processors.StatusExpired,
+	},
+	processors.StatusPartiallyCaptured: {
+		processors.StatusPartiallyCaptured,
+		processors.StatusCaptured,
+	},
+	processors.StatusCaptured: {
+		processors.StatusPartiallyRefunded,
+		processors.StatusRefunded,
+	},
+	processors.StatusPartiallyRefunded: {
+		processors.StatusCaptured,
+		processors.StatusPartiallyRefunded,
+		processors.StatusRefunded,
+	},
+	processors.StatusFailed: {
+		processors.StatusCaptured,
+	},
+	// A refund failing after it was counted gives its amount back.
+	processors.StatusRefunded: {
+		processors.StatusCaptured,
+		processors.StatusPartiallyRefunded,
+	},
+	processors.StatusVoided:  {},
+	processors.StatusExpired: {},
+}
+
+type TransitionError struct {
+	PaymentId string
+	From      string
+	To        string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment %s cannot move from %s to %s", e.PaymentId, e.From, e.To)
+}
+
+func canTransition(from string, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkTransition(payment *database.Payment, to string) error {
+	if !canTransition(payment.Status, to) {
+		return &TransitionError{
+			PaymentId: payment.Id,
+			From:      payment.Status,
+			To:        to,
+		}
+	}
+
+	return nil
+}
+
+func (s *Services) transition(ctx context.Context, payment *database.Payment, to string) error {
+	if err := checkTransition(payment, to); err != nil {
+		return err
+	}
+
+	err := s.Database.UpdateStatus(ctx, payment.Id, to)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = to
+	s.publish(ctx, payment.Id, to)
+	return nil
+}
+
+func refundStatus(payment *database.Payment, amount int64) string {
+	if refundedAmount(payment)+amount >= capturedAmount(payment) {
+		return processors.StatusRefunded
+	}
+
+	return processors.StatusPartiallyRefunded
+}
+
+// settledStatus is the status a captured payment has for the refunds it
+// holds, leaving failed ones out.
+func settledStatus(payment *database.Payment) string {
+	refunded := refundedAmount(payment)
+	switch {
+	case refunded == 0:
+		return processors.StatusCaptured
+	case refunded >= capturedAmount(payment):
+		return processors.StatusRefunded
+	}
+
+	return processors.StatusPartiallyRefunded
+}
+
+func capturedAmount(payment *database.Payment) int64 {
+	if len(payment.Captures) == 0 {
+		return payment.Amount
+	}
+
+	var captured int64
+	for _, capture := range payment.Captures {
+		captured += capture.Amount
+	}
+
+	return captured
+}
+
+func refundedAmount(payment *database.Payment) int64 {
+	var refunded int64
+	for _, refund := range payment.Refunds {
+		if refund.Status != processors.RefundFailed {
+			refunded += refund.Amount
+		}
+	}
+
+	return refunded
+}
+
+func refundableAmount(payment *database.Payment) int64 {
+	return capturedAmount(payment) - refundedAmount(payment)
+}
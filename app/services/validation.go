@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+const (
+	MaxMetadataKeys        = 20
+	MaxMetadataKeyLength   = 40
+	MaxMetadataValueLength = 500
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := []string{}
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+
+	return "invalid payment: " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) add(field string, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+type Totals struct {
+	Items    int64
+	Tax      int64
+	Shipping int64
+	Discount int64
+	Total    int64
+}
+
+func paymentTotals(payment processors.Payment, validation *ValidationError) Totals {
+	var totals Totals
+
+	for index, item := range payment.LineItems {
+		field := fmt.Sprintf("lineItems[%d]", index)
+
+		if item.Amount <= 0 {
+			validation.add(field+".amount", "must be greater than zero")
+			continue
+		}
+
+		if item.Quantity <= 0 {
+			validation.add(field+".quantity", "must be greater than zero")
+			continue
+		}
+
+		if item.Tax < 0 {
+			validation.add(field+".tax", "must not be negative")
+			continue
+		}
+
+		if item.Amount > (math.MaxInt64-totals.Items)/int64(item.Quantity) {
+			validation.add(field+".amount", "is too large")
+			continue
+		}
+
+		if item.Tax > (math.MaxInt64-totals.Tax)/int64(item.Quantity) {
+			validation.add(field+".tax", "is too large")
+			continue
+		}
+
+		totals.Items += item.Amount * int64(item.Quantity)
+		totals.Tax += item.Tax * int64(item.Quantity)
+	}
+
+	if payment.Shipping != nil {
+		shipping := payment.Shipping
+		if shipping.Amount < 0 {
+			validation.add("shipping.amount", "must not be negative")
+		}
+
+		if !shipping.Address.IsZero() {
+			if shipping.Name == "" {
+				validation.add("shipping.name", "is required with an address")
+			}
+			if shipping.Address.Line1 == "" {
+				validation.add("shipping.address.line1", "is required")
+			}
+			if shipping.Address.Country == "" {
+				validation.add("shipping.address.country", "is required")
+			}
+		}
+
+		totals.Shipping = shipping.Amount
+	}
+
+	if payment.Discount < 0 {
+		validation.add("discount", "must not be negative")
+	} else if payment.Discount > totals.Items {
+		validation.add("discount", "must not exceed the line item total")
+	}
+	totals.Discount = payment.Discount
+
+	if totals.Tax > math.MaxInt64-totals.Items || totals.Shipping > math.MaxInt64-totals.Items-totals.Tax {
+		validation.add("amount", "is too large")
+	}
+
+	totals.Total = totals.Items + totals.Tax + totals.Shipping - totals.Discount
+	return totals
+}
+
+func validatePayment(payment *processors.Payment) error {
+	validation := &ValidationError{}
+
+	if len(payment.LineItems) == 0 {
+		validation.add("lineItems", "must contain at least one item")
+	}
+
+	validateMetadata(payment.Metadata, validation)
+
+	totals := paymentTotals(*payment, validation)
+	if len(validation.Fields) > 0 {
+		return validation
+	}
+
+	if payment.Amount == 0 {
+		payment.Amount = totals.Total
+	}
+
+	if payment.Amount != totals.Total {
+		validation.add("amount", fmt.Sprintf("must equal the computed total of %d", totals.Total))
+		return validation
+	}
+
+	return nil
+}
+
+func validateMetadata(metadata map[string]string, validation *ValidationError) {
+	if len(metadata) > MaxMetadataKeys {
+		validation.add("metadata", fmt.Sprintf("must not have more than %d keys", MaxMetadataKeys))
+		return
+	}
+
+	keys := []string{}
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case key == "" || len(key) > MaxMetadataKeyLength:
+			validation.add("metadata", fmt.Sprintf("keys must have 1 to %d characters", MaxMetadataKeyLength))
+		case len(metadata[key]) > MaxMetadataValueLength:
+			validation.add("metadata."+key, fmt.Sprintf("must not exceed %d characters", MaxMetadataValueLength))
+		}
+	}
+}
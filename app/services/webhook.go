@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+var ErrWebhooksNotSupported = errors.New("processor does not send webhooks")
+
+// HandleWebhook applies a processor notification to the payment it is about,
+// whichever merchant owns it. mode tells the live processor accounts from the
+// sandbox ones.
+func (s *Services) HandleWebhook(ctx context.Context, mode string, processorName string, payload []byte, headers http.Header) error {
+	ctx = database.Unscoped(ctx)
+	registry, err := s.registry(mode)
+	if err != nil {
+		return err
+	}
+
+	connector, err := registry.Get(processorName)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrUnknownProcessor, processorName)
+	}
+
+	receiver, ok := processors.AsWebhookReceiver(connector)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrWebhooksNotSupported, processorName)
+	}
+
+	event, err := receiver.ParseWebhook(ctx, payload, headers)
+	if err != nil {
+		return err
+	}
+
+	if event.Type == "" || event.PrivateId == "" {
+		return nil
+	}
+
+	found, err := s.Database.FindByPrivateId(ctx, event.PrivateId)
+	if errors.Is(err, database.ErrPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if (found.Mode == database.ModeTest) != (mode == database.ModeTest) {
+		return nil
+	}
+
+	payment, err := s.applyEvent(ctx, found.Id, event)
+	if err != nil || event.Type != processors.EventPaymentApproved {
+		return err
+	}
+
+	return s.completeApproval(ctx, payment)
+}
+
+// applyEvent holds the same lock as captures and refunds, so a notification
+// about a refund that is still being recorded finds it once it is.
+func (s *Services) applyEvent(ctx context.Context, paymentId string, event *processors.WebhookEvent) (*database.Payment, error) {
+	unlock := s.locks.lock(paymentId)
+	defer unlock()
+
+	payment, err := s.Database.FindById(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case processors.EventPaymentApproved:
+		err = s.syncStatus(ctx, payment, processors.StatusApproved)
+	case processors.EventPaymentAuthorized:
+		err = s.syncStatus(ctx, payment, processors.StatusAuthorized)
+	case processors.EventPaymentVoided:
+		err = s.syncStatus(ctx, payment, processors.StatusVoided)
+	case processors.EventPaymentCaptured:
+		err = s.syncStatus(ctx, payment, processors.StatusCaptured)
+	case processors.EventPaymentFailed:
+		err = s.syncStatus(ctx, payment, processors.StatusFailed)
+	case processors.EventPaymentExpired:
+		err = s.syncStatus(ctx, payment, processors.StatusExpired)
+	case processors.EventPaymentRefunded:
+		err = s.applyRefunds(ctx, payment, event.Refunds)
+	}
+
+	return payment, err
+}
+
+// applyRefunds records the refunds a processor reports and the status
+// changes of the ones already recorded, then settles the payment status.
+func (s *Services) applyRefunds(ctx context.Context, payment *database.Payment, refunds []processors.RefundResponse) error {
+	var changed bool
+	for _, refund := range refunds {
+		stored := findRefund(payment, refund.Id)
+		if stored == nil {
+			err := s.Database.AttachRefund(ctx, payment.Id, refundRecord(payment, refund, 0))
+			if errors.Is(err, database.ErrDuplicateRefund) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			changed = true
+			continue
+		}
+
+		if refund.Status == "" || refund.Status == stored.Status {
+			continue
+		}
+
+		if err := s.Database.UpdateRefundStatus(ctx, payment.Id, stored.Id, refund.Status); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	updated, err := s.Database.FindById(ctx, payment.Id)
+	if err != nil {
+		return err
+	}
+
+	return s.syncStatus(ctx, updated, settledStatus(updated))
+}
+
+func (s *Services) syncStatus(ctx context.Context, payment *database.Payment, status string) error {
+	if payment.Status == status && status != processors.StatusPartiallyRefunded {
+		return nil
+	}
+
+	err := s.transition(ctx, payment, status)
+
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil
+	}
+
+	return err
+}
+
+// completeApproval authorizes approved manual payments and captures approved
+// automatic ones for connectors that leave the capture to us. Failures are
+// logged and the payment stays approved.
+func (s *Services) completeApproval(ctx context.Context, payment *database.Payment) error {
+	if payment.Status != processors.StatusApproved {
+		return nil
+	}
+
+	connector, _, err := s.connector(payment.Mode, payment.Processor)
+	if err != nil {
+		return err
+	}
+
+	handler, isOk := processors.AsApprovalHandler(connector)
+	if !isOk {
+		return nil
+	}
+
+	if payment.CaptureMethod == processors.CaptureManual {
+		unlock := s.locks.lock(payment.Id)
+		defer unlock()
+
+		payment, err := s.Database.FindById(ctx, payment.Id)
+		if err != nil || payment.Status != processors.StatusApproved {
+			return err
+		}
+
+		if err := handler.Authorize(ctx, payment.PrivateId); err != nil {
+			slog.Error("authorization failed", "payment", payment.Id, "detail", err)
+			return nil
+		}
+
+		return s.syncStatus(ctx, payment, processors.StatusAuthorized)
+	}
+
+	if !handler.CapturesOnApproval() {
+		return nil
+	}
+
+	_, err = s.CapturePayment(ctx, payment.Id, processors.CaptureRequest{IdempotencyKey: "auto-capture-" + payment.PrivateId})
+	if err != nil {
+		slog.Error("auto capture failed", "payment", payment.Id, "detail", err)
+	}
+
+	return nil
+}
+
+// findRefund also matches refunds recorded under several processor ids, as
+// PayPal refunds spanning more than one capture are.
+func findRefund(payment *database.Payment, refundId string) *database.Refund {
+	for index, refund := range payment.Refunds {
+		for _, id := range strings.Split(refund.Id, ",") {
+			if id == refundId {
+				return &payment.Refunds[index]
+			}
+		}
+	}
+
+	return nil
+}
@@ -1 +1,17 @@
 package database
+
+import "errors"
+
+var (
+	ErrPaymentNotFound        = errors.New("payment not exists")
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not exists")
+	ErrInvalidCursor          = errors.New("invalid cursor")
+	ErrDuplicateReference     = errors.New("reference id already used")
+	ErrDuplicateRefund        = errors.New("refund already recorded")
+	ErrRefundNotFound         = errors.New("refund not exists")
+	ErrSubscriptionNotFound   = errors.New("webhook subscription not exists")
+	ErrEventNotFound          = errors.New("webhook event not exists")
+	ErrDeliveryNotFound       = errors.New("webhook delivery not exists")
+	ErrMerchantNotFound       = errors.New("merchant not exists")
+	ErrApiKeyNotFound         = errors.New("api key not exists")
+)
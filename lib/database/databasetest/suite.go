@@ -0,0 +1,1060 @@
+package databasetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/lib/database"
+)
+
+type Factory func(t *testing.T) database.Database
+
+func Run(t *testing.T, factory Factory) {
+	t.Run("SaveAndFindById", func(t *testing.T) { testSaveAndFindById(t, factory(t)) })
+	t.Run("Breakdown", func(t *testing.T) { testBreakdown(t, factory(t)) })
+	t.Run("FindByPrivateId", func(t *testing.T) { testFindByPrivateId(t, factory(t)) })
+	t.Run("Reference", func(t *testing.T) { testReference(t, factory(t)) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, factory(t)) })
+	t.Run("UpdateStatus", func(t *testing.T) { testUpdateStatus(t, factory(t)) })
+	t.Run("RefundOrdering", func(t *testing.T) { testRefundOrdering(t, factory(t)) })
+	t.Run("RecordRefund", func(t *testing.T) { testRecordRefund(t, factory(t)) })
+	t.Run("DuplicateRefund", func(t *testing.T) { testDuplicateRefund(t, factory(t)) })
+	t.Run("UpdateRefundStatus", func(t *testing.T) { testUpdateRefundStatus(t, factory(t)) })
+	t.Run("RecordCapture", func(t *testing.T) { testRecordCapture(t, factory(t)) })
+	t.Run("ReadsAreCopies", func(t *testing.T) { testReadsAreCopies(t, factory(t)) })
+	t.Run("ConcurrentAccess", func(t *testing.T) { testConcurrentAccess(t, factory(t)) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, factory(t)) })
+	t.Run("WebhookDeliveries", func(t *testing.T) { testWebhookDeliveries(t, factory(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, factory(t)) })
+	t.Run("ListPagination", func(t *testing.T) { testListPagination(t, factory(t)) })
+	t.Run("MerchantScope", func(t *testing.T) { testMerchantScope(t, factory(t)) })
+	t.Run("ScopedIdempotencyKeys", func(t *testing.T) { testScopedIdempotencyKeys(t, factory(t)) })
+	t.Run("ScopedWebhooks", func(t *testing.T) { testScopedWebhooks(t, factory(t)) })
+	t.Run("ApiKeys", func(t *testing.T) { testApiKeys(t, factory(t)) })
+}
+
+func NewPayment(privateId string) database.Payment {
+	return database.Payment{
+		Currency:      "USD",
+		Amount:        2500,
+		Status:        "created",
+		RedirectUrl:   "https://merchant.test/success",
+		CancelUrl:     "https://merchant.test/cancel",
+		PrivateId:     privateId,
+		Processor:     "stripe",
+		CaptureMethod: "automatic",
+		LineItems: []database.LineItem{
+			{Name: "first", Amount: 1000, Quantity: 1},
+			{Name: "second", Amount: 1500, Quantity: 1},
+		},
+		Refunds:  []database.Refund{},
+		Captures: []database.Capture{},
+	}
+}
+
+func NewRefund(id string, amount int64) database.Refund {
+	return database.Refund{
+		Id:        id,
+		Amount:    amount,
+		Currency:  "USD",
+		Status:    "succeeded",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func sameRefund(stored database.Refund, expected database.Refund) bool {
+	return stored.Id == expected.Id &&
+		stored.Amount == expected.Amount &&
+		stored.Currency == expected.Currency &&
+		stored.Status == expected.Status &&
+		stored.Reason == expected.Reason &&
+		stored.CreatedAt.Equal(expected.CreatedAt)
+}
+
+func save(t *testing.T, db database.Database, payment database.Payment) string {
+	t.Helper()
+
+	id, err := db.Save(unscoped(), payment)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if id == "" {
+		t.Fatal("Save returned an empty id")
+	}
+
+	return id
+}
+
+// unscoped is the context of processor webhooks and the webhook dispatcher,
+// which see every merchant.
+func unscoped() context.Context {
+	return database.Unscoped(context.Background())
+}
+
+func find(t *testing.T, db database.Database, id string) *database.Payment {
+	t.Helper()
+
+	payment, err := db.FindById(unscoped(), id)
+	if err != nil {
+		t.Fatalf("FindById(%s): %v", id, err)
+	}
+
+	return payment
+}
+
+func testSaveAndFindById(t *testing.T, db database.Database) {
+	expected := NewPayment("private-save")
+	id := save(t, db, expected)
+
+	payment := find(t, db, id)
+	if payment.Id != id {
+		t.Errorf("Id = %q, want %q", payment.Id, id)
+	}
+
+	if payment.Currency != expected.Currency || payment.Amount != expected.Amount || payment.Status != expected.Status {
+		t.Errorf("stored payment = %+v, want %+v", payment, expected)
+	}
+
+	if payment.RedirectUrl != expected.RedirectUrl || payment.CancelUrl != expected.CancelUrl {
+		t.Errorf("urls = %q %q, want %q %q", payment.RedirectUrl, payment.CancelUrl, expected.RedirectUrl, expected.CancelUrl)
+	}
+
+	if payment.PrivateId != expected.PrivateId || payment.Processor != expected.Processor || payment.CaptureMethod != expected.CaptureMethod {
+		t.Errorf("processor = %q %q %q, want %q %q %q", payment.Processor, payment.PrivateId, payment.CaptureMethod,
+			expected.Processor, expected.PrivateId, expected.CaptureMethod)
+	}
+
+	if len(payment.LineItems) != len(expected.LineItems) {
+		t.Fatalf("line items = %d, want %d", len(payment.LineItems), len(expected.LineItems))
+	}
+
+	for index, item := range expected.LineItems {
+		if payment.LineItems[index] != item {
+			t.Errorf("line item %d = %+v, want %+v", index, payment.LineItems[index], item)
+		}
+	}
+
+	if payment.Refunds == nil || len(payment.Refunds) != 0 {
+		t.Errorf("refunds = %#v, want an empty list", payment.Refunds)
+	}
+
+	if len(payment.StatusHistory) != 1 || payment.StatusHistory[0].Status != expected.Status {
+		t.Errorf("status history = %+v, want the initial status only", payment.StatusHistory)
+	}
+
+	other := save(t, db, NewPayment("private-save-other"))
+	if other == id {
+		t.Errorf("Save returned the same id twice: %s", id)
+	}
+}
+
+func testBreakdown(t *testing.T, db database.Database) {
+	withoutShipping := find(t, db, save(t, db, NewPayment("private-no-shipping")))
+	if withoutShipping.Shipping != nil || withoutShipping.Discount != 0 {
+		t.Errorf("shipping = %+v discount = %d, want none", withoutShipping.Shipping, withoutShipping.Discount)
+	}
+
+	expected := NewPayment("private-breakdown")
+	expected.LineItems[0].Sku = "SKU-1"
+	expected.LineItems[0].Description = "first item"
+	expected.LineItems[0].Tax = 80
+	expected.Discount = 300
+	expected.Shipping = &database.Shipping{
+		Name:   "Jane Doe",
+		Amount: 500,
+		Address: database.Address{
+			Line1:      "1 Main St",
+			City:       "Springfield",
+			State:      "IL",
+			PostalCode: "62701",
+			Country:    "US",
+		},
+	}
+
+	payment := find(t, db, save(t, db, expected))
+	if payment.Discount != expected.Discount {
+		t.Errorf("discount = %d, want %d", payment.Discount, expected.Discount)
+	}
+
+	if payment.Shipping == nil || *payment.Shipping != *expected.Shipping {
+		t.Errorf("shipping = %+v, want %+v", payment.Shipping, expected.Shipping)
+	}
+
+	if payment.LineItems[0] != expected.LineItems[0] {
+		t.Errorf("line item = %+v, want %+v", payment.LineItems[0], expected.LineItems[0])
+	}
+}
+
+func testFindByPrivateId(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-lookup"))
+	save(t, db, NewPayment("private-lookup-other"))
+
+	payment, err := db.FindByPrivateId(unscoped(), "private-lookup")
+	if err != nil {
+		t.Fatalf("FindByPrivateId: %v", err)
+	}
+
+	if payment.Id != id {
+		t.Errorf("Id = %q, want %q", payment.Id, id)
+	}
+}
+
+func testReference(t *testing.T, db database.Database) {
+	expected := NewPayment("private-reference")
+	expected.ReferenceId = "order-1001"
+	expected.Description = "Order 1001"
+	expected.Customer = &database.Customer{Name: "Jane Doe", Email: "jane@example.com", Phone: "+15555550100"}
+	expected.Metadata = map[string]string{"cart": "c-42", "channel": "web"}
+	id := save(t, db, expected)
+
+	payment, err := db.FindByReference(unscoped(), "order-1001")
+	if err != nil {
+		t.Fatalf("FindByReference: %v", err)
+	}
+
+	if payment.Id != id || payment.ReferenceId != expected.ReferenceId || payment.Description != expected.Description {
+		t.Errorf("payment = %+v, want reference %q", payment, expected.ReferenceId)
+	}
+
+	if payment.Customer == nil || *payment.Customer != *expected.Customer {
+		t.Errorf("customer = %+v, want %+v", payment.Customer, expected.Customer)
+	}
+
+	if fmt.Sprint(payment.Metadata) != fmt.Sprint(expected.Metadata) {
+		t.Errorf("metadata = %v, want %v", payment.Metadata, expected.Metadata)
+	}
+
+	if _, err := db.Save(unscoped(), expected); !errors.Is(err, database.ErrDuplicateReference) {
+		t.Errorf("Save with a used reference = %v, want ErrDuplicateReference", err)
+	}
+
+	save(t, db, NewPayment("private-no-reference"))
+	save(t, db, NewPayment("private-no-reference-other"))
+
+	if _, err := db.FindByReference(unscoped(), ""); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("FindByReference(\"\") = %v, want ErrPaymentNotFound", err)
+	}
+
+	if _, err := db.FindByReference(unscoped(), "order-missing"); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("FindByReference(missing) = %v, want ErrPaymentNotFound", err)
+	}
+}
+
+func testNotFound(t *testing.T, db database.Database) {
+	missing := "missing-payment"
+
+	if _, err := db.FindById(unscoped(), missing); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("FindById error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if _, err := db.FindByPrivateId(unscoped(), missing); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("FindByPrivateId error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := db.UpdateStatus(unscoped(), missing, "captured"); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("UpdateStatus error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := db.AttachRefund(unscoped(), missing, NewRefund("re_1", 100)); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("AttachRefund error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := db.RecordRefund(unscoped(), missing, NewRefund("re_1", 100), "refunded"); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("RecordRefund error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := db.RecordCapture(unscoped(), missing, database.Capture{Id: "ch_1", Amount: 100}, "captured"); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("RecordCapture error = %v, want ErrPaymentNotFound", err)
+	}
+}
+
+func testUpdateStatus(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-status"))
+
+	for _, status := range []string{"approved", "captured"} {
+		if err := db.UpdateStatus(unscoped(), id, status); err != nil {
+			t.Fatalf("UpdateStatus(%s): %v", status, err)
+		}
+	}
+
+	payment := find(t, db, id)
+	if payment.Status != "captured" {
+		t.Errorf("Status = %q, want captured", payment.Status)
+	}
+
+	expected := []string{"created", "approved", "captured"}
+	if len(payment.StatusHistory) != len(expected) {
+		t.Fatalf("status history = %+v, want %v", payment.StatusHistory, expected)
+	}
+
+	for index, status := range expected {
+		change := payment.StatusHistory[index]
+		if change.Status != status {
+			t.Errorf("status history %d = %q, want %q", index, change.Status, status)
+		}
+
+		if change.ChangedAt.IsZero() {
+			t.Errorf("status history %d has no timestamp", index)
+		}
+
+		if index > 0 && change.ChangedAt.Before(payment.StatusHistory[index-1].ChangedAt) {
+			t.Errorf("status history %d is older than its predecessor", index)
+		}
+	}
+}
+
+func testRefundOrdering(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-refunds"))
+
+	var expected []database.Refund
+	for index := 0; index < 5; index++ {
+		refund := NewRefund(fmt.Sprintf("re_%d", index), int64(100*(index+1)))
+		expected = append(expected, refund)
+
+		if err := db.AttachRefund(unscoped(), id, refund); err != nil {
+			t.Fatalf("AttachRefund: %v", err)
+		}
+	}
+
+	payment := find(t, db, id)
+	if len(payment.Refunds) != len(expected) {
+		t.Fatalf("refunds = %+v, want %+v", payment.Refunds, expected)
+	}
+
+	for index, refund := range expected {
+		if !sameRefund(payment.Refunds[index], refund) {
+			t.Errorf("refund %d = %+v, want %+v", index, payment.Refunds[index], refund)
+		}
+	}
+}
+
+func testRecordRefund(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-record-refund"))
+
+	refund := NewRefund("re_record", 2500)
+	refund.Reason = "requested_by_customer"
+	if err := db.RecordRefund(unscoped(), id, refund, "refunded"); err != nil {
+		t.Fatalf("RecordRefund: %v", err)
+	}
+
+	payment := find(t, db, id)
+	if payment.Status != "refunded" {
+		t.Errorf("Status = %q, want refunded", payment.Status)
+	}
+
+	if len(payment.Refunds) != 1 || !sameRefund(payment.Refunds[0], refund) {
+		t.Errorf("refunds = %+v, want [%+v]", payment.Refunds, refund)
+	}
+
+	last := payment.StatusHistory[len(payment.StatusHistory)-1]
+	if last.Status != "refunded" {
+		t.Errorf("last status change = %q, want refunded", last.Status)
+	}
+}
+
+func testDuplicateRefund(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-duplicate-refund"))
+
+	if err := db.RecordRefund(unscoped(), id, NewRefund("re_duplicate", 1000), "partially_refunded"); err != nil {
+		t.Fatalf("RecordRefund: %v", err)
+	}
+
+	if err := db.AttachRefund(unscoped(), id, NewRefund("re_duplicate", 1000)); !errors.Is(err, database.ErrDuplicateRefund) {
+		t.Errorf("AttachRefund of a recorded refund = %v, want ErrDuplicateRefund", err)
+	}
+
+	if err := db.RecordRefund(unscoped(), id, NewRefund("re_duplicate", 1000), "refunded"); !errors.Is(err, database.ErrDuplicateRefund) {
+		t.Errorf("RecordRefund of a recorded refund = %v, want ErrDuplicateRefund", err)
+	}
+
+	payment := find(t, db, id)
+	if len(payment.Refunds) != 1 || payment.Status != "partially_refunded" {
+		t.Errorf("payment = %s with refunds %+v, want one refund, partially refunded", payment.Status, payment.Refunds)
+	}
+}
+
+func testUpdateRefundStatus(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-refund-status"))
+
+	pending := NewRefund("re_pending", 1000)
+	pending.Status = "pending"
+	if err := db.AttachRefund(unscoped(), id, pending); err != nil {
+		t.Fatalf("AttachRefund: %v", err)
+	}
+
+	if err := db.UpdateRefundStatus(unscoped(), id, "re_pending", "failed"); err != nil {
+		t.Fatalf("UpdateRefundStatus: %v", err)
+	}
+
+	payment := find(t, db, id)
+	if len(payment.Refunds) != 1 || payment.Refunds[0].Status != "failed" {
+		t.Errorf("refunds = %+v, want re_pending failed", payment.Refunds)
+	}
+
+	if err := db.UpdateRefundStatus(unscoped(), id, "re_missing", "failed"); !errors.Is(err, database.ErrRefundNotFound) {
+		t.Errorf("UpdateRefundStatus(missing) = %v, want ErrRefundNotFound", err)
+	}
+}
+
+func testRecordCapture(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-record-capture"))
+
+	capturedAt := time.Now().UTC().Truncate(time.Second)
+	expected := []database.Capture{
+		{Id: "ch_first", Amount: 1000, Final: false, CapturedAt: capturedAt},
+		{Id: "ch_second", Amount: 1500, Final: true, CapturedAt: capturedAt.Add(time.Second)},
+	}
+
+	for index, status := range []string{"partially_captured", "captured"} {
+		if err := db.RecordCapture(unscoped(), id, expected[index], status); err != nil {
+			t.Fatalf("RecordCapture(%s): %v", expected[index].Id, err)
+		}
+	}
+
+	payment := find(t, db, id)
+	if payment.Status != "captured" {
+		t.Errorf("Status = %q, want captured", payment.Status)
+	}
+
+	if len(payment.Captures) != len(expected) {
+		t.Fatalf("captures = %+v, want %+v", payment.Captures, expected)
+	}
+
+	for index, capture := range expected {
+		stored := payment.Captures[index]
+		if stored.Id != capture.Id || stored.Amount != capture.Amount || stored.Final != capture.Final || !stored.CapturedAt.Equal(capture.CapturedAt) {
+			t.Errorf("capture %d = %+v, want %+v", index, stored, capture)
+		}
+	}
+
+	history := payment.StatusHistory
+	if len(history) < 2 || history[len(history)-2].Status != "partially_captured" || history[len(history)-1].Status != "captured" {
+		t.Errorf("status history = %+v, want it to end with partially_captured, captured", history)
+	}
+}
+
+func testReadsAreCopies(t *testing.T, db database.Database) {
+	id := save(t, db, NewPayment("private-copies"))
+
+	payment := find(t, db, id)
+	payment.Status = "mutated"
+	payment.LineItems[0].Name = "mutated"
+	payment.Refunds = append(payment.Refunds, NewRefund("re_mutated", 1))
+	payment.Captures = append(payment.Captures, database.Capture{Id: "ch_mutated"})
+
+	stored := find(t, db, id)
+	if stored.Status == "mutated" || stored.LineItems[0].Name == "mutated" || len(stored.Refunds) != 0 || len(stored.Captures) != 0 {
+		t.Errorf("mutating a read payment changed the store: %+v", stored)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, db database.Database) {
+	const workers = 16
+	const refundsPerWorker = 5
+
+	id := save(t, db, NewPayment("private-concurrent"))
+
+	var wg sync.WaitGroup
+	ids := make(chan string, workers)
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			created, err := db.Save(unscoped(), NewPayment(fmt.Sprintf("private-concurrent-%d", worker)))
+			if err != nil {
+				t.Errorf("Save: %v", err)
+				return
+			}
+			ids <- created
+
+			for index := 0; index < refundsPerWorker; index++ {
+				refund := NewRefund(fmt.Sprintf("re_%d_%d", worker, index), 1)
+				if err := db.RecordRefund(unscoped(), id, refund, "partially_refunded"); err != nil {
+					t.Errorf("RecordRefund: %v", err)
+				}
+
+				if _, err := db.FindById(unscoped(), id); err != nil {
+					t.Errorf("FindById: %v", err)
+				}
+			}
+		}(worker)
+	}
+
+	wg.Wait()
+	close(ids)
+
+	seen := map[string]bool{}
+	for created := range ids {
+		if seen[created] {
+			t.Errorf("duplicate id %s", created)
+		}
+		seen[created] = true
+	}
+
+	payment := find(t, db, id)
+	if len(payment.Refunds) != workers*refundsPerWorker {
+		t.Errorf("refunds = %d, want %d", len(payment.Refunds), workers*refundsPerWorker)
+	}
+
+	if len(payment.StatusHistory) != workers*refundsPerWorker+1 {
+		t.Errorf("status history = %d, want %d", len(payment.StatusHistory), workers*refundsPerWorker+1)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, db database.Database) {
+	store, ok := db.(database.IdempotencyStore)
+	if !ok {
+		t.Skip("database does not implement IdempotencyStore")
+	}
+
+	later := time.Now().Add(time.Hour)
+	record, err := store.ReserveIdempotencyKey(unscoped(), "key-1", "fingerprint-1", later)
+	if err != nil || record != nil {
+		t.Fatalf("first Reserve = %+v, %v, want a new reservation", record, err)
+	}
+
+	record, err = store.ReserveIdempotencyKey(unscoped(), "key-1", "fingerprint-2", later)
+	if err != nil || record == nil {
+		t.Fatalf("second Reserve = %+v, %v, want the existing record", record, err)
+	}
+
+	if record.Fingerprint != "fingerprint-1" || record.Completed {
+		t.Errorf("pending record = %+v, want fingerprint-1 and not completed", record)
+	}
+
+	if err := store.CompleteIdempotencyKey(unscoped(), "key-1", 201, []byte(`{"data":1}`), later); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	record, err = store.ReserveIdempotencyKey(unscoped(), "key-1", "fingerprint-1", later)
+	if err != nil || record == nil {
+		t.Fatalf("Reserve after Complete = %+v, %v, want the completed record", record, err)
+	}
+
+	if !record.Completed || record.StatusCode != 201 || string(record.Body) != `{"data":1}` {
+		t.Errorf("completed record = %+v, want status 201 and the stored body", record)
+	}
+
+	if err := store.CompleteIdempotencyKey(unscoped(), "missing", 200, nil, later); !errors.Is(err, database.ErrIdempotencyKeyNotFound) {
+		t.Errorf("Complete(missing) error = %v, want ErrIdempotencyKeyNotFound", err)
+	}
+
+	if _, err := store.ReserveIdempotencyKey(unscoped(), "key-2", "fingerprint", later); err != nil {
+		t.Fatalf("Reserve key-2: %v", err)
+	}
+
+	if err := store.ReleaseIdempotencyKey(unscoped(), "key-2"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	record, err = store.ReserveIdempotencyKey(unscoped(), "key-2", "fingerprint", later)
+	if err != nil || record != nil {
+		t.Errorf("Reserve after Release = %+v, %v, want a new reservation", record, err)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	if _, err := store.ReserveIdempotencyKey(unscoped(), "key-3", "fingerprint-1", expired); err != nil {
+		t.Fatalf("Reserve key-3: %v", err)
+	}
+
+	record, err = store.ReserveIdempotencyKey(unscoped(), "key-3", "fingerprint-2", later)
+	if err != nil || record != nil {
+		t.Errorf("Reserve after the reservation expired = %+v, %v, want a new reservation", record, err)
+	}
+
+	if err := store.CompleteIdempotencyKey(unscoped(), "key-3", 200, nil, expired); err != nil {
+		t.Fatalf("Complete key-3: %v", err)
+	}
+
+	record, err = store.ReserveIdempotencyKey(unscoped(), "key-3", "fingerprint-3", later)
+	if err != nil || record != nil {
+		t.Errorf("Reserve after the response expired = %+v, %v, want a new reservation", record, err)
+	}
+}
+
+func list(t *testing.T, db database.Database, filter database.PaymentFilter) *database.PaymentPage {
+	t.Helper()
+
+	page, err := db.List(unscoped(), filter)
+	if err != nil {
+		t.Fatalf("List(%+v): %v", filter, err)
+	}
+
+	return page
+}
+
+func testListFilters(t *testing.T, db database.Database) {
+	start := time.Now().Add(-time.Second)
+
+	small := NewPayment("private-list-small")
+	small.Amount = 1000
+	smallId := save(t, db, small)
+
+	euro := NewPayment("private-list-euro")
+	euro.Currency = "EUR"
+	euro.Processor = "paypal"
+	euro.Metadata = map[string]string{"channel": "web", "cart": "c-1"}
+	euroId := save(t, db, euro)
+
+	capturedId := save(t, db, NewPayment("private-list-captured"))
+	if err := db.UpdateStatus(unscoped(), capturedId, "captured"); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		filter database.PaymentFilter
+		want   []string
+	}{
+		{"all", database.PaymentFilter{}, []string{capturedId, euroId, smallId}},
+		{"status", database.PaymentFilter{Status: "captured"}, []string{capturedId}},
+		{"processor", database.PaymentFilter{Processor: "paypal"}, []string{euroId}},
+		{"currency", database.PaymentFilter{Currency: "USD"}, []string{capturedId, smallId}},
+		{"min amount", database.PaymentFilter{MinAmount: 2000}, []string{capturedId, euroId}},
+		{"max amount", database.PaymentFilter{MaxAmount: 2000}, []string{smallId}},
+		{"created after", database.PaymentFilter{CreatedAfter: start}, []string{capturedId, euroId, smallId}},
+		{"created before", database.PaymentFilter{CreatedBefore: start}, []string{}},
+		{"oldest first", database.PaymentFilter{Order: database.SortOldest}, []string{smallId, euroId, capturedId}},
+		{"metadata", database.PaymentFilter{Metadata: map[string]string{"channel": "web", "cart": "c-1"}}, []string{euroId}},
+		{"metadata mismatch", database.PaymentFilter{Metadata: map[string]string{"channel": "store"}}, []string{}},
+	}
+
+	for _, tc := range cases {
+		page := list(t, db, tc.filter)
+
+		ids := []string{}
+		for _, payment := range page.Payments {
+			ids = append(ids, payment.Id)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: ids = %v, want %v", tc.name, ids, tc.want)
+		}
+
+		if page.NextCursor != "" {
+			t.Errorf("%s: next cursor = %q, want none on the last page", tc.name, page.NextCursor)
+		}
+	}
+
+	page := list(t, db, database.PaymentFilter{Status: "captured"})
+	if len(page.Payments) == 1 && (len(page.Payments[0].StatusHistory) != 2 || page.Payments[0].CreatedAt.IsZero()) {
+		t.Errorf("listed payment = %+v, want the full payment", page.Payments[0])
+	}
+}
+
+func testListPagination(t *testing.T, db database.Database) {
+	saved := map[string]bool{}
+	for i := 0; i < 7; i++ {
+		saved[save(t, db, NewPayment(fmt.Sprintf("private-page-%d", i)))] = true
+	}
+
+	for _, order := range []database.SortOrder{database.SortNewest, database.SortOldest} {
+		seen := map[string]bool{}
+		var previous *database.Payment
+		filter := database.PaymentFilter{Order: order, Limit: 3}
+
+		for pages := 0; ; pages++ {
+			if pages > len(saved) {
+				t.Fatalf("%s: pagination did not terminate", order)
+			}
+
+			page := list(t, db, filter)
+			if len(page.Payments) > filter.Limit {
+				t.Fatalf("%s: page of %d payments, want at most %d", order, len(page.Payments), filter.Limit)
+			}
+
+			for index := range page.Payments {
+				payment := &page.Payments[index]
+				if seen[payment.Id] {
+					t.Errorf("%s: payment %s listed twice", order, payment.Id)
+				}
+				seen[payment.Id] = true
+
+				if previous != nil {
+					isNewer := payment.CreatedAt.After(previous.CreatedAt)
+					isOlder := payment.CreatedAt.Before(previous.CreatedAt)
+					if (order == database.SortNewest && isNewer) || (order == database.SortOldest && isOlder) {
+						t.Errorf("%s: payment %s listed out of order", order, payment.Id)
+					}
+				}
+				previous = payment
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			filter.Cursor = page.NextCursor
+		}
+
+		if len(seen) != len(saved) {
+			t.Errorf("%s: listed %d payments, want %d", order, len(seen), len(saved))
+		}
+	}
+
+	if _, err := db.List(unscoped(), database.PaymentFilter{Cursor: "not a cursor"}); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("List with a bad cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, db database.Database) {
+	store, ok := db.(database.WebhookStore)
+	if !ok {
+		t.Skip("database does not implement WebhookStore")
+	}
+
+	ctx := unscoped()
+	capturedId, err := store.SaveSubscription(ctx, database.WebhookSubscription{
+		Url:    "https://merchant.test/hooks",
+		Events: []string{"payment.captured", "payment.refunded"},
+		Secret: "whsec_test",
+	})
+	if err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	createdId, err := store.SaveSubscription(ctx, database.WebhookSubscription{
+		Url:    "https://other.test/hooks",
+		Events: []string{"payment.created"},
+		Secret: "whsec_other",
+	})
+	if err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	subscription, err := store.FindSubscription(ctx, capturedId)
+	if err != nil || subscription.Secret != "whsec_test" || len(subscription.Events) != 2 {
+		t.Fatalf("FindSubscription = %+v, %v", subscription, err)
+	}
+
+	eventId, err := store.SaveEvent(ctx, database.WebhookEvent{Type: "payment.captured", PaymentId: "payment-1", Payload: []byte(`{"id":"payment-1"}`)})
+	if err != nil {
+		t.Fatalf("SaveEvent: %v", err)
+	}
+
+	event, err := store.FindEvent(ctx, eventId)
+	if err != nil || string(event.Payload) != `{"id":"payment-1"}` || event.Type != "payment.captured" {
+		t.Fatalf("FindEvent = %+v, %v", event, err)
+	}
+
+	now := time.Now()
+	claimed, err := store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimDeliveries: %v", err)
+	}
+
+	if len(claimed) != 1 || claimed[0].SubscriptionId != capturedId || claimed[0].EventId != eventId {
+		t.Fatalf("claimed = %+v, want one delivery to the captured subscription", claimed)
+	}
+
+	again, err := store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("second claim = %+v, %v, want the leased delivery skipped", again, err)
+	}
+
+	delivery := claimed[0]
+	delivery.Attempts = 8
+	delivery.Status = database.DeliveryDead
+	delivery.LastStatusCode = 500
+	delivery.LastError = "subscriber answered 500"
+	if err := store.UpdateDelivery(ctx, delivery); err != nil {
+		t.Fatalf("UpdateDelivery: %v", err)
+	}
+
+	dead, err := store.ListDeliveries(ctx, database.DeliveryDead)
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 8 || dead[0].LastError != delivery.LastError {
+		t.Fatalf("dead deliveries = %+v, %v", dead, err)
+	}
+
+	if err := store.ReplayEvent(ctx, eventId); err != nil {
+		t.Fatalf("ReplayEvent: %v", err)
+	}
+
+	replayed, err := store.ClaimDeliveries(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	if err != nil || len(replayed) != 1 || replayed[0].Attempts != 0 || replayed[0].Status != database.DeliveryPending {
+		t.Fatalf("replayed = %+v, %v, want the delivery pending again", replayed, err)
+	}
+
+	if err := store.ReplayEvent(ctx, "missing-event"); !errors.Is(err, database.ErrEventNotFound) {
+		t.Errorf("ReplayEvent(missing) = %v, want ErrEventNotFound", err)
+	}
+
+	if err := store.DeleteSubscription(ctx, capturedId); err != nil {
+		t.Fatalf("DeleteSubscription: %v", err)
+	}
+
+	if err := store.DeleteSubscription(ctx, capturedId); !errors.Is(err, database.ErrSubscriptionNotFound) {
+		t.Errorf("second DeleteSubscription = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	subscriptions, err := store.ListSubscriptions(ctx)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].Id != createdId {
+		t.Errorf("subscriptions = %+v, %v, want only %s", subscriptions, err, createdId)
+	}
+
+	remaining, err := store.ListDeliveries(ctx, "")
+	if err != nil || len(remaining) != 0 {
+		t.Errorf("deliveries = %+v, %v, want the deleted subscription's deliveries gone", remaining, err)
+	}
+}
+
+func testMerchantScope(t *testing.T, db database.Database) {
+	first := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-1", Mode: database.ModeLive})
+	second := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-2", Mode: database.ModeLive})
+	firstTest := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-1", Mode: database.ModeTest})
+
+	payment := NewPayment("private-scoped")
+	payment.ReferenceId = "order-1"
+	id, err := db.Save(first, payment)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	stored, err := db.FindById(first, id)
+	if err != nil || stored.MerchantId != "merchant-1" || stored.Mode != database.ModeLive {
+		t.Fatalf("FindById = %+v, %v, want the payment owned by merchant-1 in live mode", stored, err)
+	}
+
+	payment.PrivateId = "private-other"
+	if _, err := db.Save(second, payment); err != nil {
+		t.Fatalf("Save with the same reference for another merchant: %v", err)
+	}
+
+	payment.PrivateId = "private-test"
+	if _, err := db.Save(firstTest, payment); err != nil {
+		t.Fatalf("Save with the same reference in test mode: %v", err)
+	}
+
+	for name, ctx := range map[string]context.Context{"other merchant": second, "other mode": firstTest} {
+		if _, err := db.FindById(ctx, id); !errors.Is(err, database.ErrPaymentNotFound) {
+			t.Errorf("%s: FindById = %v, want ErrPaymentNotFound", name, err)
+		}
+
+		if _, err := db.FindByPrivateId(ctx, "private-scoped"); !errors.Is(err, database.ErrPaymentNotFound) {
+			t.Errorf("%s: FindByPrivateId = %v, want ErrPaymentNotFound", name, err)
+		}
+
+		if err := db.UpdateStatus(ctx, id, "failed"); !errors.Is(err, database.ErrPaymentNotFound) {
+			t.Errorf("%s: UpdateStatus = %v, want ErrPaymentNotFound", name, err)
+		}
+
+		if err := db.RecordRefund(ctx, id, NewRefund("re_scoped", 100), "partially_refunded"); !errors.Is(err, database.ErrPaymentNotFound) {
+			t.Errorf("%s: RecordRefund = %v, want ErrPaymentNotFound", name, err)
+		}
+
+		capture := database.Capture{Id: "cap_scoped", Amount: 100, CapturedAt: time.Now().UTC()}
+		if err := db.RecordCapture(ctx, id, capture, "captured"); !errors.Is(err, database.ErrPaymentNotFound) {
+			t.Errorf("%s: RecordCapture = %v, want ErrPaymentNotFound", name, err)
+		}
+
+		referenced, err := db.FindByReference(ctx, "order-1")
+		if err != nil || referenced.Id == id {
+			t.Errorf("%s: FindByReference = %+v, %v, want the payment of its own scope", name, referenced, err)
+		}
+	}
+
+	if _, err := db.Save(first, payment); !errors.Is(err, database.ErrDuplicateReference) {
+		t.Errorf("Save with a reused reference = %v, want ErrDuplicateReference", err)
+	}
+
+	unchanged := find(t, db, id)
+	if unchanged.Status != "created" || len(unchanged.Refunds) != 0 || len(unchanged.Captures) != 0 {
+		t.Errorf("payment = %+v, want it untouched by other scopes", unchanged)
+	}
+
+	page, err := db.List(second, database.PaymentFilter{})
+	if err != nil || len(page.Payments) != 1 || page.Payments[0].MerchantId != "merchant-2" {
+		t.Errorf("List = %+v, %v, want only the payment of merchant-2", page, err)
+	}
+
+	all, err := db.List(unscoped(), database.PaymentFilter{})
+	if err != nil || len(all.Payments) != 3 {
+		t.Errorf("unscoped List = %+v, %v, want every payment", all, err)
+	}
+
+	if _, err := db.FindById(context.Background(), id); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("FindById without a scope = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := db.UpdateStatus(context.Background(), id, "failed"); !errors.Is(err, database.ErrPaymentNotFound) {
+		t.Errorf("UpdateStatus without a scope = %v, want ErrPaymentNotFound", err)
+	}
+
+	none, err := db.List(context.Background(), database.PaymentFilter{})
+	if err != nil || len(none.Payments) != 0 {
+		t.Errorf("List without a scope = %+v, %v, want no payments", none, err)
+	}
+}
+
+func testScopedIdempotencyKeys(t *testing.T, db database.Database) {
+	store, ok := db.(database.IdempotencyStore)
+	if !ok {
+		t.Skip("database does not implement IdempotencyStore")
+	}
+
+	first := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-1", Mode: database.ModeLive})
+	second := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-2", Mode: database.ModeLive})
+	later := time.Now().Add(time.Hour)
+
+	if record, err := store.ReserveIdempotencyKey(first, "shared-key", "fingerprint-1", later); err != nil || record != nil {
+		t.Fatalf("Reserve = %+v, %v, want a new reservation", record, err)
+	}
+
+	if err := store.CompleteIdempotencyKey(first, "shared-key", 200, []byte(`{"data":1}`), later); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	if record, err := store.ReserveIdempotencyKey(second, "shared-key", "fingerprint-2", later); err != nil || record != nil {
+		t.Fatalf("Reserve for another merchant = %+v, %v, want a new reservation", record, err)
+	}
+
+	if err := store.ReleaseIdempotencyKey(second, "shared-key"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	record, err := store.ReserveIdempotencyKey(first, "shared-key", "fingerprint-1", later)
+	if err != nil || record == nil || !record.Completed {
+		t.Errorf("Reserve after another merchant released the key = %+v, %v, want the completed record", record, err)
+	}
+}
+
+func testScopedWebhooks(t *testing.T, db database.Database) {
+	store, ok := db.(database.WebhookStore)
+	if !ok {
+		t.Skip("database does not implement WebhookStore")
+	}
+
+	first := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-1", Mode: database.ModeLive})
+	second := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant-2", Mode: database.ModeLive})
+
+	firstId, err := store.SaveSubscription(first, database.WebhookSubscription{Url: "https://first.test/hooks", Events: []string{"payment.captured"}, Secret: "whsec_first"})
+	if err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	secondId, err := store.SaveSubscription(second, database.WebhookSubscription{Url: "https://second.test/hooks", Events: []string{"payment.captured"}, Secret: "whsec_second"})
+	if err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	if _, err := store.FindSubscription(second, firstId); !errors.Is(err, database.ErrSubscriptionNotFound) {
+		t.Errorf("FindSubscription from another merchant = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	if err := store.DeleteSubscription(second, firstId); !errors.Is(err, database.ErrSubscriptionNotFound) {
+		t.Errorf("DeleteSubscription from another merchant = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	subscriptions, err := store.ListSubscriptions(second)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].Id != secondId {
+		t.Errorf("ListSubscriptions = %+v, %v, want only %s", subscriptions, err, secondId)
+	}
+
+	eventId, err := store.SaveEvent(unscoped(), database.WebhookEvent{
+		Type:       "payment.captured",
+		PaymentId:  "payment-1",
+		Payload:    []byte(`{}`),
+		MerchantId: "merchant-1",
+		Mode:       database.ModeLive,
+	})
+	if err != nil {
+		t.Fatalf("SaveEvent: %v", err)
+	}
+
+	claimed, err := store.ClaimDeliveries(unscoped(), time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].SubscriptionId != firstId {
+		t.Fatalf("claimed = %+v, %v, want one delivery to %s", claimed, err, firstId)
+	}
+
+	if _, err := store.FindEvent(second, eventId); !errors.Is(err, database.ErrEventNotFound) {
+		t.Errorf("FindEvent from another merchant = %v, want ErrEventNotFound", err)
+	}
+
+	if err := store.ReplayEvent(second, eventId); !errors.Is(err, database.ErrEventNotFound) {
+		t.Errorf("ReplayEvent from another merchant = %v, want ErrEventNotFound", err)
+	}
+
+	if deliveries, err := store.ListDeliveries(second, ""); err != nil || len(deliveries) != 0 {
+		t.Errorf("ListDeliveries from another merchant = %+v, %v, want none", deliveries, err)
+	}
+
+	if deliveries, err := store.ListDeliveries(first, ""); err != nil || len(deliveries) != 1 {
+		t.Errorf("ListDeliveries = %+v, %v, want the merchant's delivery", deliveries, err)
+	}
+
+	if _, err := store.FindSubscription(context.Background(), firstId); !errors.Is(err, database.ErrSubscriptionNotFound) {
+		t.Errorf("FindSubscription without a scope = %v, want ErrSubscriptionNotFound", err)
+	}
+
+	if subscriptions, err := store.ListSubscriptions(context.Background()); err != nil || len(subscriptions) != 0 {
+		t.Errorf("ListSubscriptions without a scope = %+v, %v, want none", subscriptions, err)
+	}
+}
+
+func testApiKeys(t *testing.T, db database.Database) {
+	store, ok := db.(database.MerchantStore)
+	if !ok {
+		t.Skip("database does not implement MerchantStore")
+	}
+
+	ctx := unscoped()
+	merchantId, err := store.SaveMerchant(ctx, database.Merchant{Name: "Acme"})
+	if err != nil {
+		t.Fatalf("SaveMerchant: %v", err)
+	}
+
+	merchant, err := store.FindMerchant(ctx, merchantId)
+	if err != nil || merchant.Name != "Acme" {
+		t.Fatalf("FindMerchant = %+v, %v", merchant, err)
+	}
+
+	if _, err := store.SaveApiKey(ctx, database.ApiKey{MerchantId: "missing", Kind: database.KeySecret, Mode: database.ModeTest, Hash: "hash-0"}); !errors.Is(err, database.ErrMerchantNotFound) {
+		t.Errorf("SaveApiKey for a missing merchant = %v, want ErrMerchantNotFound", err)
+	}
+
+	keyId, err := store.SaveApiKey(ctx, database.ApiKey{MerchantId: merchantId, Kind: database.KeySecret, Mode: database.ModeTest, Hash: "hash-1", Hint: "sk_test_...abcd"})
+	if err != nil {
+		t.Fatalf("SaveApiKey: %v", err)
+	}
+
+	if _, err := store.SaveApiKey(ctx, database.ApiKey{MerchantId: merchantId, Kind: database.KeyPublishable, Mode: database.ModeLive, Hash: "hash-2"}); err != nil {
+		t.Fatalf("SaveApiKey: %v", err)
+	}
+
+	key, err := store.FindApiKey(ctx, "hash-1")
+	if err != nil || key.Id != keyId || key.MerchantId != merchantId || key.Kind != database.KeySecret || key.Mode != database.ModeTest || key.RevokedAt != nil {
+		t.Fatalf("FindApiKey = %+v, %v", key, err)
+	}
+
+	if _, err := store.FindApiKey(ctx, "hash-unknown"); !errors.Is(err, database.ErrApiKeyNotFound) {
+		t.Errorf("FindApiKey(unknown) = %v, want ErrApiKeyNotFound", err)
+	}
+
+	if err := store.RevokeApiKey(ctx, "other-merchant", keyId); !errors.Is(err, database.ErrApiKeyNotFound) {
+		t.Errorf("RevokeApiKey for another merchant = %v, want ErrApiKeyNotFound", err)
+	}
+
+	if err := store.RevokeApiKey(ctx, merchantId, keyId); err != nil {
+		t.Fatalf("RevokeApiKey: %v", err)
+	}
+
+	key, err = store.FindApiKey(ctx, "hash-1")
+	if err != nil || key.RevokedAt == nil {
+		t.Errorf("revoked key = %+v, %v, want RevokedAt set", key, err)
+	}
+
+	keys, err := store.ListApiKeys(ctx, merchantId)
+	if err != nil || len(keys) != 2 || keys[0].Id != keyId || keys[0].Hint != "sk_test_...abcd" {
+		t.Errorf("ListApiKeys = %+v, %v, want both keys oldest first", keys, err)
+	}
+
+	if _, err := store.ListApiKeys(ctx, "missing"); !errors.Is(err, database.ErrMerchantNotFound) {
+		t.Errorf("ListApiKeys(missing) = %v, want ErrMerchantNotFound", err)
+	}
+}
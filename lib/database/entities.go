@@ -1,37 +1,253 @@
 package database
 
+import (
+	"context"
+	"time"
+)
+
 type PartialRefund struct {
 	Amount int64 `json:"amount"`
 }
 
-type RefundResponse struct {
-	Id     string `json:"id"`
-	Amount string `json:"amount"`
+type Refund struct {
+	Id        string    `json:"id"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Capture struct {
+	Id         string    `json:"id"`
+	Amount     int64     `json:"amount"`
+	Final      bool      `json:"final"`
+	CapturedAt time.Time `json:"capturedAt"`
+}
+
+type StatusChange struct {
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changedAt"`
 }
 
 type LineItem struct {
-	Name     string `json:"name"`
-	Amount   int64  `json:"amount"`
-	Quantity int32  `json:"quantity"`
+	Name        string `json:"name"`
+	Amount      int64  `json:"amount"`
+	Quantity    int32  `json:"quantity"`
+	Sku         string `json:"sku"`
+	Description string `json:"description"`
+	Tax         int64  `json:"tax"`
+}
+
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postalCode"`
+	Country    string `json:"country"`
+}
+
+type Shipping struct {
+	Name    string  `json:"name"`
+	Amount  int64   `json:"amount"`
+	Address Address `json:"address"`
+}
+
+type Customer struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
 }
 
 type Payment struct {
-	Currency    string           `json:"currency"`
-	Amount      int64            `json:"amount"`
-	Status      string           `json:"status"`
-	RedirectUrl string           `json:"redirectUrl"`
-	CancelUrl   string           `json:"cancelUrl"`
-	PrivateId   string           `json:"privateId"`
-	LineItems   []LineItem       `json:"lineItems"`
-	Refunds     []RefundResponse `json:"refunds"`
-	Id          string           `json:"id"`
+	Currency      string            `json:"currency"`
+	Amount        int64             `json:"amount"`
+	Status        string            `json:"status"`
+	RedirectUrl   string            `json:"redirectUrl"`
+	CancelUrl     string            `json:"cancelUrl"`
+	PrivateId     string            `json:"privateId"`
+	LineItems     []LineItem        `json:"lineItems"`
+	Shipping      *Shipping         `json:"shipping"`
+	Discount      int64             `json:"discount"`
+	Refunds       []Refund          `json:"refunds"`
+	Captures      []Capture         `json:"captures"`
+	Id            string            `json:"id"`
+	Processor     string            `json:"processor"`
+	CaptureMethod string            `json:"captureMethod"`
+	ReferenceId   string            `json:"referenceId"`
+	Description   string            `json:"description"`
+	Customer      *Customer         `json:"customer"`
+	Metadata      map[string]string `json:"metadata"`
+	StatusHistory []StatusChange    `json:"statusHistory"`
+	MerchantId    string            `json:"merchantId"`
+	Mode          string            `json:"mode"`
+	CreatedAt     time.Time         `json:"createdAt"`
+}
+
+type SortOrder string
+
+const (
+	SortNewest SortOrder = "desc"
+	SortOldest SortOrder = "asc"
+)
+
+// PaymentFilter selects payments for List. Zero values do not filter,
+// CreatedAfter is inclusive, CreatedBefore is exclusive and Cursor continues
+// a previous page in the same Order.
+type PaymentFilter struct {
+	Status        string
+	Processor     string
+	Currency      string
+	MinAmount     int64
+	MaxAmount     int64
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Metadata      map[string]string
+	Order         SortOrder
+	Cursor        string
+	Limit         int
+}
+
+type PaymentPage struct {
+	Payments   []Payment `json:"data"`
+	NextCursor string    `json:"nextCursor"`
 }
 
 type Database interface {
-	Save(payment Payment) string
-	FindById(id string) (*Payment, error)
-	UpdateStatus(id string, status string) error
-	AttachRefund(paymentId string, refund RefundResponse) error
+	Save(ctx context.Context, payment Payment) (string, error)
+	FindById(ctx context.Context, id string) (*Payment, error)
+	FindByPrivateId(ctx context.Context, privateId string) (*Payment, error)
+	FindByReference(ctx context.Context, referenceId string) (*Payment, error)
+	UpdateStatus(ctx context.Context, id string, status string) error
+	AttachRefund(ctx context.Context, paymentId string, refund Refund) error
+	RecordRefund(ctx context.Context, paymentId string, refund Refund, status string) error
+	UpdateRefundStatus(ctx context.Context, paymentId string, refundId string, status string) error
+	RecordCapture(ctx context.Context, paymentId string, capture Capture, status string) error
+	List(ctx context.Context, filter PaymentFilter) (*PaymentPage, error)
+}
+
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	Body        []byte
+	Completed   bool
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps reservations and stored responses until their
+// expiry; expired keys are pruned on the next reservation and can be used
+// again.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, expiresAt time.Time) (*IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte, expiresAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+type WebhookSubscription struct {
+	Id         string    `json:"id"`
+	Url        string    `json:"url"`
+	Events     []string  `json:"events"`
+	Secret     string    `json:"-"`
+	MerchantId string    `json:"merchantId"`
+	Mode       string    `json:"mode"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (subscription WebhookSubscription) Accepts(eventType string) bool {
+	for _, event := range subscription.Events {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookEvent struct {
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	PaymentId  string    `json:"paymentId"`
+	Payload    []byte    `json:"-"`
+	MerchantId string    `json:"merchantId"`
+	Mode       string    `json:"mode"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	EventId        string    `json:"eventId"`
+	SubscriptionId string    `json:"subscriptionId"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt"`
+	LastStatusCode int       `json:"lastStatusCode"`
+	LastError      string    `json:"lastError"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type WebhookStore interface {
+	SaveSubscription(ctx context.Context, subscription WebhookSubscription) (string, error)
+	FindSubscription(ctx context.Context, id string) (*WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	// SaveEvent stores the event and queues a delivery to every subscription
+	// of the same merchant and mode listening to its type.
+	SaveEvent(ctx context.Context, event WebhookEvent) (string, error)
+	FindEvent(ctx context.Context, id string) (*WebhookEvent, error)
+	// ClaimDeliveries returns pending deliveries due at now and moves their
+	// next attempt past the lease, so concurrent workers do not send them twice.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error
+	ListDeliveries(ctx context.Context, status string) ([]WebhookDelivery, error)
+	// ReplayEvent queues every delivery of the event again.
+	ReplayEvent(ctx context.Context, eventId string) error
+}
+
+const (
+	ModeTest = "test"
+	ModeLive = "live"
+)
+
+const (
+	KeySecret      = "secret"
+	KeyPublishable = "publishable"
+)
+
+type Merchant struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ApiKey is stored by the SHA-256 hash of its value, which is only shown
+// when the key is issued. Hint keeps the prefix and last characters.
+type ApiKey struct {
+	Id         string     `json:"id"`
+	MerchantId string     `json:"merchantId"`
+	Kind       string     `json:"kind"`
+	Mode       string     `json:"mode"`
+	Hash       string     `json:"-"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+type MerchantStore interface {
+	SaveMerchant(ctx context.Context, merchant Merchant) (string, error)
+	FindMerchant(ctx context.Context, id string) (*Merchant, error)
+	SaveApiKey(ctx context.Context, key ApiKey) (string, error)
+	FindApiKey(ctx context.Context, hash string) (*ApiKey, error)
+	ListApiKeys(ctx context.Context, merchantId string) ([]ApiKey, error)
+	RevokeApiKey(ctx context.Context, merchantId string, id string) error
 }
 
 type PaymentDetail struct {
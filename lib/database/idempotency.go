@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (im *InMemory) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, expiresAt time.Time) (*IdempotencyRecord, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	now := time.Now()
+	for scoped, record := range im.idempotencyKeys {
+		if !now.Before(record.ExpiresAt) {
+			delete(im.idempotencyKeys, scoped)
+		}
+	}
+
+	record, found := im.idempotencyKeys[scopedKey(ctx, key)]
+	if found {
+		clone := *record
+		clone.Body = append([]byte{}, record.Body...)
+		return &clone, nil
+	}
+
+	im.idempotencyKeys[scopedKey(ctx, key)] = &IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+
+	return nil, nil
+}
+
+func (im *InMemory) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte, expiresAt time.Time) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	record, found := im.idempotencyKeys[scopedKey(ctx, key)]
+	if !found {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	record.StatusCode = statusCode
+	record.Body = append([]byte{}, body...)
+	record.Completed = true
+	record.ExpiresAt = expiresAt
+	return nil
+}
+
+func (im *InMemory) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	delete(im.idempotencyKeys, scopedKey(ctx, key))
+	return nil
+}
+
+func (st *sqlStore) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, expiresAt time.Time) (*IdempotencyRecord, error) {
+	now := time.Now().UTC()
+	if _, err := st.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now); err != nil {
+		return nil, err
+	}
+
+	merchantId, mode := owner(ctx, "", "")
+	result, err := st.db.ExecContext(ctx, `INSERT INTO idempotency_keys (merchant_id, mode, key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (merchant_id, mode, key) DO NOTHING`, merchantId, mode, key, fingerprint, now, expiresAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted == 1 {
+		return nil, nil
+	}
+
+	var record IdempotencyRecord
+	err = st.db.QueryRowContext(ctx, "SELECT key, fingerprint, status_code, body, completed, created_at, expires_at FROM idempotency_keys WHERE merchant_id = $1 AND mode = $2 AND key = $3",
+		merchantId, mode, key).Scan(
+		&record.Key, &record.Fingerprint, &record.StatusCode, &record.Body, &record.Completed, &record.CreatedAt, &record.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st.ReserveIdempotencyKey(ctx, key, fingerprint, expiresAt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (st *sqlStore) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte, expiresAt time.Time) error {
+	merchantId, mode := owner(ctx, "", "")
+	result, err := st.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = $1, body = $2, completed = TRUE, expires_at = $3 WHERE merchant_id = $4 AND mode = $5 AND key = $6",
+		statusCode, body, expiresAt.UTC(), merchantId, mode, key)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+func (st *sqlStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	merchantId, mode := owner(ctx, "", "")
+	_, err := st.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE merchant_id = $1 AND mode = $2 AND key = $3", merchantId, mode, key)
+	return err
+}
+
+func scopedKey(ctx context.Context, key string) string {
+	merchantId, mode := owner(ctx, "", "")
+	return ownedKey(merchantId, mode, key)
+}
@@ -1,59 +1,280 @@
 package database
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type InMemory struct {
+	mu              sync.RWMutex
+	payments        map[string]*Payment
+	privateIds      map[string]string
+	references      map[string]string
+	idempotencyKeys map[string]*IdempotencyRecord
+	subscriptions   map[string]*WebhookSubscription
+	events          map[string]*WebhookEvent
+	deliveries      []*WebhookDelivery
+	merchants       map[string]*Merchant
+	apiKeys         map[string]*ApiKey
+}
+
+func NewInMemory() *InMemory {
+	return &InMemory{
+		payments:        map[string]*Payment{},
+		privateIds:      map[string]string{},
+		references:      map[string]string{},
+		idempotencyKeys: map[string]*IdempotencyRecord{},
+		subscriptions:   map[string]*WebhookSubscription{},
+		events:          map[string]*WebhookEvent{},
+		merchants:       map[string]*Merchant{},
+		apiKeys:         map[string]*ApiKey{},
+	}
 }
 
-var payments []Payment
+func (im *InMemory) Save(ctx context.Context, payment Payment) (string, error) {
+	now := time.Now().UTC()
+	stored := clonePayment(&payment)
+	stored.Id = uuid.NewString()
+	stored.CreatedAt = now
+	stored.StatusHistory = []StatusChange{{Status: payment.Status, ChangedAt: now}}
+	stored.MerchantId, stored.Mode = owner(ctx, payment.MerchantId, payment.Mode)
+	reference := ownedKey(stored.MerchantId, stored.Mode, stored.ReferenceId)
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
 
-func (im InMemory) Save(payment Payment) string {
-	paymentId := fmt.Sprint(time.Now().UnixNano())
-	payment.Id = paymentId
-	payments = append(payments, payment)
+	if stored.ReferenceId != "" && im.references[reference] != "" {
+		return "", ErrDuplicateReference
+	}
 
-	return paymentId
+	im.payments[stored.Id] = stored
+	if stored.PrivateId != "" {
+		im.privateIds[stored.PrivateId] = stored.Id
+	}
+	if stored.ReferenceId != "" {
+		im.references[reference] = stored.Id
+	}
+
+	return stored.Id, nil
 }
 
-func (im InMemory) FindById(id string) (*Payment, error) {
-	var payment *Payment
+func (im *InMemory) FindById(ctx context.Context, id string) (*Payment, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
 
-	for _, p := range payments {
-		match := id == p.Id
-		if match {
-			payment = &p
-		}
+	payment, err := im.find(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	if payment == nil {
-		return nil, errors.New("payment not exists")
+	return clonePayment(payment), nil
+}
+
+func (im *InMemory) FindByPrivateId(ctx context.Context, privateId string) (*Payment, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	payment, err := im.find(ctx, im.privateIds[privateId])
+	if err != nil {
+		return nil, err
 	}
 
-	return payment, nil
+	return clonePayment(payment), nil
 }
 
-func (im InMemory) UpdateStatus(id string, status string) error {
-	for index, p := range payments {
-		match := id == p.Id
-		if match {
-			payments[index].Status = status
-		}
+func (im *InMemory) FindByReference(ctx context.Context, referenceId string) (*Payment, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	merchantId, mode := owner(ctx, "", "")
+	payment, err := im.find(ctx, im.references[ownedKey(merchantId, mode, referenceId)])
+	if err != nil {
+		return nil, err
+	}
+
+	return clonePayment(payment), nil
+}
+
+func (im *InMemory) UpdateStatus(ctx context.Context, id string, status string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	payment, err := im.find(ctx, id)
+	if err != nil {
+		return err
 	}
 
+	setStatus(payment, status)
 	return nil
 }
 
-func (im InMemory) AttachRefund(paymentId string, refund RefundResponse) error {
-	for index, p := range payments {
-		match := paymentId == p.Id
-		if match {
-			payments[index].Refunds = append(payments[index].Refunds, refund)
+func (im *InMemory) AttachRefund(ctx context.Context, paymentId string, refund Refund) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	payment, err := im.find(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	if hasRefund(payment, refund.Id) {
+		return ErrDuplicateRefund
+	}
+
+	payment.Refunds = append(payment.Refunds, refund)
+	return nil
+}
+
+func (im *InMemory) UpdateRefundStatus(ctx context.Context, paymentId string, refundId string, status string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	payment, err := im.find(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	for index := range payment.Refunds {
+		if payment.Refunds[index].Id == refundId {
+			payment.Refunds[index].Status = status
+			return nil
 		}
 	}
 
+	return ErrRefundNotFound
+}
+
+func (im *InMemory) RecordRefund(ctx context.Context, paymentId string, refund Refund, status string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	payment, err := im.find(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	if hasRefund(payment, refund.Id) {
+		return ErrDuplicateRefund
+	}
+
+	payment.Refunds = append(payment.Refunds, refund)
+	setStatus(payment, status)
+	return nil
+}
+
+func (im *InMemory) RecordCapture(ctx context.Context, paymentId string, capture Capture, status string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	payment, err := im.find(ctx, paymentId)
+	if err != nil {
+		return err
+	}
+
+	payment.Captures = append(payment.Captures, capture)
+	setStatus(payment, status)
 	return nil
 }
+
+func (im *InMemory) List(ctx context.Context, filter PaymentFilter) (*PaymentPage, error) {
+	cursor, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	order := filter.order()
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	matched := []*Payment{}
+	for _, payment := range im.payments {
+		if !inScope(ctx, payment.MerchantId, payment.Mode) || !filter.matches(payment) {
+			continue
+		}
+
+		if cursor != nil && !before(*cursor, positionOf(payment), order) {
+			continue
+		}
+
+		matched = append(matched, payment)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return before(positionOf(matched[i]), positionOf(matched[j]), order)
+	})
+
+	page := &PaymentPage{Payments: []Payment{}}
+	for _, payment := range matched {
+		if len(page.Payments) == filter.pageSize() {
+			page.NextCursor = encodeCursor(&page.Payments[len(page.Payments)-1])
+			break
+		}
+
+		page.Payments = append(page.Payments, *clonePayment(payment))
+	}
+
+	return page, nil
+}
+
+// find must be called with im.mu held.
+func (im *InMemory) find(ctx context.Context, id string) (*Payment, error) {
+	payment, found := im.payments[id]
+	if !found || !inScope(ctx, payment.MerchantId, payment.Mode) {
+		return nil, ErrPaymentNotFound
+	}
+
+	return payment, nil
+}
+
+func ownedKey(merchantId string, mode string, key string) string {
+	return merchantId + "/" + mode + "/" + key
+}
+
+func setStatus(payment *Payment, status string) {
+	payment.Status = status
+	payment.StatusHistory = append(payment.StatusHistory, StatusChange{
+		Status:    status,
+		ChangedAt: time.Now(),
+	})
+}
+
+func clonePayment(payment *Payment) *Payment {
+	clone := *payment
+	clone.LineItems = append([]LineItem{}, payment.LineItems...)
+	if payment.Shipping != nil {
+		shipping := *payment.Shipping
+		clone.Shipping = &shipping
+	}
+	if payment.Customer != nil {
+		customer := *payment.Customer
+		clone.Customer = &customer
+	}
+	clone.Metadata = map[string]string{}
+	for key, value := range payment.Metadata {
+		clone.Metadata[key] = value
+	}
+	clone.Refunds = append([]Refund{}, payment.Refunds...)
+	clone.Captures = append([]Capture{}, payment.Captures...)
+	clone.StatusHistory = append([]StatusChange{}, payment.StatusHistory...)
+
+	return &clone
+}
+
+func hasRefund(payment *Payment, refundId string) bool {
+	if refundId == "" {
+		return false
+	}
+
+	for _, refund := range payment.Refunds {
+		if refund.Id == refundId {
+			return true
+		}
+	}
+
+	return false
+}
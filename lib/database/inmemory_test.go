@@ -0,0 +1,14 @@
+package database_test
+
+import (
+	"testing"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/database/databasetest"
+)
+
+func TestInMemory(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		return database.NewInMemory()
+	})
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// pageCursor is the position of the last payment of a page. Payments are
+// ordered by creation time and then by id, so the position is stable even
+// when several payments share a timestamp.
+type pageCursor struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+func positionOf(payment *Payment) pageCursor {
+	return pageCursor{CreatedAt: payment.CreatedAt, Id: payment.Id}
+}
+
+func encodeCursor(payment *Payment) string {
+	raw := strconv.FormatInt(payment.CreatedAt.UnixNano(), 10) + "|" + payment.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*pageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &pageCursor{CreatedAt: time.Unix(0, unixNano).UTC(), Id: id}, nil
+}
+
+func (f PaymentFilter) pageSize() int {
+	if f.Limit <= 0 {
+		return DefaultPageSize
+	}
+
+	if f.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+
+	return f.Limit
+}
+
+func (f PaymentFilter) order() SortOrder {
+	if f.Order == SortOldest {
+		return SortOldest
+	}
+
+	return SortNewest
+}
+
+func (f PaymentFilter) matches(payment *Payment) bool {
+	switch {
+	case f.Status != "" && payment.Status != f.Status:
+		return false
+	case f.Processor != "" && payment.Processor != f.Processor:
+		return false
+	case f.Currency != "" && payment.Currency != f.Currency:
+		return false
+	case f.MinAmount > 0 && payment.Amount < f.MinAmount:
+		return false
+	case f.MaxAmount > 0 && payment.Amount > f.MaxAmount:
+		return false
+	case !f.CreatedAfter.IsZero() && payment.CreatedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !payment.CreatedAt.Before(f.CreatedBefore):
+		return false
+	}
+
+	for key, value := range f.Metadata {
+		stored, found := payment.Metadata[key]
+		if !found || stored != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// before reports whether position a comes before b in the given order.
+func before(a pageCursor, b pageCursor, order SortOrder) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		if order == SortOldest {
+			return a.Id < b.Id
+		}
+		return a.Id > b.Id
+	}
+
+	if order == SortOldest {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (im *InMemory) SaveMerchant(ctx context.Context, merchant Merchant) (string, error) {
+	stored := merchant
+	stored.Id = uuid.NewString()
+	stored.CreatedAt = time.Now().UTC()
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.merchants[stored.Id] = &stored
+	return stored.Id, nil
+}
+
+func (im *InMemory) FindMerchant(ctx context.Context, id string) (*Merchant, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	merchant, found := im.merchants[id]
+	if !found {
+		return nil, ErrMerchantNotFound
+	}
+
+	clone := *merchant
+	return &clone, nil
+}
+
+func (im *InMemory) SaveApiKey(ctx context.Context, key ApiKey) (string, error) {
+	stored := key
+	stored.Id = uuid.NewString()
+	stored.CreatedAt = time.Now().UTC()
+	stored.RevokedAt = nil
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, found := im.merchants[key.MerchantId]; !found {
+		return "", ErrMerchantNotFound
+	}
+
+	im.apiKeys[stored.Id] = &stored
+	return stored.Id, nil
+}
+
+func (im *InMemory) FindApiKey(ctx context.Context, hash string) (*ApiKey, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	for _, key := range im.apiKeys {
+		if key.Hash == hash {
+			return cloneApiKey(key), nil
+		}
+	}
+
+	return nil, ErrApiKeyNotFound
+}
+
+func (im *InMemory) ListApiKeys(ctx context.Context, merchantId string) ([]ApiKey, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	if _, found := im.merchants[merchantId]; !found {
+		return nil, ErrMerchantNotFound
+	}
+
+	keys := []ApiKey{}
+	for _, key := range im.apiKeys {
+		if key.MerchantId == merchantId {
+			keys = append(keys, *cloneApiKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (im *InMemory) RevokeApiKey(ctx context.Context, merchantId string, id string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	key, found := im.apiKeys[id]
+	if !found || key.MerchantId != merchantId {
+		return ErrApiKeyNotFound
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+	}
+
+	return nil
+}
+
+func cloneApiKey(key *ApiKey) *ApiKey {
+	clone := *key
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		clone.RevokedAt = &revokedAt
+	}
+
+	return &clone
+}
+
+func (st *sqlStore) SaveMerchant(ctx context.Context, merchant Merchant) (string, error) {
+	id := uuid.NewString()
+	_, err := st.db.ExecContext(ctx, "INSERT INTO merchants (id, name, created_at) VALUES ($1, $2, $3)", id, merchant.Name, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (st *sqlStore) FindMerchant(ctx context.Context, id string) (*Merchant, error) {
+	var merchant Merchant
+	err := st.db.QueryRowContext(ctx, "SELECT id, name, created_at FROM merchants WHERE id = $1", id).Scan(&merchant.Id, &merchant.Name, &merchant.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMerchantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.CreatedAt = merchant.CreatedAt.UTC()
+	return &merchant, nil
+}
+
+func (st *sqlStore) SaveApiKey(ctx context.Context, key ApiKey) (string, error) {
+	if _, err := st.FindMerchant(ctx, key.MerchantId); err != nil {
+		return "", err
+	}
+
+	id := uuid.NewString()
+	_, err := st.db.ExecContext(ctx, "INSERT INTO api_keys (id, merchant_id, kind, mode, hash, hint, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		id, key.MerchantId, key.Kind, key.Mode, key.Hash, key.Hint, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+const apiKeyColumns = "id, merchant_id, kind, mode, hash, hint, created_at, revoked_at"
+
+func scanApiKey(scan func(dest ...interface{}) error) (*ApiKey, error) {
+	var key ApiKey
+	var revokedAt sql.NullTime
+	if err := scan(&key.Id, &key.MerchantId, &key.Kind, &key.Mode, &key.Hash, &key.Hint, &key.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+
+	key.CreatedAt = key.CreatedAt.UTC()
+	if revokedAt.Valid {
+		revoked := revokedAt.Time.UTC()
+		key.RevokedAt = &revoked
+	}
+
+	return &key, nil
+}
+
+func (st *sqlStore) FindApiKey(ctx context.Context, hash string) (*ApiKey, error) {
+	key, err := scanApiKey(st.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = $1", hash).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrApiKeyNotFound
+	}
+
+	return key, err
+}
+
+func (st *sqlStore) ListApiKeys(ctx context.Context, merchantId string) ([]ApiKey, error) {
+	if _, err := st.FindMerchant(ctx, merchantId); err != nil {
+		return nil, err
+	}
+
+	rows, err := st.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE merchant_id = $1 ORDER BY created_at", merchantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (st *sqlStore) RevokeApiKey(ctx context.Context, merchantId string, id string) error {
+	result, err := st.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2 AND merchant_id = $3",
+		time.Now().UTC(), id, merchantId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrApiKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE captures (
+    seq         BIGSERIAL PRIMARY KEY,
+    payment_id  TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    id          TEXT NOT NULL,
+    amount      BIGINT NOT NULL,
+    final       BOOLEAN NOT NULL,
+    captured_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX captures_payment_id_idx ON captures (payment_id);
@@ -0,0 +1,9 @@
+ALTER TABLE refunds ALTER COLUMN amount TYPE BIGINT
+    USING CASE WHEN amount ~ '^[0-9]+$' THEN amount::BIGINT ELSE 0 END;
+
+ALTER TABLE refunds ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE refunds ADD COLUMN status TEXT NOT NULL DEFAULT 'succeeded';
+ALTER TABLE refunds ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE refunds ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE refunds SET currency = payments.currency FROM payments WHERE payments.id = refunds.payment_id;
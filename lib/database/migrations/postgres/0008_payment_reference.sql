@@ -0,0 +1,16 @@
+ALTER TABLE payments ADD COLUMN reference_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN customer_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN customer_email TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN customer_phone TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX payments_reference_id_idx ON payments (reference_id) WHERE reference_id <> '';
+
+CREATE TABLE payment_metadata (
+    payment_id TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    value      TEXT NOT NULL,
+    PRIMARY KEY (payment_id, name)
+);
+
+CREATE INDEX payment_metadata_name_value_idx ON payment_metadata (name, value);
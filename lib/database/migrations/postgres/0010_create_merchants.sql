@@ -0,0 +1,37 @@
+CREATE TABLE merchants (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE api_keys (
+    id          TEXT PRIMARY KEY,
+    merchant_id TEXT NOT NULL REFERENCES merchants (id) ON DELETE CASCADE,
+    kind        TEXT NOT NULL,
+    mode        TEXT NOT NULL,
+    hash        TEXT NOT NULL UNIQUE,
+    hint        TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_merchant_id_idx ON api_keys (merchant_id);
+
+ALTER TABLE payments ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+
+DROP INDEX payments_reference_id_idx;
+CREATE UNIQUE INDEX payments_reference_id_idx ON payments (merchant_id, mode, reference_id) WHERE reference_id <> '';
+CREATE INDEX payments_merchant_created_at_idx ON payments (merchant_id, mode, created_at, id);
+
+ALTER TABLE idempotency_keys ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (merchant_id, mode, key);
+
+ALTER TABLE webhook_subscriptions ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhook_subscriptions ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+CREATE INDEX webhook_subscriptions_merchant_id_idx ON webhook_subscriptions (merchant_id, mode);
+
+ALTER TABLE webhook_events ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhook_events ADD COLUMN mode TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys ADD COLUMN expires_at TIMESTAMPTZ NOT NULL DEFAULT 'epoch';
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
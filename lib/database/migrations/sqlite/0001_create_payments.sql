@@ -0,0 +1,40 @@
+CREATE TABLE payments (
+    id           TEXT PRIMARY KEY,
+    currency     TEXT NOT NULL,
+    amount       INTEGER NOT NULL,
+    status       TEXT NOT NULL,
+    redirect_url TEXT NOT NULL,
+    cancel_url   TEXT NOT NULL,
+    private_id   TEXT NOT NULL,
+    processor    TEXT NOT NULL,
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX payments_private_id_idx ON payments (private_id);
+
+CREATE TABLE line_items (
+    payment_id TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    position   INTEGER NOT NULL,
+    name       TEXT NOT NULL,
+    amount     INTEGER NOT NULL,
+    quantity   INTEGER NOT NULL,
+    PRIMARY KEY (payment_id, position)
+);
+
+CREATE TABLE refunds (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    payment_id TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    id         TEXT NOT NULL,
+    amount     TEXT NOT NULL
+);
+
+CREATE INDEX refunds_payment_id_idx ON refunds (payment_id);
+
+CREATE TABLE status_history (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    payment_id TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    status     TEXT NOT NULL,
+    changed_at DATETIME NOT NULL
+);
+
+CREATE INDEX status_history_payment_id_idx ON status_history (payment_id);
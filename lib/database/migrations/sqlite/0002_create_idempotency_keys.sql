@@ -0,0 +1,8 @@
+CREATE TABLE idempotency_keys (
+    key         TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    body        BLOB,
+    completed   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  DATETIME NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN capture_method TEXT NOT NULL DEFAULT 'automatic';
@@ -0,0 +1,10 @@
+CREATE TABLE captures (
+    seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+    payment_id  TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    id          TEXT NOT NULL,
+    amount      INTEGER NOT NULL,
+    final       BOOLEAN NOT NULL,
+    captured_at DATETIME NOT NULL
+);
+
+CREATE INDEX captures_payment_id_idx ON captures (payment_id);
@@ -0,0 +1,22 @@
+CREATE TABLE refunds_details (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    payment_id TEXT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    id         TEXT NOT NULL,
+    amount     INTEGER NOT NULL,
+    currency   TEXT NOT NULL DEFAULT '',
+    status     TEXT NOT NULL DEFAULT 'succeeded',
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO refunds_details (seq, payment_id, id, amount, currency)
+SELECT refunds.seq, refunds.payment_id, refunds.id,
+       CASE WHEN refunds.amount GLOB '[0-9]*' AND refunds.amount NOT GLOB '*[^0-9]*' THEN CAST(refunds.amount AS INTEGER) ELSE 0 END,
+       payments.currency
+FROM refunds JOIN payments ON payments.id = refunds.payment_id;
+
+DROP TABLE refunds;
+
+ALTER TABLE refunds_details RENAME TO refunds;
+
+CREATE INDEX refunds_payment_id_idx ON refunds (payment_id);
@@ -0,0 +1,13 @@
+ALTER TABLE line_items ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+ALTER TABLE line_items ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE line_items ADD COLUMN tax INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE payments ADD COLUMN discount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN shipping_amount INTEGER;
+ALTER TABLE payments ADD COLUMN shipping_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_line1 TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_line2 TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_city TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_state TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_postal_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN shipping_country TEXT NOT NULL DEFAULT '';
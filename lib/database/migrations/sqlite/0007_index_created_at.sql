@@ -0,0 +1 @@
+CREATE INDEX payments_created_at_idx ON payments (created_at, id);
@@ -0,0 +1,30 @@
+CREATE TABLE webhook_subscriptions (
+    id         TEXT PRIMARY KEY,
+    url        TEXT NOT NULL,
+    events     TEXT NOT NULL,
+    secret     TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE webhook_events (
+    id         TEXT PRIMARY KEY,
+    type       TEXT NOT NULL,
+    payment_id TEXT NOT NULL,
+    payload    BLOB NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id               TEXT PRIMARY KEY,
+    event_id         TEXT NOT NULL REFERENCES webhook_events (id) ON DELETE CASCADE,
+    subscription_id  TEXT NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    status           TEXT NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  DATETIME NOT NULL,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT NOT NULL DEFAULT '',
+    created_at       DATETIME NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX webhook_deliveries_event_id_idx ON webhook_deliveries (event_id);
@@ -0,0 +1,50 @@
+CREATE TABLE merchants (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE api_keys (
+    id          TEXT PRIMARY KEY,
+    merchant_id TEXT NOT NULL REFERENCES merchants (id) ON DELETE CASCADE,
+    kind        TEXT NOT NULL,
+    mode        TEXT NOT NULL,
+    hash        TEXT NOT NULL UNIQUE,
+    hint        TEXT NOT NULL,
+    created_at  DATETIME NOT NULL,
+    revoked_at  DATETIME
+);
+
+CREATE INDEX api_keys_merchant_id_idx ON api_keys (merchant_id);
+
+ALTER TABLE payments ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+
+DROP INDEX payments_reference_id_idx;
+CREATE UNIQUE INDEX payments_reference_id_idx ON payments (merchant_id, mode, reference_id) WHERE reference_id <> '';
+CREATE INDEX payments_merchant_created_at_idx ON payments (merchant_id, mode, created_at, id);
+
+CREATE TABLE scoped_idempotency_keys (
+    merchant_id TEXT NOT NULL DEFAULT '',
+    mode        TEXT NOT NULL DEFAULT '',
+    key         TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    body        BLOB,
+    completed   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  DATETIME NOT NULL,
+    PRIMARY KEY (merchant_id, mode, key)
+);
+
+INSERT INTO scoped_idempotency_keys (key, fingerprint, status_code, body, completed, created_at)
+    SELECT key, fingerprint, status_code, body, completed, created_at FROM idempotency_keys;
+
+DROP TABLE idempotency_keys;
+ALTER TABLE scoped_idempotency_keys RENAME TO idempotency_keys;
+
+ALTER TABLE webhook_subscriptions ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhook_subscriptions ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+CREATE INDEX webhook_subscriptions_merchant_id_idx ON webhook_subscriptions (merchant_id, mode);
+
+ALTER TABLE webhook_events ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE webhook_events ADD COLUMN mode TEXT NOT NULL DEFAULT '';
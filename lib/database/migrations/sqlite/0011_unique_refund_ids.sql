@@ -0,0 +1,3 @@
+DELETE FROM refunds WHERE id <> '' AND seq NOT IN (SELECT MIN(seq) FROM refunds GROUP BY payment_id, id);
+
+CREATE UNIQUE INDEX refunds_payment_id_id_idx ON refunds (payment_id, id) WHERE id <> '';
@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys ADD COLUMN expires_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
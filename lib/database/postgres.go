@@ -0,0 +1,30 @@
+package database
+
+import (
+	"database/sql"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type Postgres struct {
+	sqlStore
+}
+
+func NewPostgres(dsn string) (*Postgres, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(db, "postgres"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Postgres{sqlStore{db: db, dialect: "postgres"}}, nil
+}
@@ -0,0 +1,59 @@
+package database_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/database/databasetest"
+)
+
+var postgresSchemas int64
+
+// TestPostgres runs against the server at POSTGRES_TEST_URL, a URL-style DSN.
+// Every test gets its own schema, dropped when the test ends. CI always sets
+// it, so a missing URL there fails instead of skipping the Postgres paths.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_URL")
+	if dsn == "" && os.Getenv("CI") != "" {
+		t.Fatal("POSTGRES_TEST_URL is not set")
+	}
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		schema := fmt.Sprintf("payments_test_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&postgresSchemas, 1))
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("CREATE SCHEMA: %v", err)
+		}
+		t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		parsed, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("POSTGRES_TEST_URL: %v", err)
+		}
+		query := parsed.Query()
+		query.Set("search_path", schema)
+		parsed.RawQuery = query.Encode()
+
+		db, err := database.NewPostgres(parsed.String())
+		if err != nil {
+			t.Fatalf("NewPostgres: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return db
+	})
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"strconv"
+)
+
+// Scope is the merchant and mode a request is authenticated for. Stores only
+// read and write records owned by the scope of the context they are given.
+// Contexts without a scope see no records unless they are marked Unscoped.
+type Scope struct {
+	MerchantId string
+	Mode       string
+}
+
+type scopeKey struct{}
+
+type unscopedKey struct{}
+
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+func ScopeFrom(ctx context.Context) (Scope, bool) {
+	scope, isOk := ctx.Value(scopeKey{}).(Scope)
+	return scope, isOk
+}
+
+// Unscoped lets ctx see the records of every merchant, for processor
+// webhooks, the webhook dispatcher and admin routes. A scope set on ctx
+// still applies.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unscopedKey{}, true)
+}
+
+func isUnscoped(ctx context.Context) bool {
+	unscoped, _ := ctx.Value(unscopedKey{}).(bool)
+	return unscoped
+}
+
+func inScope(ctx context.Context, merchantId string, mode string) bool {
+	scope, isOk := ScopeFrom(ctx)
+	if !isOk {
+		return isUnscoped(ctx)
+	}
+
+	return scope.MerchantId == merchantId && scope.Mode == mode
+}
+
+// owner returns who owns a record created with ctx: its scope, or the given
+// merchant and mode when ctx is unscoped.
+func owner(ctx context.Context, merchantId string, mode string) (string, string) {
+	if scope, isOk := ScopeFrom(ctx); isOk {
+		return scope.MerchantId, scope.Mode
+	}
+
+	return merchantId, mode
+}
+
+// scopeCondition returns the SQL condition restricting a table to the scope
+// of ctx, with its parameters numbered after args.
+func scopeCondition(ctx context.Context, args ...interface{}) (string, []interface{}) {
+	scope, isOk := ScopeFrom(ctx)
+	if !isOk && isUnscoped(ctx) {
+		return "1 = 1", args
+	}
+	if !isOk {
+		return "1 = 0", args
+	}
+
+	next := len(args) + 1
+	condition := "merchant_id = $" + strconv.Itoa(next) + " AND mode = $" + strconv.Itoa(next+1)
+	return condition, append(args, scope.MerchantId, scope.Mode)
+}
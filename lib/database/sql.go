@@ -0,0 +1,544 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (st *sqlStore) Close() error {
+	return st.db.Close()
+}
+
+func (st *sqlStore) Save(ctx context.Context, payment Payment) (string, error) {
+	paymentId := uuid.NewString()
+	now := time.Now().UTC()
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var shippingAmount sql.NullInt64
+	var shipping Shipping
+	if payment.Shipping != nil {
+		shipping = *payment.Shipping
+		shippingAmount = sql.NullInt64{Int64: shipping.Amount, Valid: true}
+	}
+
+	var customer Customer
+	if payment.Customer != nil {
+		customer = *payment.Customer
+	}
+
+	merchantId, mode := owner(ctx, payment.MerchantId, payment.Mode)
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO payments (id, currency, amount, status, redirect_url, cancel_url, private_id, processor, capture_method, created_at,
+			discount, shipping_amount, shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_state, shipping_postal_code, shipping_country,
+			reference_id, description, customer_name, customer_email, customer_phone, merchant_id, mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
+		ON CONFLICT DO NOTHING`,
+		paymentId, payment.Currency, payment.Amount, payment.Status, payment.RedirectUrl,
+		payment.CancelUrl, payment.PrivateId, payment.Processor, payment.CaptureMethod, now,
+		payment.Discount, shippingAmount, shipping.Name, shipping.Address.Line1, shipping.Address.Line2,
+		shipping.Address.City, shipping.Address.State, shipping.Address.PostalCode, shipping.Address.Country,
+		payment.ReferenceId, payment.Description, customer.Name, customer.Email, customer.Phone, merchantId, mode)
+	if err != nil {
+		return "", err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if inserted == 0 {
+		return "", ErrDuplicateReference
+	}
+
+	for name, value := range payment.Metadata {
+		_, err := tx.ExecContext(ctx, "INSERT INTO payment_metadata (payment_id, name, value) VALUES ($1, $2, $3)", paymentId, name, value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for position, item := range payment.LineItems {
+		_, err := tx.ExecContext(ctx, `INSERT INTO line_items (payment_id, position, name, amount, quantity, sku, description, tax) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			paymentId, position, item.Name, item.Amount, item.Quantity, item.Sku, item.Description, item.Tax)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, refund := range payment.Refunds {
+		if err := insertRefund(ctx, tx, paymentId, refund); err != nil {
+			return "", err
+		}
+	}
+
+	for _, capture := range payment.Captures {
+		if err := insertCapture(ctx, tx, paymentId, capture); err != nil {
+			return "", err
+		}
+	}
+
+	if err := insertStatus(ctx, tx, paymentId, payment.Status, now); err != nil {
+		return "", err
+	}
+
+	return paymentId, tx.Commit()
+}
+
+const paymentColumns = `id, currency, amount, status, redirect_url, cancel_url, private_id, processor, capture_method, created_at,
+	discount, shipping_amount, shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_state, shipping_postal_code, shipping_country,
+	reference_id, description, customer_name, customer_email, customer_phone, merchant_id, mode`
+
+func (st *sqlStore) FindById(ctx context.Context, id string) (*Payment, error) {
+	condition, args := scopeCondition(ctx, id)
+	return st.findOne(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = $1 AND "+condition, args...)
+}
+
+func (st *sqlStore) FindByPrivateId(ctx context.Context, privateId string) (*Payment, error) {
+	condition, args := scopeCondition(ctx, privateId)
+	return st.findOne(ctx, "SELECT "+paymentColumns+" FROM payments WHERE private_id = $1 AND "+condition+" LIMIT 1", args...)
+}
+
+func (st *sqlStore) FindByReference(ctx context.Context, referenceId string) (*Payment, error) {
+	if referenceId == "" {
+		return nil, ErrPaymentNotFound
+	}
+
+	condition, args := scopeCondition(ctx, referenceId)
+	return st.findOne(ctx, "SELECT "+paymentColumns+" FROM payments WHERE reference_id = $1 AND "+condition+" LIMIT 1", args...)
+}
+
+func (st *sqlStore) UpdateStatus(ctx context.Context, id string, status string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateStatus(ctx, tx, id, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *sqlStore) AttachRefund(ctx context.Context, paymentId string, refund Refund) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := st.lockPayment(ctx, tx, paymentId); err != nil {
+		return err
+	}
+
+	if err := insertRefund(ctx, tx, paymentId, refund); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *sqlStore) RecordRefund(ctx context.Context, paymentId string, refund Refund, status string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := st.lockPayment(ctx, tx, paymentId); err != nil {
+		return err
+	}
+
+	if err := insertRefund(ctx, tx, paymentId, refund); err != nil {
+		return err
+	}
+
+	if err := updateStatus(ctx, tx, paymentId, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *sqlStore) UpdateRefundStatus(ctx context.Context, paymentId string, refundId string, status string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := st.lockPayment(ctx, tx, paymentId); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE refunds SET status = $1 WHERE payment_id = $2 AND id = $3", status, paymentId, refundId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrRefundNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (st *sqlStore) RecordCapture(ctx context.Context, paymentId string, capture Capture, status string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := st.lockPayment(ctx, tx, paymentId); err != nil {
+		return err
+	}
+
+	if err := insertCapture(ctx, tx, paymentId, capture); err != nil {
+		return err
+	}
+
+	if err := updateStatus(ctx, tx, paymentId, status); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *sqlStore) List(ctx context.Context, filter PaymentFilter) (*PaymentPage, error) {
+	cursor, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, args := scopeCondition(ctx)
+	conditions := []string{condition}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.Status != "" {
+		conditions = append(conditions, "status = "+param(filter.Status))
+	}
+	if filter.Processor != "" {
+		conditions = append(conditions, "processor = "+param(filter.Processor))
+	}
+	if filter.Currency != "" {
+		conditions = append(conditions, "currency = "+param(filter.Currency))
+	}
+	if filter.MinAmount > 0 {
+		conditions = append(conditions, "amount >= "+param(filter.MinAmount))
+	}
+	if filter.MaxAmount > 0 {
+		conditions = append(conditions, "amount <= "+param(filter.MaxAmount))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= "+param(filter.CreatedAfter.UTC()))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+param(filter.CreatedBefore.UTC()))
+	}
+	for name, value := range filter.Metadata {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM payment_metadata WHERE payment_id = payments.id AND name = "+param(name)+" AND value = "+param(value)+")")
+	}
+
+	order := filter.order()
+	direction, comparison := "DESC", "<"
+	if order == SortOldest {
+		direction, comparison = "ASC", ">"
+	}
+
+	if cursor != nil {
+		createdAt, id := param(cursor.CreatedAt), param(cursor.Id)
+		conditions = append(conditions, "(created_at "+comparison+" "+createdAt+" OR (created_at = "+createdAt+" AND id "+comparison+" "+id+"))")
+	}
+
+	query := "SELECT " + paymentColumns + " FROM payments WHERE " + strings.Join(conditions, " AND ")
+	query += " ORDER BY created_at " + direction + ", id " + direction + " LIMIT " + strconv.Itoa(filter.pageSize()+1)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &PaymentPage{Payments: []Payment{}}
+	hasMore := false
+	for rows.Next() {
+		if len(page.Payments) == filter.pageSize() {
+			hasMore = true
+			break
+		}
+
+		payment, err := scanPayment(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		page.Payments = append(page.Payments, *payment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	payments := make([]*Payment, len(page.Payments))
+	for index := range page.Payments {
+		payments[index] = &page.Payments[index]
+	}
+
+	if err := st.loadDetails(ctx, payments); err != nil {
+		return nil, err
+	}
+
+	if hasMore {
+		page.NextCursor = encodeCursor(&page.Payments[len(page.Payments)-1])
+	}
+
+	return page, nil
+}
+
+func (st *sqlStore) findOne(ctx context.Context, query string, args ...interface{}) (*Payment, error) {
+	payment, err := scanPayment(st.db.QueryRowContext(ctx, query, args...).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := st.loadDetails(ctx, []*Payment{payment}); err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+func scanPayment(scan func(dest ...interface{}) error) (*Payment, error) {
+	var payment Payment
+	var shippingAmount sql.NullInt64
+	var shipping Shipping
+	var customer Customer
+
+	err := scan(
+		&payment.Id, &payment.Currency, &payment.Amount, &payment.Status, &payment.RedirectUrl,
+		&payment.CancelUrl, &payment.PrivateId, &payment.Processor, &payment.CaptureMethod, &payment.CreatedAt,
+		&payment.Discount, &shippingAmount, &shipping.Name, &shipping.Address.Line1, &shipping.Address.Line2,
+		&shipping.Address.City, &shipping.Address.State, &shipping.Address.PostalCode, &shipping.Address.Country,
+		&payment.ReferenceId, &payment.Description, &customer.Name, &customer.Email, &customer.Phone,
+		&payment.MerchantId, &payment.Mode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.CreatedAt = payment.CreatedAt.UTC()
+	if shippingAmount.Valid {
+		shipping.Amount = shippingAmount.Int64
+		payment.Shipping = &shipping
+	}
+
+	if customer != (Customer{}) {
+		payment.Customer = &customer
+	}
+
+	payment.Metadata = map[string]string{}
+	payment.LineItems = []LineItem{}
+	payment.Refunds = []Refund{}
+	payment.Captures = []Capture{}
+
+	return &payment, nil
+}
+
+// loadDetails loads the metadata, line items, refunds, captures and status
+// history of payments with one query per table.
+func (st *sqlStore) loadDetails(ctx context.Context, payments []*Payment) error {
+	if len(payments) == 0 {
+		return nil
+	}
+
+	byId := map[string]*Payment{}
+	placeholders := make([]string, len(payments))
+	ids := make([]interface{}, len(payments))
+	for index, payment := range payments {
+		byId[payment.Id] = payment
+		placeholders[index] = "$" + strconv.Itoa(index+1)
+		ids[index] = payment.Id
+	}
+	in := "payment_id IN (" + strings.Join(placeholders, ", ") + ")"
+
+	err := st.each(ctx, "SELECT payment_id, name, value FROM payment_metadata WHERE "+in, ids, func(rows *sql.Rows) error {
+		var paymentId, name, value string
+		if err := rows.Scan(&paymentId, &name, &value); err != nil {
+			return err
+		}
+
+		byId[paymentId].Metadata[name] = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = st.each(ctx, "SELECT payment_id, name, amount, quantity, sku, description, tax FROM line_items WHERE "+in+" ORDER BY position", ids, func(rows *sql.Rows) error {
+		var paymentId string
+		var item LineItem
+		if err := rows.Scan(&paymentId, &item.Name, &item.Amount, &item.Quantity, &item.Sku, &item.Description, &item.Tax); err != nil {
+			return err
+		}
+
+		payment := byId[paymentId]
+		payment.LineItems = append(payment.LineItems, item)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = st.each(ctx, "SELECT payment_id, id, amount, currency, status, reason, created_at FROM refunds WHERE "+in+" ORDER BY seq", ids, func(rows *sql.Rows) error {
+		var paymentId string
+		var refund Refund
+		if err := rows.Scan(&paymentId, &refund.Id, &refund.Amount, &refund.Currency, &refund.Status, &refund.Reason, &refund.CreatedAt); err != nil {
+			return err
+		}
+
+		payment := byId[paymentId]
+		payment.Refunds = append(payment.Refunds, refund)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = st.each(ctx, "SELECT payment_id, id, amount, final, captured_at FROM captures WHERE "+in+" ORDER BY seq", ids, func(rows *sql.Rows) error {
+		var paymentId string
+		var capture Capture
+		if err := rows.Scan(&paymentId, &capture.Id, &capture.Amount, &capture.Final, &capture.CapturedAt); err != nil {
+			return err
+		}
+
+		payment := byId[paymentId]
+		payment.Captures = append(payment.Captures, capture)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return st.each(ctx, "SELECT payment_id, status, changed_at FROM status_history WHERE "+in+" ORDER BY seq", ids, func(rows *sql.Rows) error {
+		var paymentId string
+		var change StatusChange
+		if err := rows.Scan(&paymentId, &change.Status, &change.ChangedAt); err != nil {
+			return err
+		}
+
+		payment := byId[paymentId]
+		payment.StatusHistory = append(payment.StatusHistory, change)
+		return nil
+	})
+}
+
+func (st *sqlStore) each(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (st *sqlStore) lockPayment(ctx context.Context, tx *sql.Tx, paymentId string) error {
+	condition, args := scopeCondition(ctx, paymentId)
+	query := "SELECT id FROM payments WHERE id = $1 AND " + condition
+	if st.dialect == "postgres" {
+		query += " FOR UPDATE"
+	}
+
+	var id string
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPaymentNotFound
+	}
+
+	return err
+}
+
+func updateStatus(ctx context.Context, tx *sql.Tx, paymentId string, status string) error {
+	condition, args := scopeCondition(ctx, status, paymentId)
+	result, err := tx.ExecContext(ctx, "UPDATE payments SET status = $1 WHERE id = $2 AND "+condition, args...)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrPaymentNotFound
+	}
+
+	return insertStatus(ctx, tx, paymentId, status, time.Now())
+}
+
+func insertStatus(ctx context.Context, tx *sql.Tx, paymentId string, status string, changedAt time.Time) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO status_history (payment_id, status, changed_at) VALUES ($1, $2, $3)", paymentId, status, changedAt)
+	return err
+}
+
+func insertRefund(ctx context.Context, tx *sql.Tx, paymentId string, refund Refund) error {
+	result, err := tx.ExecContext(ctx, "INSERT INTO refunds (payment_id, id, amount, currency, status, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING",
+		paymentId, refund.Id, refund.Amount, refund.Currency, refund.Status, refund.Reason, refund.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if inserted == 0 {
+		return ErrDuplicateRefund
+	}
+
+	return nil
+}
+
+func insertCapture(ctx context.Context, tx *sql.Tx, paymentId string, capture Capture) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO captures (payment_id, id, amount, final, captured_at) VALUES ($1, $2, $3, $4, $5)",
+		paymentId, capture.Id, capture.Amount, capture.Final, capture.CapturedAt)
+	return err
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+type SQLite struct {
+	sqlStore
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", "journal_mode(WAL)")
+	pragmas.Add("_pragma", "busy_timeout(5000)")
+	pragmas.Add("_pragma", "foreign_keys(1)")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(db, "sqlite"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{sqlStore{db: db, dialect: "sqlite"}}, nil
+}
@@ -0,0 +1,21 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/database/databasetest"
+)
+
+func TestSQLite(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := database.NewSQLite(filepath.Join(t.TempDir(), "payments.db"))
+		if err != nil {
+			t.Fatalf("NewSQLite: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return db
+	})
+}
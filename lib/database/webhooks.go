@@ -0,0 +1,443 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (im *InMemory) SaveSubscription(ctx context.Context, subscription WebhookSubscription) (string, error) {
+	stored := subscription
+	stored.Id = uuid.NewString()
+	stored.Events = append([]string{}, subscription.Events...)
+	stored.MerchantId, stored.Mode = owner(ctx, subscription.MerchantId, subscription.Mode)
+	stored.CreatedAt = time.Now().UTC()
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.subscriptions[stored.Id] = &stored
+	return stored.Id, nil
+}
+
+func (im *InMemory) FindSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	subscription, found := im.subscriptions[id]
+	if !found || !inScope(ctx, subscription.MerchantId, subscription.Mode) {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	clone := *subscription
+	clone.Events = append([]string{}, subscription.Events...)
+	return &clone, nil
+}
+
+func (im *InMemory) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	subscriptions := []WebhookSubscription{}
+	for _, subscription := range im.subscriptions {
+		if !inScope(ctx, subscription.MerchantId, subscription.Mode) {
+			continue
+		}
+
+		clone := *subscription
+		clone.Events = append([]string{}, subscription.Events...)
+		subscriptions = append(subscriptions, clone)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+func (im *InMemory) DeleteSubscription(ctx context.Context, id string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	subscription, found := im.subscriptions[id]
+	if !found || !inScope(ctx, subscription.MerchantId, subscription.Mode) {
+		return ErrSubscriptionNotFound
+	}
+
+	delete(im.subscriptions, id)
+
+	deliveries := []*WebhookDelivery{}
+	for _, delivery := range im.deliveries {
+		if delivery.SubscriptionId != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	im.deliveries = deliveries
+
+	return nil
+}
+
+func (im *InMemory) SaveEvent(ctx context.Context, event WebhookEvent) (string, error) {
+	now := time.Now().UTC()
+	stored := event
+	stored.Id = uuid.NewString()
+	stored.Payload = append([]byte{}, event.Payload...)
+	stored.MerchantId, stored.Mode = owner(ctx, event.MerchantId, event.Mode)
+	stored.CreatedAt = now
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.events[stored.Id] = &stored
+	for _, subscription := range im.subscriptions {
+		isOwner := subscription.MerchantId == stored.MerchantId && subscription.Mode == stored.Mode
+		if !isOwner || !subscription.Accepts(stored.Type) {
+			continue
+		}
+
+		im.deliveries = append(im.deliveries, &WebhookDelivery{
+			Id:             uuid.NewString(),
+			EventId:        stored.Id,
+			SubscriptionId: subscription.Id,
+			Status:         DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+
+	return stored.Id, nil
+}
+
+func (im *InMemory) FindEvent(ctx context.Context, id string) (*WebhookEvent, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	event, found := im.events[id]
+	if !found || !inScope(ctx, event.MerchantId, event.Mode) {
+		return nil, ErrEventNotFound
+	}
+
+	clone := *event
+	clone.Payload = append([]byte{}, event.Payload...)
+	return &clone, nil
+}
+
+func (im *InMemory) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	due := []*WebhookDelivery{}
+	for _, delivery := range im.deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	claimed := []WebhookDelivery{}
+	for _, delivery := range due {
+		if len(claimed) == limit {
+			break
+		}
+
+		claimed = append(claimed, *delivery)
+		delivery.NextAttemptAt = now.Add(lease).UTC()
+	}
+
+	return claimed, nil
+}
+
+func (im *InMemory) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for index, stored := range im.deliveries {
+		if stored.Id == delivery.Id {
+			updated := delivery
+			updated.NextAttemptAt = delivery.NextAttemptAt.UTC()
+			im.deliveries[index] = &updated
+			return nil
+		}
+	}
+
+	return ErrDeliveryNotFound
+}
+
+func (im *InMemory) ListDeliveries(ctx context.Context, status string) ([]WebhookDelivery, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range im.deliveries {
+		subscription, found := im.subscriptions[delivery.SubscriptionId]
+		if !found || !inScope(ctx, subscription.MerchantId, subscription.Mode) {
+			continue
+		}
+
+		if status == "" || delivery.Status == status {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (im *InMemory) ReplayEvent(ctx context.Context, eventId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	event, found := im.events[eventId]
+	if !found || !inScope(ctx, event.MerchantId, event.Mode) {
+		return ErrEventNotFound
+	}
+
+	now := time.Now().UTC()
+	for _, delivery := range im.deliveries {
+		if delivery.EventId == eventId {
+			delivery.Status = DeliveryPending
+			delivery.Attempts = 0
+			delivery.NextAttemptAt = now
+		}
+	}
+
+	return nil
+}
+
+func (st *sqlStore) SaveSubscription(ctx context.Context, subscription WebhookSubscription) (string, error) {
+	id := uuid.NewString()
+	merchantId, mode := owner(ctx, subscription.MerchantId, subscription.Mode)
+	_, err := st.db.ExecContext(ctx, "INSERT INTO webhook_subscriptions (id, url, events, secret, merchant_id, mode, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		id, subscription.Url, strings.Join(subscription.Events, ","), subscription.Secret, merchantId, mode, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+const subscriptionColumns = "id, url, events, secret, merchant_id, mode, created_at"
+
+func scanSubscription(scan func(dest ...interface{}) error) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	var events string
+	err := scan(&subscription.Id, &subscription.Url, &events, &subscription.Secret, &subscription.MerchantId, &subscription.Mode, &subscription.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.Events = strings.Split(events, ",")
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	return &subscription, nil
+}
+
+func (st *sqlStore) FindSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	condition, args := scopeCondition(ctx, id)
+	row := st.db.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE id = $1 AND "+condition, args...)
+	subscription, err := scanSubscription(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	return subscription, err
+}
+
+func (st *sqlStore) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	condition, args := scopeCondition(ctx)
+	rows, err := st.db.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE "+condition+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (st *sqlStore) DeleteSubscription(ctx context.Context, id string) error {
+	condition, args := scopeCondition(ctx, id)
+	result, err := st.db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1 AND "+condition, args...)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+func (st *sqlStore) SaveEvent(ctx context.Context, event WebhookEvent) (string, error) {
+	merchantId, mode := owner(ctx, event.MerchantId, event.Mode)
+	subscriptions, err := st.ListSubscriptions(WithScope(ctx, Scope{MerchantId: merchantId, Mode: mode}))
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.NewString()
+	now := time.Now().UTC()
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO webhook_events (id, type, payment_id, payload, merchant_id, mode, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		id, event.Type, event.PaymentId, event.Payload, merchantId, mode, now)
+	if err != nil {
+		return "", err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.Accepts(event.Type) {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO webhook_deliveries (id, event_id, subscription_id, status, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`, uuid.NewString(), id, subscription.Id, DeliveryPending, now, now)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+func (st *sqlStore) FindEvent(ctx context.Context, id string) (*WebhookEvent, error) {
+	var event WebhookEvent
+	condition, args := scopeCondition(ctx, id)
+	err := st.db.QueryRowContext(ctx, "SELECT id, type, payment_id, payload, merchant_id, mode, created_at FROM webhook_events WHERE id = $1 AND "+condition, args...).Scan(
+		&event.Id, &event.Type, &event.PaymentId, &event.Payload, &event.MerchantId, &event.Mode, &event.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event.CreatedAt = event.CreatedAt.UTC()
+	return &event, nil
+}
+
+const deliveryColumns = "id, event_id, subscription_id, status, attempts, next_attempt_at, last_status_code, last_error, created_at"
+
+func (st *sqlStore) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= $2 ORDER BY next_attempt_at LIMIT $3"
+	if st.dialect == "postgres" {
+		query += " FOR UPDATE SKIP LOCKED"
+	}
+
+	deliveries, err := queryDeliveries(ctx, tx, query, DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delivery := range deliveries {
+		_, err := tx.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id = $2", now.Add(lease).UTC(), delivery.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deliveries, tx.Commit()
+}
+
+func (st *sqlStore) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	result, err := st.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt_at = $3, last_status_code = $4, last_error = $5
+		WHERE id = $6`, delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(), delivery.LastStatusCode, delivery.LastError, delivery.Id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (st *sqlStore) ListDeliveries(ctx context.Context, status string) ([]WebhookDelivery, error) {
+	condition, args := scopeCondition(ctx)
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE subscription_id IN (SELECT id FROM webhook_subscriptions WHERE " + condition + ")"
+	if status != "" {
+		args = append(args, status)
+		query += " AND status = $" + strconv.Itoa(len(args))
+	}
+
+	return queryDeliveries(ctx, st.db, query+" ORDER BY created_at", args...)
+}
+
+func (st *sqlStore) ReplayEvent(ctx context.Context, eventId string) error {
+	if _, err := st.FindEvent(ctx, eventId); err != nil {
+		return err
+	}
+
+	_, err := st.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = $2 WHERE event_id = $3",
+		DeliveryPending, time.Now().UTC(), eventId)
+	return err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryDeliveries(ctx context.Context, db queryer, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := rows.Scan(&delivery.Id, &delivery.EventId, &delivery.SubscriptionId, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
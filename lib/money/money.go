@@ -0,0 +1,105 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{
+		Amount:   amount,
+		Currency: strings.ToUpper(currency),
+	}
+}
+
+func Parse(value string, currency string) (Money, error) {
+	exponent := Exponent(currency)
+
+	digits := value
+	negative := strings.HasPrefix(digits, "-")
+	if negative {
+		digits = digits[1:]
+	}
+
+	whole, fraction, hasFraction := strings.Cut(digits, ".")
+	if whole == "" || (hasFraction && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	if len(fraction) > exponent {
+		if strings.Trim(fraction[exponent:], "0") != "" {
+			return Money{}, ErrInvalidAmount
+		}
+
+		fraction = fraction[:exponent]
+	}
+
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	// The sign is parsed with the digits so the most negative amount fits.
+	number := whole + fraction
+	if negative {
+		number = "-" + number
+	}
+
+	amount, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+
+	return New(amount, currency), nil
+}
+
+func (m Money) Exponent() int {
+	return Exponent(m.Currency)
+}
+
+func (m Money) Decimal() string {
+	exponent := m.Exponent()
+
+	sign := ""
+	units := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		units = uint64(-(m.Amount + 1)) + 1
+	}
+
+	digits := strconv.FormatUint(units, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	split := len(digits) - exponent
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+func (m Money) IsWhole() bool {
+	return m.Amount%int64(math.Pow10(m.Exponent())) == 0
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func isDigits(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return true
+}
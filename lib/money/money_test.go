@@ -0,0 +1,138 @@
+package money_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"payment-processor.gary94746/main/lib/money"
+)
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		currency string
+		amount   int64
+		decimal  string
+	}{
+		{"JPY", 1500, "1500"},
+		{"JPY", 0, "0"},
+		{"USD", 1099, "10.99"},
+		{"USD", 5, "0.05"},
+		{"USD", -5, "-0.05"},
+		{"eur", 100000, "1000.00"},
+		{"KWD", 12345, "12.345"},
+		{"KWD", 7, "0.007"},
+		{"CLF", 1, "0.0001"},
+		{"CLF", 123456, "12.3456"},
+	}
+
+	for _, c := range cases {
+		value := money.New(c.amount, c.currency)
+		if decimal := value.Decimal(); decimal != c.decimal {
+			t.Errorf("New(%d, %s).Decimal() = %q, want %q", c.amount, c.currency, decimal, c.decimal)
+		}
+
+		parsed, err := money.Parse(c.decimal, c.currency)
+		if err != nil {
+			t.Errorf("Parse(%q, %s): %v", c.decimal, c.currency, err)
+			continue
+		}
+
+		if parsed != value {
+			t.Errorf("Parse(%q, %s) = %+v, want %+v", c.decimal, c.currency, parsed, value)
+		}
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	accepted := []struct {
+		value    string
+		currency string
+		amount   int64
+	}{
+		{"10.5", "USD", 1050},
+		{"10.500", "USD", 1050},
+		{"1500.00", "JPY", 1500},
+		{"1.2", "KWD", 1200},
+		{"0.50000", "CLF", 5000},
+	}
+
+	for _, c := range accepted {
+		parsed, err := money.Parse(c.value, c.currency)
+		if err != nil || parsed.Amount != c.amount {
+			t.Errorf("Parse(%q, %s) = %+v, %v, want %d", c.value, c.currency, parsed, err, c.amount)
+		}
+	}
+
+	rejected := []struct {
+		value    string
+		currency string
+	}{
+		{"10.999", "USD"},
+		{"1500.5", "JPY"},
+		{"1.2345", "KWD"},
+		{"0.00001", "CLF"},
+		{"", "USD"},
+		{"10.", "USD"},
+		{".5", "USD"},
+		{"1e3", "USD"},
+		{"99999999999999999999", "USD"},
+	}
+
+	for _, c := range rejected {
+		if parsed, err := money.Parse(c.value, c.currency); !errors.Is(err, money.ErrInvalidAmount) {
+			t.Errorf("Parse(%q, %s) = %+v, %v, want ErrInvalidAmount", c.value, c.currency, parsed, err)
+		}
+	}
+}
+
+// exponentCurrencies has a currency for every exponent ISO 4217 uses.
+var exponentCurrencies = []string{"JPY", "USD", "KWD", "CLF"}
+
+func TestRoundTripProperty(t *testing.T) {
+	for _, currency := range exponentCurrencies {
+		exponent := money.Exponent(currency)
+
+		formatted := func(amount int64) bool {
+			value := money.New(amount, currency)
+			parsed, err := money.Parse(value.Decimal(), currency)
+			return err == nil && parsed == value
+		}
+
+		parsed := func(whole uint32, fraction uint16, negative bool, padding uint8) bool {
+			value := fmt.Sprint(whole)
+			if exponent > 0 {
+				digits := fmt.Sprintf("%0*d", exponent, int64(fraction)%int64(math.Pow10(exponent)))
+				value += "." + digits + strings.Repeat("0", int(padding%4))
+			}
+			if negative {
+				value = "-" + value
+			}
+
+			first, err := money.Parse(value, currency)
+			if err != nil {
+				return false
+			}
+
+			second, err := money.Parse(first.Decimal(), currency)
+			return err == nil && second == first
+		}
+
+		if err := quick.Check(formatted, nil); err != nil {
+			t.Errorf("%s: format then parse: %v", currency, err)
+		}
+
+		if err := quick.Check(parsed, nil); err != nil {
+			t.Errorf("%s: parse, format and parse: %v", currency, err)
+		}
+
+		for _, amount := range []int64{math.MinInt64, math.MaxInt64, -1, 0, 1} {
+			if !formatted(amount) {
+				t.Errorf("%s: %d does not survive format then parse", currency, amount)
+			}
+		}
+	}
+}
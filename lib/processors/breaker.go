@@ -0,0 +1,221 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var breakerStates = expvar.NewMap("processor_breaker_state")
+
+type BreakerSettings struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenRequests int
+}
+
+func BreakerSettingsFrom(credentials map[string]string) BreakerSettings {
+	settings := BreakerSettings{
+		FailureThreshold: 5,
+		OpenTimeout:      seconds(credentials["breaker_open_timeout"], 30*time.Second),
+		HalfOpenRequests: 1,
+	}
+
+	if failures, err := strconv.Atoi(credentials["breaker_failures"]); err == nil && failures > 0 {
+		settings.FailureThreshold = failures
+	}
+
+	if requests, err := strconv.Atoi(credentials["breaker_half_open_requests"]); err == nil && requests > 0 {
+		settings.HalfOpenRequests = requests
+	}
+
+	return settings
+}
+
+// Breaker is a circuit breaker around a PaymentConnector. It opens after
+// FailureThreshold consecutive outages, rejects calls for OpenTimeout and then
+// lets HalfOpenRequests trial calls through before closing again.
+type Breaker struct {
+	name      string
+	connector PaymentConnector
+	settings  BreakerSettings
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	trials    int
+	successes int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func NewBreaker(name string, connector PaymentConnector, settings BreakerSettings) *Breaker {
+	breaker := &Breaker{
+		name:      name,
+		connector: connector,
+		settings:  settings,
+		now:       time.Now,
+	}
+	breaker.setState(BreakerClosed)
+
+	return breaker
+}
+
+func (b *Breaker) Unwrap() PaymentConnector {
+	return b.connector
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		return BreakerHalfOpen
+	}
+
+	return b.state
+}
+
+func (b *Breaker) Available() bool {
+	return b.State() != BreakerOpen
+}
+
+func (b *Breaker) Init(settings PaymentSettings) error {
+	return b.connector.Init(settings)
+}
+
+func (b *Breaker) Create(ctx context.Context, payment Payment) (*PaymentDetail, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	detail, err := b.connector.Create(ctx, payment)
+	b.record(err)
+
+	return detail, err
+}
+
+func (b *Breaker) Capture(ctx context.Context, paymentId string, capture CaptureRequest) (*CaptureResponse, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	captured, err := b.connector.Capture(ctx, paymentId, capture)
+	b.record(err)
+
+	return captured, err
+}
+
+func (b *Breaker) Refund(ctx context.Context, paymentId string, refund PartialRefund) (*RefundResponse, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	refunded, err := b.connector.Refund(ctx, paymentId, refund)
+	b.record(err)
+
+	return refunded, err
+}
+
+func (b *Breaker) Void(ctx context.Context, paymentId string, void VoidRequest) (bool, error) {
+	if err := b.allow(); err != nil {
+		return false, err
+	}
+
+	voided, err := b.connector.Void(ctx, paymentId, void)
+	b.record(err)
+
+	return voided, err
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.setState(BreakerHalfOpen)
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		return b.openError()
+	case BreakerHalfOpen:
+		if b.trials >= b.settings.HalfOpenRequests {
+			return b.openError()
+		}
+		b.trials++
+	}
+
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		if b.state == BreakerHalfOpen && b.trials > 0 {
+			b.trials--
+		}
+		return
+	}
+
+	if isOutage(err) {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.settings.FailureThreshold {
+			b.openedAt = b.now()
+			b.setState(BreakerOpen)
+		}
+		return
+	}
+
+	b.failures = 0
+	if b.state != BreakerHalfOpen {
+		return
+	}
+
+	b.successes++
+	if b.successes >= b.settings.HalfOpenRequests {
+		b.setState(BreakerClosed)
+	}
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	b.state = state
+	b.trials = 0
+	b.successes = 0
+	if state == BreakerClosed {
+		b.failures = 0
+	}
+
+	value := new(expvar.String)
+	value.Set(string(state))
+	breakerStates.Set(b.name, value)
+}
+
+func (b *Breaker) openError() error {
+	return &ProcessorError{
+		Kind:      ErrorUnavailable,
+		Processor: b.name,
+		Code:      "circuit_open",
+		Message:   "processor is temporarily unavailable",
+		Err:       ErrCircuitOpen,
+	}
+}
+
+func isOutage(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrRateLimited) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,117 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// scriptedConnector answers every call with err.
+type scriptedConnector struct {
+	err   error
+	calls int
+}
+
+func (c *scriptedConnector) Init(settings PaymentSettings) error {
+	return nil
+}
+
+func (c *scriptedConnector) Create(ctx context.Context, payment Payment) (*PaymentDetail, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &PaymentDetail{PrivateId: "private-1", Status: StatusCreated}, nil
+}
+
+func (c *scriptedConnector) Capture(ctx context.Context, paymentId string, capture CaptureRequest) (*CaptureResponse, error) {
+	c.calls++
+	return &CaptureResponse{}, c.err
+}
+
+func (c *scriptedConnector) Refund(ctx context.Context, paymentId string, refund PartialRefund) (*RefundResponse, error) {
+	c.calls++
+	return &RefundResponse{}, c.err
+}
+
+func (c *scriptedConnector) Void(ctx context.Context, paymentId string, void VoidRequest) (bool, error) {
+	c.calls++
+	return c.err == nil, c.err
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	connector := &scriptedConnector{err: &ProcessorError{Kind: ErrorUnavailable, Processor: "test"}}
+	breaker := NewBreaker("breaker_test", connector, BreakerSettings{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := breaker.Create(context.Background(), Payment{}); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("Create = %v, want the outage", err)
+		}
+	}
+
+	if state := breaker.State(); state != BreakerOpen || breaker.Available() {
+		t.Fatalf("state = %s, want open after 2 outages", state)
+	}
+
+	_, err := breaker.Create(context.Background(), Payment{})
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrUnavailable) || connector.calls != 2 {
+		t.Fatalf("Create while open = %v after %d calls, want circuit_open without calling the processor", err, connector.calls)
+	}
+
+	now = now.Add(time.Minute)
+	if state := breaker.State(); state != BreakerHalfOpen || !breaker.Available() {
+		t.Fatalf("state = %s, want half open after the open timeout", state)
+	}
+
+	// A failed trial opens the breaker again for another timeout.
+	breaker.Create(context.Background(), Payment{})
+	if state := breaker.State(); state != BreakerOpen || connector.calls != 3 {
+		t.Fatalf("state = %s after %d calls, want open again after the failed trial", state, connector.calls)
+	}
+
+	now = now.Add(time.Minute)
+	connector.err = nil
+	if _, err := breaker.Create(context.Background(), Payment{}); err != nil {
+		t.Fatalf("trial Create = %v, want nil", err)
+	}
+
+	if state := breaker.State(); state != BreakerClosed {
+		t.Errorf("state = %s, want closed after a successful trial", state)
+	}
+}
+
+func TestBreakerLimitsHalfOpenTrials(t *testing.T) {
+	connector := &scriptedConnector{err: &ProcessorError{Kind: ErrorUnavailable}}
+	breaker := NewBreaker("breaker_test", connector, BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	breaker.Void(context.Background(), "payment-1", VoidRequest{})
+	now = now.Add(time.Minute)
+
+	// The trial is still running when the next call arrives.
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("first trial = %v, want it let through", err)
+	}
+
+	if _, err := breaker.Capture(context.Background(), "payment-1", CaptureRequest{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second call while half open = %v, want circuit_open", err)
+	}
+}
+
+func TestBreakerIgnoresOtherFailures(t *testing.T) {
+	connector := &scriptedConnector{err: &ProcessorError{Kind: ErrorDeclined}}
+	breaker := NewBreaker("breaker_test", connector, BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+
+	for i := 0; i < 3; i++ {
+		breaker.Refund(context.Background(), "payment-1", PartialRefund{})
+	}
+
+	if state := breaker.State(); state != BreakerClosed || connector.calls != 3 {
+		t.Errorf("state = %s after %d calls, want declines to leave the breaker closed", state, connector.calls)
+	}
+}
@@ -1,36 +1,176 @@
 package processors
 
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"payment-processor.gary94746/main/lib/money"
+)
+
+const (
+	StatusCreated           = "created"
+	StatusApproved          = "approved"
+	StatusAuthorized        = "authorized"
+	StatusPartiallyCaptured = "partially_captured"
+	StatusCaptured          = "captured"
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+	StatusVoided            = "voided"
+	StatusFailed            = "failed"
+	StatusExpired           = "expired"
+)
+
+const (
+	CaptureAutomatic = "automatic"
+	CaptureManual    = "manual"
+)
+
+const (
+	EventPaymentApproved   = "payment.approved"
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentVoided     = "payment.voided"
+	EventPaymentCaptured   = "payment.captured"
+	EventPaymentFailed     = "payment.failed"
+	EventPaymentRefunded   = "payment.refunded"
+	EventPaymentExpired    = "payment.expired"
+)
+
 const (
-	StatusCreated  = "created"
-	StatusCaptured = "captured"
-	StatusRefunded = "refund"
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+var (
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+	ErrWebhookNotConfigured = errors.New("webhooks are not configured for this processor")
 )
 
 type PartialRefund struct {
-	Amount int64 `json:"amount" `
+	Amount         int64  `json:"amount"`
+	Reason         string `json:"reason"`
+	IdempotencyKey string `json:"-"`
 }
 
-type RefundResponse struct {
+func (refund PartialRefund) Money(currency string) money.Money {
+	return money.New(refund.Amount, currency)
+}
+
+type CaptureRequest struct {
+	Amount         int64  `json:"amount"`
+	Final          bool   `json:"final"`
+	IdempotencyKey string `json:"-"`
+}
+
+type CaptureResponse struct {
 	Id     string `json:"id"`
-	Amount string `json:"amount"`
+	Amount int64  `json:"amount"`
+	Final  bool   `json:"final"`
 }
 
-type LineItem struct {
-	Name     string `json:"name"`
+type VoidRequest struct {
+	IdempotencyKey string `json:"-"`
+}
+
+type RefundResponse struct {
+	Id       string `json:"id"`
 	Amount   int64  `json:"amount"`
-	Quantity int32  `json:"quantity"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+}
+
+type LineItem struct {
+	Name        string `json:"name"`
+	Amount      int64  `json:"amount"`
+	Quantity    int32  `json:"quantity"`
+	Sku         string `json:"sku"`
+	Description string `json:"description"`
+	Tax         int64  `json:"tax"`
+}
+
+func (item LineItem) Money(currency string) money.Money {
+	return money.New(item.Amount, currency)
+}
+
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postalCode"`
+	Country    string `json:"country"`
+}
+
+func (address Address) IsZero() bool {
+	return address == Address{}
+}
+
+type Shipping struct {
+	Name    string  `json:"name"`
+	Amount  int64   `json:"amount"`
+	Address Address `json:"address"`
+}
+
+type Customer struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
 }
 
 type Payment struct {
-	Currency    string           `json:"currency"`
-	Amount      int64            `json:"amount"`
-	Status      string           `json:"status"`
-	RedirectUrl string           `json:"redirectUrl"`
-	CancelUrl   string           `json:"cancelUrl"`
-	PrivateId   string           `json:"privateId"`
-	LineItems   []LineItem       `json:"lineItems"`
-	Refunds     []RefundResponse `json:"refunds"`
-	Id          string           `json:"id"`
+	Currency       string            `json:"currency"`
+	Amount         int64             `json:"amount"`
+	Status         string            `json:"status"`
+	RedirectUrl    string            `json:"redirectUrl"`
+	CancelUrl      string            `json:"cancelUrl"`
+	PrivateId      string            `json:"privateId"`
+	LineItems      []LineItem        `json:"lineItems"`
+	Shipping       *Shipping         `json:"shipping"`
+	Discount       int64             `json:"discount"`
+	Refunds        []RefundResponse  `json:"refunds"`
+	Id             string            `json:"id"`
+	Processor      string            `json:"processor"`
+	CaptureMethod  string            `json:"captureMethod"`
+	ReferenceId    string            `json:"referenceId"`
+	Description    string            `json:"description"`
+	Customer       *Customer         `json:"customer"`
+	Metadata       map[string]string `json:"metadata"`
+	IdempotencyKey string            `json:"-"`
+}
+
+func (payment Payment) Money() money.Money {
+	return money.New(payment.Amount, payment.Currency)
+}
+
+func (payment Payment) ItemTotal() int64 {
+	var total int64
+	for _, item := range payment.LineItems {
+		total += item.Amount * int64(item.Quantity)
+	}
+
+	return total
+}
+
+func (payment Payment) TaxTotal() int64 {
+	var total int64
+	for _, item := range payment.LineItems {
+		total += item.Tax * int64(item.Quantity)
+	}
+
+	return total
+}
+
+func (payment Payment) ShippingAmount() int64 {
+	if payment.Shipping == nil {
+		return 0
+	}
+
+	return payment.Shipping.Amount
+}
+
+func (payment Payment) Total() int64 {
+	return payment.ItemTotal() + payment.TaxTotal() + payment.ShippingAmount() - payment.Discount
 }
 
 type Storage interface {
@@ -45,6 +185,7 @@ type PaymentDetail struct {
 	PrivateId   string `json:"privateId"`
 	RedirectUrl string `json:"redirectUrl"`
 	Status      string `json:"status"`
+	Processor   string `json:"processor"`
 }
 
 type PaymentSettings struct {
@@ -54,7 +195,57 @@ type PaymentSettings struct {
 
 type PaymentConnector interface {
 	Init(settings PaymentSettings) error
-	Create(payment Payment) (*PaymentDetail, error)
-	Capture(paymentId string) (bool, error)
-	Refund(paymentId string, refund PartialRefund) (*RefundResponse, error)
+	Create(ctx context.Context, payment Payment) (*PaymentDetail, error)
+	Capture(ctx context.Context, paymentId string, capture CaptureRequest) (*CaptureResponse, error)
+	Refund(ctx context.Context, paymentId string, refund PartialRefund) (*RefundResponse, error)
+	Void(ctx context.Context, paymentId string, void VoidRequest) (bool, error)
+}
+
+type WebhookEvent struct {
+	Id        string
+	Type      string
+	PrivateId string
+	Refunds   []RefundResponse
+}
+
+type WebhookReceiver interface {
+	ParseWebhook(ctx context.Context, payload []byte, headers http.Header) (*WebhookEvent, error)
+}
+
+// ApprovalHandler is implemented by connectors whose payments need another
+// call once the buyer approves them: an authorization for manual captures,
+// or a capture when CapturesOnApproval is set.
+type ApprovalHandler interface {
+	Authorize(ctx context.Context, privateId string) error
+	CapturesOnApproval() bool
+}
+
+func AsApprovalHandler(connector PaymentConnector) (ApprovalHandler, bool) {
+	for {
+		if handler, isOk := connector.(ApprovalHandler); isOk {
+			return handler, true
+		}
+
+		wrapper, isOk := connector.(interface{ Unwrap() PaymentConnector })
+		if !isOk {
+			return nil, false
+		}
+
+		connector = wrapper.Unwrap()
+	}
+}
+
+func AsWebhookReceiver(connector PaymentConnector) (WebhookReceiver, bool) {
+	for {
+		if receiver, isOk := connector.(WebhookReceiver); isOk {
+			return receiver, true
+		}
+
+		wrapper, isOk := connector.(interface{ Unwrap() PaymentConnector })
+		if !isOk {
+			return nil, false
+		}
+
+		connector = wrapper.Unwrap()
+	}
 }
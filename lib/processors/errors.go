@@ -0,0 +1,117 @@
+package processors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type ErrorKind string
+
+const (
+	ErrorDeclined       ErrorKind = "declined"
+	ErrorInvalidRequest ErrorKind = "invalid_request"
+	ErrorAuthentication ErrorKind = "authentication"
+	ErrorRateLimited    ErrorKind = "rate_limited"
+	ErrorUnavailable    ErrorKind = "processor_unavailable"
+	ErrorNotFound       ErrorKind = "not_found"
+	ErrorConflict       ErrorKind = "conflict"
+)
+
+var (
+	ErrDeclined       = &ProcessorError{Kind: ErrorDeclined}
+	ErrInvalidRequest = &ProcessorError{Kind: ErrorInvalidRequest}
+	ErrAuthentication = &ProcessorError{Kind: ErrorAuthentication}
+	ErrRateLimited    = &ProcessorError{Kind: ErrorRateLimited}
+	ErrUnavailable    = &ProcessorError{Kind: ErrorUnavailable}
+	ErrNotFound       = &ProcessorError{Kind: ErrorNotFound}
+	ErrConflict       = &ProcessorError{Kind: ErrorConflict}
+)
+
+// ProcessorError is a failure reported by a processor, classified by Kind
+// and carrying the processor's own error code for diagnostics.
+type ProcessorError struct {
+	Kind       ErrorKind
+	Processor  string
+	Code       string
+	Message    string
+	StatusCode int
+	Err        error
+}
+
+func (e *ProcessorError) Error() string {
+	message := string(e.Kind)
+	if e.Processor != "" {
+		message = e.Processor + " " + message
+	}
+
+	if e.Code != "" {
+		message += " (" + e.Code + ")"
+	}
+
+	if e.Message != "" {
+		message += ": " + e.Message
+	} else if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+
+	return message
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is one of the Err* sentinels of the same kind,
+// so callers can use errors.Is(err, ErrDeclined).
+func (e *ProcessorError) Is(target error) bool {
+	sentinel, isOk := target.(*ProcessorError)
+	if !isOk {
+		return false
+	}
+
+	return sentinel.Kind == e.Kind && sentinel.Processor == "" && sentinel.Code == ""
+}
+
+// PartialRefundError is returned when a refund split across several
+// processor calls fails after some of them went through. Refund holds what
+// was actually refunded, which callers must still record.
+type PartialRefundError struct {
+	Refund RefundResponse
+	Err    error
+}
+
+func (e *PartialRefundError) Error() string {
+	return fmt.Sprintf("refund failed after refunding %d %s: %v", e.Refund.Amount, e.Refund.Currency, e.Err)
+}
+
+func (e *PartialRefundError) Unwrap() error {
+	return e.Err
+}
+
+func statusKind(status int) ErrorKind {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorAuthentication
+	case status == http.StatusPaymentRequired:
+		return ErrorDeclined
+	case status == http.StatusNotFound:
+		return ErrorNotFound
+	case status == http.StatusConflict:
+		return ErrorConflict
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case status >= http.StatusInternalServerError:
+		return ErrorUnavailable
+	}
+
+	return ErrorInvalidRequest
+}
+
+func unavailableError(processor string, err error) error {
+	return &ProcessorError{
+		Kind:      ErrorUnavailable,
+		Processor: processor,
+		Message:   "error reaching the processor",
+		Err:       err,
+	}
+}
@@ -0,0 +1,9 @@
+package processors
+
+import "net/http"
+
+func setIdempotencyKey(request *http.Request, header string, key string) {
+	if key != "" {
+		request.Header.Set(header, key)
+	}
+}
@@ -2,9 +2,9 @@ package processors
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"log/slog"
@@ -12,7 +12,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"time"
+
+	"payment-processor.gary94746/main/lib/money"
 )
 
 type PayPal struct {
@@ -21,7 +22,10 @@ type PayPal struct {
 	log         slog.Logger
 	username    string
 	password    string
-	bearerToken string
+	tokens      *tokenManager
+	webhookId   string
+	autoCapture bool
+	timeouts    Timeouts
 }
 
 func (p *PayPal) Init(settings PaymentSettings) error {
@@ -30,50 +34,92 @@ func (p *PayPal) Init(settings PaymentSettings) error {
 	p.basePath = "https://api.paypal.com"
 	p.password = settings.Credentials["client_token"]
 
+	p.webhookId = settings.Credentials["webhook_id"]
+	p.autoCapture = settings.Credentials["auto_capture"] == "true"
+	p.timeouts = timeoutsFrom(settings.Credentials)
+
 	isSandbox := settings.Credentials["mode"] == "SANDBOX"
 	if isSandbox {
 		p.basePath = "https://api.sandbox.paypal.com"
 	}
 
+	baseUrl := settings.Credentials["base_url"]
+	if baseUrl != "" {
+		p.basePath = baseUrl
+	}
+
 	p.client = &http.Client{
-		Timeout: 60 * time.Second,
+		Transport: newRetryTransport("paypal", "PayPal-Request-Id", retryPolicyFrom(settings.Credentials), &p.log),
 	}
+	p.tokens = newTokenManager(p.getToken)
 
 	return nil
 }
 
-func (p *PayPal) Create(payment Payment) (*PaymentDetail, error) {
+func (p *PayPal) Create(ctx context.Context, payment Payment) (*PaymentDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Create)
+	defer cancel()
+
 	items := []Item{}
 
 	for _, lineItem := range payment.LineItems {
+		unitAmount, err := paypalAmount(lineItem.Money(payment.Currency))
+		if err != nil {
+			return nil, err
+		}
+
 		item := Item{
-			Name:     lineItem.Name,
-			Quantity: strconv.Itoa(int(lineItem.Quantity)),
-			UnitAmount: Amount{
-				CurrencyCode: payment.Currency,
-				Value:        strconv.Itoa(int(lineItem.Amount / 100)),
-			},
+			Name:        lineItem.Name,
+			Description: lineItem.Description,
+			Sku:         lineItem.Sku,
+			Quantity:    strconv.Itoa(int(lineItem.Quantity)),
+			UnitAmount:  unitAmount,
+		}
+
+		item.Tax, err = optionalAmount(lineItem.Tax, payment.Currency)
+		if err != nil {
+			return nil, err
 		}
 
 		items = append(items, item)
 	}
 
+	total, err := paypalAmount(payment.Money())
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown, err := paypalBreakdown(payment)
+	if err != nil {
+		return nil, err
+	}
+
 	purchaseUnit := PurchaseUnits{
+		ReferenceId: payment.ReferenceId,
+		CustomId:    payment.ReferenceId,
+		Description: payment.Description,
 		Amount: PurchaseUnitAmount{
-			CurrencyCode: payment.Currency,
-			Value:        strconv.Itoa(int(payment.Amount / 100)),
-			Breakdown: Breakdown{
-				ItemTotal: Amount{
-					CurrencyCode: payment.Currency,
-					Value:        strconv.Itoa(int(payment.Amount / 100)),
-				},
-			},
+			CurrencyCode: total.CurrencyCode,
+			Value:        total.Value,
+			Breakdown:    *breakdown,
 		},
-		Items: items,
+		Items:    items,
+		Shipping: paypalShipping(payment.Shipping),
+	}
+
+	intent := "CAPTURE"
+	if payment.CaptureMethod == CaptureManual {
+		intent = "AUTHORIZE"
+	}
+
+	var payer *Payer
+	if payment.Customer != nil && payment.Customer.Email != "" {
+		payer = &Payer{EmailAddress: payment.Customer.Email}
 	}
 
 	order := Order{
-		Intent: "CAPTURE",
+		Intent: intent,
+		Payer:  payer,
 		ApplicationContext: ApplicationContext{
 			ReturnUrl: payment.RedirectUrl,
 			CancelUrl: payment.CancelUrl,
@@ -83,22 +129,26 @@ func (p *PayPal) Create(payment Payment) (*PaymentDetail, error) {
 
 	payload, err := json.Marshal(order)
 	if err != nil {
-		p.log.Info("Error on marshal order", err)
+		p.log.Info("Error on marshal order", "err", err)
+		return nil, errors.New("error encoding the order")
 	}
 
-	request, err := http.NewRequest(http.MethodPost, p.basePath+"/v2/checkout/orders", bytes.NewBuffer(payload))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.basePath+"/v2/checkout/orders", bytes.NewBuffer(payload))
 	if err != nil {
-		p.log.Info("Error on request", err)
+		p.log.Info("Error on request", "err", err)
+		return nil, errors.New("error creating the request")
 	}
+	setIdempotencyKey(request, "PayPal-Request-Id", payment.IdempotencyKey)
 
 	response, err := p.requestWrapper(*request)
 	if err != nil {
-		p.log.Info("Do request err", err)
+		p.log.Info("Do request err", "err", err)
+		return nil, err
 	}
 
 	rawResponse, err := io.ReadAll(response.Body)
 	if err != nil {
-		p.log.Error("Error decoding order response", rawResponse)
+		p.log.Error("Error decoding order response", "err", err)
 		return nil, errors.New("error decoding order response")
 	}
 
@@ -108,13 +158,13 @@ func (p *PayPal) Create(payment Payment) (*PaymentDetail, error) {
 	if !isCreatedStatus {
 		p.log.Error("PAYPAL_ORDER_CREATION_ERROR", "RESPONSE", string(rawResponse))
 
-		return nil, fmt.Errorf("error creating the order, detail -> %s", string(rawResponse))
+		return nil, paypalError(response.StatusCode, rawResponse)
 	}
 
 	orderResponse := &OrderResponse{}
 	dErr := json.Unmarshal([]byte(rawResponse), orderResponse)
 	if dErr != nil {
-		p.log.Info("Decoding error", dErr)
+		p.log.Info("Decoding error", "err", dErr)
 
 		return nil, errors.New("error decoding the order")
 	}
@@ -135,21 +185,36 @@ func (p *PayPal) Create(payment Payment) (*PaymentDetail, error) {
 	}, nil
 }
 
-func (p *PayPal) Capture(id string) (bool, error) {
-	request, err := http.NewRequest(http.MethodPost, p.basePath+"/v2/checkout/orders/"+id+"/capture", nil)
+func (p *PayPal) Capture(ctx context.Context, id string, capture CaptureRequest) (*CaptureResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Capture)
+	defer cancel()
+
+	orderDetail, err := p.getOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if orderDetail.Intent == "AUTHORIZE" {
+		return p.captureAuthorization(ctx, orderDetail, capture)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.basePath+"/v2/checkout/orders/"+id+"/capture", nil)
 	if err != nil {
-		p.log.Error("Error on request", err)
+		p.log.Error("Error on request", "err", err)
+		return nil, errors.New("error creating the request")
 	}
+	setIdempotencyKey(request, "PayPal-Request-Id", capture.IdempotencyKey)
 
 	response, err := p.requestWrapper(*request)
 	if err != nil {
-		p.log.Error("Do request err", err)
+		p.log.Error("Do request err", "err", err)
+		return nil, err
 	}
 
 	rawResponse, err := io.ReadAll(response.Body)
 	if err != nil {
-		p.log.Error("Error decoding order response", rawResponse)
-		return false, errors.New("error decoding order response")
+		p.log.Error("Error decoding order response", "err", err)
+		return nil, errors.New("error decoding order response")
 	}
 
 	defer response.Body.Close()
@@ -158,84 +223,388 @@ func (p *PayPal) Capture(id string) (bool, error) {
 	if !isCreatedStatus {
 		p.log.Error("Error capturing the order", "response", string(rawResponse), "status", response.StatusCode)
 
-		return false, errors.New("error capturing")
+		return nil, paypalError(response.StatusCode, rawResponse)
 	}
 
-	return true, nil
+	var capturedOrder OrderDetail
+	if err := json.Unmarshal(rawResponse, &capturedOrder); err != nil {
+		return nil, errors.New("error decoding the capture")
+	}
+
+	if len(capturedOrder.PurchaseUnits) == 0 || len(capturedOrder.PurchaseUnits[0].Payments.Captures) == 0 {
+		return nil, errors.New("order has no captures")
+	}
+
+	captured := capturedOrder.PurchaseUnits[0].Payments.Captures[0]
+	return &CaptureResponse{
+		Id:     captured.ID,
+		Amount: minorUnits(captured.Amount.Value, captured.Amount.CurrencyCode),
+		Final:  true,
+	}, nil
 }
 
-func (p *PayPal) Refund(paymentId string, refund PartialRefund) (*RefundResponse, error) {
-	orderDetail, err := p.getOrder(paymentId)
+// Refund spreads the amount over the captures that still have a balance, as
+// PayPal refunds captures rather than orders. When more than one capture is
+// refunded the response carries the PayPal refund ids joined by commas, and a
+// failure after the first one returns what was refunded in a
+// PartialRefundError.
+func (p *PayPal) Refund(ctx context.Context, paymentId string, refund PartialRefund) (*RefundResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Refund)
+	defer cancel()
+
+	orderDetail, err := p.getOrder(ctx, paymentId)
 	if err != nil {
 		p.log.Warn("Order querying", "orderId", paymentId)
-		return nil, errors.New("Error querying the order" + paymentId)
+		return nil, err
 	}
 
 	purchaseUnits := orderDetail.PurchaseUnits
-	if purchaseUnits == nil {
+	if len(purchaseUnits) == 0 {
 		return nil, errors.New("order not have purchase units")
 	}
 
-	captures := purchaseUnits[0].Payments.Captures
-	if captures == nil {
-		return nil, errors.New("order not capture yet")
+	payments := purchaseUnits[0].Payments
+	if len(payments.Captures) == 0 {
+		return nil, &ProcessorError{
+			Kind:      ErrorConflict,
+			Processor: "paypal",
+			Code:      orderDetail.Status,
+			Message:   "order not capture yet",
+		}
 	}
 
-	var payload Refund
-	payload.Amount.Value = strconv.Itoa(int(refund.Amount / 100))
-	payload.Amount.CurrencyCode = captures[0].Amount.CurrencyCode
+	refunded := map[string]int64{}
+	for _, previous := range payments.Refunds {
+		if previous.Status == "FAILED" || previous.Status == "CANCELLED" {
+			continue
+		}
 
-	jsonMarshal, err := json.Marshal(payload)
-	if err != nil {
-		p.log.Error("error on marshal refund request")
+		for _, link := range previous.Links {
+			if link.Rel == "up" {
+				captureId := link.Href[strings.LastIndex(link.Href, "/")+1:]
+				refunded[captureId] += minorUnits(previous.Amount.Value, previous.Amount.CurrencyCode)
+			}
+		}
+	}
+
+	currency := payments.Captures[0].Amount.CurrencyCode
+	var balance int64
+	for _, capture := range payments.Captures {
+		balance += minorUnits(capture.Amount.Value, capture.Amount.CurrencyCode) - refunded[capture.ID]
+	}
+
+	remaining := refund.Amount
+	if remaining == 0 {
+		remaining = balance
+	}
+
+	if remaining <= 0 || remaining > balance {
+		return nil, &ProcessorError{
+			Kind:      ErrorConflict,
+			Processor: "paypal",
+			Code:      "REFUND_AMOUNT_EXCEEDED",
+			Message:   "refund amount exceeds the captured balance",
+		}
+	}
+
+	ids := []string{}
+	response := &RefundResponse{Currency: currency, Status: RefundSucceeded}
+	for _, capture := range payments.Captures {
+		available := minorUnits(capture.Amount.Value, capture.Amount.CurrencyCode) - refunded[capture.ID]
+		if remaining == 0 || available <= 0 {
+			continue
+		}
+
+		amount := available
+		if remaining < amount {
+			amount = remaining
+		}
+
+		idempotencyKey := refund.IdempotencyKey
+		if idempotencyKey != "" {
+			idempotencyKey += "-" + capture.ID
+		}
+
+		refundDetail, err := p.refundCapture(ctx, capture.ID, money.New(amount, currency), refund.Reason, idempotencyKey)
+		if err != nil {
+			p.log.Error("error refunding capture", "orderId", paymentId, "captureId", capture.ID)
+			if len(ids) == 0 {
+				return nil, err
+			}
+
+			response.Id = strings.Join(ids, ",")
+			return nil, &PartialRefundError{Refund: *response, Err: err}
+		}
+
+		part := paypalRefundResponse(*refundDetail)
+		ids = append(ids, part.Id)
+		response.Amount += part.Amount
+		if part.Status != RefundSucceeded {
+			response.Status = part.Status
+		}
+
+		remaining -= amount
 	}
 
-	request, err := http.NewRequest(http.MethodPost, p.basePath+"/v2/payments/captures/"+captures[0].ID+"/refund", bytes.NewBuffer(jsonMarshal))
+	response.Id = strings.Join(ids, ",")
+	return response, nil
+}
+
+func (p *PayPal) refundCapture(ctx context.Context, captureId string, amount money.Money, reason string, idempotencyKey string) (*RefundDetail, error) {
+	value, err := paypalAmount(amount)
 	if err != nil {
-		p.log.Error("error creating request for refund, " + paymentId)
+		return nil, err
 	}
 
-	response, err := p.requestWrapper(*request)
+	payload, err := json.Marshal(Refund{Amount: value, NoteToPayer: reason})
 	if err != nil {
-		p.log.Error("error requesting refund " + paymentId)
+		return nil, errors.New("error encoding the refund")
 	}
 
-	defer response.Body.Close()
-	rawResponse, err := io.ReadAll(response.Body)
+	rawResponse, err := p.post(ctx, "/v2/payments/captures/"+captureId+"/refund", payload, idempotencyKey, http.StatusCreated)
 	if err != nil {
-		p.log.Error("Error reading body for refund: " + paymentId)
+		return nil, err
+	}
+
+	var refundDetail RefundDetail
+	if err := json.Unmarshal(rawResponse, &refundDetail); err != nil {
+		return nil, errors.New("error decoding json")
+	}
 
-		return nil, errors.New("error refunding order with status " + request.Response.Status)
+	if refundDetail.Amount.Value == "" {
+		refundDetail.Amount = value
 	}
 
-	isOk := response.StatusCode == http.StatusCreated
-	if !isOk {
-		p.log.Error("error refunding order with status "+response.Status, "response", rawResponse)
-		return nil, errors.New("error refunding order with status " + response.Status)
+	return &refundDetail, nil
+}
+
+func paypalError(status int, rawResponse []byte) error {
+	processorErr := &ProcessorError{
+		Kind:       statusKind(status),
+		Processor:  "paypal",
+		Message:    http.StatusText(status),
+		StatusCode: status,
 	}
 
-	var refundDetail RefundDetail
-	unmarshalErr := json.Unmarshal(rawResponse, &refundDetail)
+	var response PayPalErrorResponse
+	if err := json.Unmarshal(rawResponse, &response); err != nil {
+		return processorErr
+	}
 
-	if unmarshalErr != nil {
-		return nil, errors.New("error decoding json")
+	processorErr.Code = response.Name
+	if response.Error != "" {
+		processorErr.Code = response.Error
+	}
+
+	for _, message := range []string{response.Message, response.ErrorDescription} {
+		if message != "" {
+			processorErr.Message = message
+		}
+	}
+
+	if len(response.Details) > 0 && response.Details[0].Issue != "" {
+		processorErr.Code = response.Details[0].Issue
+		if response.Details[0].Description != "" {
+			processorErr.Message = response.Details[0].Description
+		}
+	}
+
+	switch {
+	case processorErr.Code == "INSTRUMENT_DECLINED" || processorErr.Code == "TRANSACTION_REFUSED" ||
+		processorErr.Code == "PAYER_CANNOT_PAY" || processorErr.Code == "PAYER_ACCOUNT_LOCKED_OR_CLOSED":
+		processorErr.Kind = ErrorDeclined
+	case processorErr.Code == "ORDER_ALREADY_CAPTURED" || processorErr.Code == "ORDER_ALREADY_AUTHORIZED" ||
+		processorErr.Code == "ORDER_NOT_APPROVED" || processorErr.Code == "DUPLICATE_INVOICE_ID" ||
+		processorErr.Code == "CAPTURE_FULLY_REFUNDED" || processorErr.Code == "REFUND_AMOUNT_EXCEEDED" ||
+		strings.HasPrefix(processorErr.Code, "AUTHORIZATION_ALREADY_"):
+		processorErr.Kind = ErrorConflict
+	}
+
+	return processorErr
+}
+
+func paypalRefundResponse(refund RefundDetail) *RefundResponse {
+	status := RefundPending
+	switch refund.Status {
+	case "COMPLETED":
+		status = RefundSucceeded
+	case "FAILED", "CANCELLED":
+		status = RefundFailed
 	}
 
 	return &RefundResponse{
-		Id:     refundDetail.Id,
-		Amount: captures[0].Amount.CurrencyCode,
+		Id:       refund.Id,
+		Amount:   minorUnits(refund.Amount.Value, refund.Amount.CurrencyCode),
+		Currency: refund.Amount.CurrencyCode,
+		Status:   status,
+	}
+}
+
+func (p *PayPal) Void(ctx context.Context, id string, void VoidRequest) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Void)
+	defer cancel()
+
+	orderDetail, err := p.getOrder(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if orderDetail.Intent != "AUTHORIZE" {
+		return false, &ProcessorError{
+			Kind:      ErrorConflict,
+			Processor: "paypal",
+			Code:      orderDetail.Intent,
+			Message:   "only authorized orders can be voided",
+		}
+	}
+
+	authorization := orderAuthorization(orderDetail)
+	if authorization == nil && orderDetail.Status != "APPROVED" {
+		return true, nil
+	}
+
+	if authorization == nil {
+		authorization, err = p.authorize(ctx, orderDetail.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = p.post(ctx, "/v2/payments/authorizations/"+authorization.Id+"/void", nil, void.IdempotencyKey, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (p *PayPal) Authorize(ctx context.Context, orderId string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Capture)
+	defer cancel()
+
+	_, err := p.authorize(ctx, orderId)
+	return err
+}
+
+func (p *PayPal) CapturesOnApproval() bool {
+	return p.autoCapture
+}
+
+func (p *PayPal) authorize(ctx context.Context, orderId string) (*AuthorizationDetail, error) {
+	rawResponse, err := p.post(ctx, "/v2/checkout/orders/"+orderId+"/authorize", []byte("{}"), "authorize-"+orderId, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var orderDetail OrderDetail
+	if err := json.Unmarshal(rawResponse, &orderDetail); err != nil {
+		return nil, errors.New("error decoding the authorization")
+	}
+
+	authorization := orderAuthorization(&orderDetail)
+	if authorization == nil {
+		return nil, errors.New("order was not authorized")
+	}
+
+	return authorization, nil
+}
+
+func (p *PayPal) captureAuthorization(ctx context.Context, orderDetail *OrderDetail, capture CaptureRequest) (*CaptureResponse, error) {
+	authorization := orderAuthorization(orderDetail)
+	if authorization == nil {
+		authorized, err := p.authorize(ctx, orderDetail.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		authorization = authorized
+	}
+
+	payload := AuthorizationCapture{FinalCapture: capture.Final || capture.Amount == 0}
+	if capture.Amount > 0 {
+		amount, err := paypalAmount(money.New(capture.Amount, authorization.Amount.CurrencyCode))
+		if err != nil {
+			return nil, err
+		}
+
+		payload.Amount = &amount
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.New("error encoding the capture")
+	}
+
+	rawResponse, err := p.post(ctx, "/v2/payments/authorizations/"+authorization.Id+"/capture", body, capture.IdempotencyKey, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	var captured CaptureDetail
+	if err := json.Unmarshal(rawResponse, &captured); err != nil {
+		return nil, errors.New("error decoding the capture")
+	}
+
+	return &CaptureResponse{
+		Id:     captured.Id,
+		Amount: minorUnits(captured.Amount.Value, captured.Amount.CurrencyCode),
+		Final:  captured.FinalCapture,
 	}, nil
 }
 
-func (p *PayPal) getOrder(orderId string) (*OrderDetail, error) {
-	request, err := http.NewRequest(http.MethodGet, p.basePath+"/v2/checkout/orders/"+orderId, nil)
+func orderAuthorization(orderDetail *OrderDetail) *AuthorizationDetail {
+	if len(orderDetail.PurchaseUnits) == 0 {
+		return nil
+	}
+
+	authorizations := orderDetail.PurchaseUnits[0].Payments.Authorizations
+	if len(authorizations) == 0 {
+		return nil
+	}
+
+	return &authorizations[0]
+}
+
+func (p *PayPal) post(ctx context.Context, path string, payload []byte, idempotencyKey string, expected ...int) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.basePath+path, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, errors.New("error creating the request")
+	}
+	setIdempotencyKey(request, "PayPal-Request-Id", idempotencyKey)
+
+	response, err := p.requestWrapper(*request)
+	if err != nil {
+		p.log.Error("Do request err", "path", path, "err", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New("error reading the response")
+	}
+
+	for _, status := range expected {
+		if response.StatusCode == status {
+			return rawResponse, nil
+		}
+	}
+
+	p.log.Error("unexpected paypal response", "path", path, "status", response.StatusCode, "response", string(rawResponse))
+	return nil, paypalError(response.StatusCode, rawResponse)
+}
+
+func (p *PayPal) getOrder(ctx context.Context, orderId string) (*OrderDetail, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.basePath+"/v2/checkout/orders/"+orderId, nil)
 	if err != nil {
 		p.log.Error("error creating request for refund, " + orderId)
+		return nil, errors.New("error creating the request")
 	}
 
 	response, err := p.requestWrapper(*request)
 	if err != nil {
 		p.log.Error("error requesting refund " + orderId)
+		return nil, err
 	}
 
 	defer response.Body.Close()
@@ -247,6 +616,11 @@ func (p *PayPal) getOrder(orderId string) (*OrderDetail, error) {
 		return nil, errors.New("error refunding order with status " + response.Status)
 	}
 
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		return nil, paypalError(response.StatusCode, rawResponse)
+	}
+
 	var orderDetail OrderDetail
 
 	marshalErr := json.Unmarshal(rawResponse, &orderDetail)
@@ -258,9 +632,9 @@ func (p *PayPal) getOrder(orderId string) (*OrderDetail, error) {
 
 }
 
-func (p *PayPal) getToken() (*string, error) {
+func (p *PayPal) getToken(ctx context.Context) (*TokenResponse, error) {
 	payload := strings.NewReader("grant_type=client_credentials")
-	req, err := http.NewRequest(http.MethodPost, p.basePath+"/v1/oauth2/token", payload)
+	req, err := http.NewRequestWithContext(repeatable(ctx), http.MethodPost, p.basePath+"/v1/oauth2/token", payload)
 	if err != nil {
 		p.log.Error("error creating the request", "detail", err)
 		return nil, errors.New("error creating the request")
@@ -272,8 +646,9 @@ func (p *PayPal) getToken() (*string, error) {
 	response, err := p.client.Do(req)
 	if err != nil {
 		p.log.Error("error on request", "detail", err)
-		return nil, errors.New("error on request")
+		return nil, unavailableError("paypal", err)
 	}
+	defer response.Body.Close()
 
 	rawResponse, err := io.ReadAll(response.Body)
 	if err != nil {
@@ -283,63 +658,160 @@ func (p *PayPal) getToken() (*string, error) {
 
 	isOk := response.StatusCode == 200
 	if !isOk {
-		p.log.Error("Error getting the auth token", "detail", rawResponse)
-		return nil, errors.New("error getting the auth token")
+		p.log.Error("Error getting the auth token", "detail", string(rawResponse))
+		return nil, paypalError(response.StatusCode, rawResponse)
 	}
 
 	var tokenResponse TokenResponse
 	unmarshalErr := json.Unmarshal(rawResponse, &tokenResponse)
 	if unmarshalErr != nil {
 		p.log.Error("error unmarshal", "detail", unmarshalErr)
+		return nil, errors.New("error decoding the auth token")
 	}
 
-	return &tokenResponse.AccessToken, nil
+	return &tokenResponse, nil
 }
 
-func (p *PayPal) retryRequest(request http.Request) (*http.Response, error) {
-	request.Header.Set("Authorization", "Bearer "+p.bearerToken)
+func (p *PayPal) retryRequest(request http.Request, token string) (*http.Response, error) {
+	request.Header.Set("Authorization", "Bearer "+token)
 
 	response, err := p.client.Do(&request)
 	if err != nil {
 		p.log.Error("RETRY_REQUEST", "message", err)
 
-		return nil, errors.New("RETRY_REQUEST fails")
+		return nil, unavailableError("paypal", err)
 	}
 
 	return response, nil
 }
 
 func (p *PayPal) requestWrapper(request http.Request) (*http.Response, error) {
+	token, err := p.tokens.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
 	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Authorization", "Bearer "+p.bearerToken)
+	request.Header.Set("Authorization", "Bearer "+token)
 
 	var body []byte
 	if request.Body != nil {
 		body, _ = io.ReadAll(request.Body)
 		defer request.Body.Close()
 	}
-	bodyCopy := io.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
 
-	request.Body = bodyCopy
+	request.Body, _ = request.GetBody()
 	firstResponse, err := p.client.Do(&request)
 	if err != nil {
 		log.Println("Error requesting the token")
+		return nil, unavailableError("paypal", err)
 	}
 
 	isUnauthorized := firstResponse.StatusCode == 401
 	if isUnauthorized {
-		token, err := p.getToken()
+		firstResponse.Body.Close()
+		token, err = p.tokens.Refresh(request.Context(), token)
 
 		if err != nil {
-			return nil, errors.New("error getting authorization bearer token")
+			return nil, err
 		}
 
-		p.bearerToken = *token
-		bodyCopy := io.NopCloser(bytes.NewReader(body))
-		request.Body = bodyCopy
+		request.Body, _ = request.GetBody()
 
-		return p.retryRequest(request)
+		return p.retryRequest(request, token)
 	}
 
 	return firstResponse, nil
 }
+
+var paypalWholeCurrencies = map[string]bool{
+	"HUF": true,
+	"TWD": true,
+}
+
+func paypalAmount(amount money.Money) (Amount, error) {
+	value := amount.Decimal()
+	if paypalWholeCurrencies[amount.Currency] {
+		if !amount.IsWhole() {
+			return Amount{}, errors.New("paypal does not support fractional " + amount.Currency + " amounts")
+		}
+
+		value = strconv.FormatInt(amount.Amount/100, 10)
+	}
+
+	return Amount{
+		CurrencyCode: amount.Currency,
+		Value:        value,
+	}, nil
+}
+
+func minorUnits(value string, currency string) int64 {
+	amount, err := money.Parse(value, currency)
+	if err != nil {
+		return 0
+	}
+
+	return amount.Amount
+}
+
+func paypalBreakdown(payment Payment) (*Breakdown, error) {
+	itemTotal, err := paypalAmount(money.New(payment.ItemTotal(), payment.Currency))
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := &Breakdown{ItemTotal: itemTotal}
+
+	breakdown.TaxTotal, err = optionalAmount(payment.TaxTotal(), payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown.Shipping, err = optionalAmount(payment.ShippingAmount(), payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown.Discount, err = optionalAmount(payment.Discount, payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return breakdown, nil
+}
+
+func optionalAmount(amount int64, currency string) (*Amount, error) {
+	if amount == 0 {
+		return nil, nil
+	}
+
+	value, err := paypalAmount(money.New(amount, currency))
+	if err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+func paypalShipping(shipping *Shipping) *PurchaseUnitShipping {
+	if shipping == nil || shipping.Address.IsZero() {
+		return nil
+	}
+
+	unitShipping := &PurchaseUnitShipping{
+		Address: ShippingAddress{
+			AddressLine1: shipping.Address.Line1,
+			AddressLine2: shipping.Address.Line2,
+			AdminArea2:   shipping.Address.City,
+			AdminArea1:   shipping.Address.State,
+			PostalCode:   shipping.Address.PostalCode,
+			CountryCode:  shipping.Address.Country,
+		},
+	}
+	unitShipping.Name.FullName = shipping.Name
+
+	return unitShipping
+}
@@ -0,0 +1,11 @@
+package processors_test
+
+import (
+	"testing"
+
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+func TestPayPal(t *testing.T) {
+	processortest.RunConnector(t, processortest.PayPalHarness)
+}
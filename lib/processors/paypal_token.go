@@ -0,0 +1,146 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	tokenRefreshMargin = 5 * time.Minute
+	tokenRetryDelay    = 10 * time.Second
+	tokenFetchTimeout  = 30 * time.Second
+)
+
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// tokenManager caches the PayPal access token and refreshes it in the
+// background ahead of its expiry, serving the cached token until it actually
+// expires. Concurrent callers needing a new token share a single fetch.
+type tokenManager struct {
+	fetch func(ctx context.Context) (*TokenResponse, error)
+	now   func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+	expiresAt time.Time
+	inflight  *tokenFetch
+}
+
+func newTokenManager(fetch func(ctx context.Context) (*TokenResponse, error)) *tokenManager {
+	return &tokenManager{fetch: fetch, now: time.Now}
+}
+
+func (m *tokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := m.now()
+	isFresh := m.token != "" && (m.refreshAt.IsZero() || now.Before(m.refreshAt))
+	if isFresh {
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+
+	isValid := m.token != "" && now.Before(m.expiresAt)
+	if isValid {
+		if m.inflight == nil {
+			call := &tokenFetch{done: make(chan struct{})}
+			m.inflight = call
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+				defer cancel()
+
+				m.run(ctx, call)
+			}()
+		}
+
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+
+	return m.load(ctx)
+}
+
+// Refresh replaces a token the processor rejected. Callers that were rejected
+// with an already replaced token get the new one without another fetch.
+func (m *tokenManager) Refresh(ctx context.Context, rejected string) (string, error) {
+	m.mu.Lock()
+	if m.token != "" && m.token != rejected {
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+
+	m.token = ""
+
+	return m.load(ctx)
+}
+
+// load must be called with m.mu held and releases it. A fetch abandoned by
+// the caller that started it is retried for callers that are still waiting.
+func (m *tokenManager) load(ctx context.Context) (string, error) {
+	for {
+		call := m.inflight
+		if call == nil {
+			call = &tokenFetch{done: make(chan struct{})}
+			m.inflight = call
+			go m.run(ctx, call)
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		isAbandoned := call.err != nil && ctx.Err() == nil &&
+			(errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded))
+		if !isAbandoned {
+			return call.token, call.err
+		}
+
+		m.mu.Lock()
+	}
+}
+
+func (m *tokenManager) run(ctx context.Context, call *tokenFetch) {
+	response, err := m.fetch(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		m.token = response.AccessToken
+		m.refreshAt = time.Time{}
+		m.expiresAt = time.Time{}
+		if response.ExpiresIn > 0 {
+			lifetime := time.Duration(response.ExpiresIn) * time.Second
+			margin := tokenRefreshMargin
+			if margin > lifetime/2 {
+				margin = lifetime / 2
+			}
+			m.refreshAt = m.now().Add(lifetime - margin)
+			m.expiresAt = m.now().Add(lifetime)
+		}
+		call.token = m.token
+	} else if m.token != "" && !m.refreshAt.IsZero() {
+		// A failed refresh is retried later, while the current token is still
+		// valid.
+		m.refreshAt = m.now().Add(tokenRetryDelay)
+		if m.refreshAt.After(m.expiresAt) {
+			m.refreshAt = m.expiresAt
+		}
+	}
+
+	call.err = err
+	m.inflight = nil
+	close(call.done)
+}
@@ -1,13 +1,20 @@
 package processors
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Refund struct {
-	Amount Amount `json:"amount"`
+	Amount      Amount `json:"amount"`
+	NoteToPayer string `json:"note_to_payer,omitempty"`
 }
 
 type RefundDetail struct {
-	Id string `json:"id"`
+	Id     string              `json:"id"`
+	Status string              `json:"status"`
+	Amount Amount              `json:"amount"`
+	Links  []OrderResponseLink `json:"links,omitempty"`
 }
 
 type Amount struct {
@@ -16,13 +23,18 @@ type Amount struct {
 }
 
 type Item struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	UnitAmount  Amount `json:"unit_amount"`
-	Quantity    string `json:"quantity"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Sku         string  `json:"sku,omitempty"`
+	UnitAmount  Amount  `json:"unit_amount"`
+	Tax         *Amount `json:"tax,omitempty"`
+	Quantity    string  `json:"quantity"`
 }
 type Breakdown struct {
-	ItemTotal Amount `json:"item_total"`
+	ItemTotal Amount  `json:"item_total"`
+	TaxTotal  *Amount `json:"tax_total,omitempty"`
+	Shipping  *Amount `json:"shipping,omitempty"`
+	Discount  *Amount `json:"discount,omitempty"`
 }
 
 type PurchaseUnitAmount struct {
@@ -32,8 +44,32 @@ type PurchaseUnitAmount struct {
 }
 
 type PurchaseUnits struct {
-	Amount PurchaseUnitAmount `json:"amount"`
-	Items  []Item             `json:"items"`
+	ReferenceId string                `json:"reference_id,omitempty"`
+	CustomId    string                `json:"custom_id,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Amount      PurchaseUnitAmount    `json:"amount"`
+	Items       []Item                `json:"items"`
+	Shipping    *PurchaseUnitShipping `json:"shipping,omitempty"`
+}
+
+type Payer struct {
+	EmailAddress string `json:"email_address,omitempty"`
+}
+
+type PurchaseUnitShipping struct {
+	Name struct {
+		FullName string `json:"full_name"`
+	} `json:"name"`
+	Address ShippingAddress `json:"address"`
+}
+
+type ShippingAddress struct {
+	AddressLine1 string `json:"address_line_1"`
+	AddressLine2 string `json:"address_line_2,omitempty"`
+	AdminArea2   string `json:"admin_area_2,omitempty"`
+	AdminArea1   string `json:"admin_area_1,omitempty"`
+	PostalCode   string `json:"postal_code,omitempty"`
+	CountryCode  string `json:"country_code"`
 }
 
 type ApplicationContext struct {
@@ -43,6 +79,7 @@ type ApplicationContext struct {
 
 type Order struct {
 	Intent             string             `json:"intent"`
+	Payer              *Payer             `json:"payer,omitempty"`
 	ApplicationContext ApplicationContext `json:"application_context"`
 	PurchaseUnits      []PurchaseUnits    `json:"purchase_units"`
 }
@@ -59,8 +96,78 @@ type OrderResponseLink struct {
 	Method string `json:"method"`
 }
 
+type WebhookNotification struct {
+	Id           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+type WebhookResource struct {
+	Id                string              `json:"id"`
+	Intent            string              `json:"intent"`
+	Status            string              `json:"status"`
+	Amount            Amount              `json:"amount"`
+	FinalCapture      bool                `json:"final_capture"`
+	SupplementaryData SupplementaryData   `json:"supplementary_data"`
+	Links             []OrderResponseLink `json:"links"`
+}
+
+type SupplementaryData struct {
+	RelatedIds struct {
+		OrderId string `json:"order_id"`
+	} `json:"related_ids"`
+}
+
+type AuthorizationDetail struct {
+	Id                string            `json:"id"`
+	Status            string            `json:"status"`
+	Amount            Amount            `json:"amount"`
+	SupplementaryData SupplementaryData `json:"supplementary_data"`
+}
+
+type CaptureDetail struct {
+	Id                string            `json:"id"`
+	Status            string            `json:"status"`
+	Amount            Amount            `json:"amount"`
+	FinalCapture      bool              `json:"final_capture"`
+	SupplementaryData SupplementaryData `json:"supplementary_data"`
+}
+
+type AuthorizationCapture struct {
+	Amount       *Amount `json:"amount,omitempty"`
+	FinalCapture bool    `json:"final_capture"`
+}
+
+type VerifyWebhookSignature struct {
+	AuthAlgo         string          `json:"auth_algo"`
+	CertUrl          string          `json:"cert_url"`
+	TransmissionId   string          `json:"transmission_id"`
+	TransmissionSig  string          `json:"transmission_sig"`
+	TransmissionTime string          `json:"transmission_time"`
+	WebhookId        string          `json:"webhook_id"`
+	WebhookEvent     json.RawMessage `json:"webhook_event"`
+}
+
+type VerifyWebhookSignatureResponse struct {
+	VerificationStatus string `json:"verification_status"`
+}
+
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type PayPalErrorResponse struct {
+	Name             string `json:"name"`
+	Message          string `json:"message"`
+	DebugId          string `json:"debug_id"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Details          []struct {
+		Issue       string `json:"issue"`
+		Description string `json:"description"`
+	} `json:"details"`
 }
 
 type OrderDetail struct {
@@ -163,7 +270,8 @@ type OrderDetail struct {
 			} `json:"address"`
 		} `json:"shipping"`
 		Payments struct {
-			Captures []struct {
+			Authorizations []AuthorizationDetail `json:"authorizations"`
+			Captures       []struct {
 				ID     string `json:"id"`
 				Status string `json:"status"`
 				Amount struct {
@@ -197,6 +305,7 @@ type OrderDetail struct {
 				CreateTime time.Time `json:"create_time"`
 				UpdateTime time.Time `json:"update_time"`
 			} `json:"captures"`
+			Refunds []RefundDetail `json:"refunds"`
 		} `json:"payments"`
 	} `json:"purchase_units"`
 	Payer struct {
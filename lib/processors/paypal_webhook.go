@@ -0,0 +1,171 @@
+package processors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func (p *PayPal) ParseWebhook(ctx context.Context, payload []byte, headers http.Header) (*WebhookEvent, error) {
+	err := p.verifyWebhook(ctx, payload, headers)
+	if err != nil {
+		p.log.Warn("paypal webhook rejected", "err", err.Error())
+		return nil, err
+	}
+
+	var notification WebhookNotification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return nil, errors.New("error decoding the event")
+	}
+
+	var resource WebhookResource
+	if err := json.Unmarshal(notification.Resource, &resource); err != nil {
+		return nil, errors.New("error decoding the event resource")
+	}
+
+	event := &WebhookEvent{Id: notification.Id}
+
+	switch notification.EventType {
+	case "CHECKOUT.ORDER.APPROVED":
+		event.Type = EventPaymentApproved
+		event.PrivateId = resource.Id
+	case "PAYMENT.CAPTURE.COMPLETED", "PAYMENT.CAPTURE.DENIED":
+		event.Type = EventPaymentCaptured
+		if notification.EventType == "PAYMENT.CAPTURE.DENIED" {
+			event.Type = EventPaymentFailed
+		} else if !resource.FinalCapture {
+			event.Type = ""
+		}
+		event.PrivateId = resource.SupplementaryData.RelatedIds.OrderId
+	case "PAYMENT.AUTHORIZATION.CREATED", "PAYMENT.AUTHORIZATION.VOIDED":
+		event.Type = EventPaymentAuthorized
+		if notification.EventType == "PAYMENT.AUTHORIZATION.VOIDED" {
+			event.Type = EventPaymentVoided
+		}
+		event.PrivateId = resource.SupplementaryData.RelatedIds.OrderId
+	case "PAYMENT.CAPTURE.REFUNDED":
+		orderId := resource.SupplementaryData.RelatedIds.OrderId
+		if orderId == "" {
+			orderId, err = p.refundOrderId(ctx, resource)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		event.Type = EventPaymentRefunded
+		event.PrivateId = orderId
+		event.Refunds = []RefundResponse{*paypalRefundResponse(RefundDetail{
+			Id:     resource.Id,
+			Status: resource.Status,
+			Amount: resource.Amount,
+		})}
+	default:
+		p.log.Info("paypal webhook ignored", "type", notification.EventType, "id", notification.Id)
+	}
+
+	return event, nil
+}
+
+func (p *PayPal) verifyWebhook(ctx context.Context, payload []byte, headers http.Header) error {
+	if p.webhookId == "" {
+		return ErrWebhookNotConfigured
+	}
+
+	verification := VerifyWebhookSignature{
+		AuthAlgo:         headers.Get("Paypal-Auth-Algo"),
+		CertUrl:          headers.Get("Paypal-Cert-Url"),
+		TransmissionId:   headers.Get("Paypal-Transmission-Id"),
+		TransmissionSig:  headers.Get("Paypal-Transmission-Sig"),
+		TransmissionTime: headers.Get("Paypal-Transmission-Time"),
+		WebhookId:        p.webhookId,
+		WebhookEvent:     payload,
+	}
+
+	if verification.TransmissionId == "" || verification.TransmissionSig == "" {
+		return ErrInvalidSignature
+	}
+
+	body, err := json.Marshal(verification)
+	if err != nil {
+		return errors.New("error encoding the verification request")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.basePath+"/v1/notifications/verify-webhook-signature", bytes.NewBuffer(body))
+	if err != nil {
+		return errors.New("error creating the verification request")
+	}
+
+	response, err := p.requestWrapper(*request)
+	if err != nil {
+		return errors.New("error verifying the webhook signature")
+	}
+	defer response.Body.Close()
+
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return errors.New("error reading the verification response")
+	}
+
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		p.log.Error("webhook verification fails", "status", response.StatusCode, "response", string(rawResponse))
+		return errors.New("error verifying the webhook signature")
+	}
+
+	var verificationResponse VerifyWebhookSignatureResponse
+	if err := json.Unmarshal(rawResponse, &verificationResponse); err != nil {
+		return errors.New("error decoding the verification response")
+	}
+
+	if verificationResponse.VerificationStatus != "SUCCESS" {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (p *PayPal) refundOrderId(ctx context.Context, refund WebhookResource) (string, error) {
+	var captureUrl string
+	for _, link := range refund.Links {
+		if link.Rel == "up" {
+			captureUrl = link.Href
+		}
+	}
+
+	if captureUrl == "" {
+		return "", errors.New("refund is not linked to a capture")
+	}
+
+	captureId := captureUrl[strings.LastIndex(captureUrl, "/")+1:]
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.basePath+"/v2/payments/captures/"+captureId, nil)
+	if err != nil {
+		return "", errors.New("error creating the capture request")
+	}
+
+	response, err := p.requestWrapper(*request)
+	if err != nil {
+		return "", errors.New("error requesting the capture")
+	}
+	defer response.Body.Close()
+
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", errors.New("error reading the capture")
+	}
+
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		return "", errors.New("error getting the capture " + response.Status)
+	}
+
+	var capture CaptureDetail
+	if err := json.Unmarshal(rawResponse, &capture); err != nil {
+		return "", errors.New("error decoding the capture")
+	}
+
+	return capture.SupplementaryData.RelatedIds.OrderId, nil
+}
@@ -0,0 +1,82 @@
+package processors_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"payment-processor.gary94746/main/lib/processors"
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+var approvedNotification = []byte(`{"id":"WH-1","event_type":"CHECKOUT.ORDER.APPROVED","resource":{"id":"ORDER-1"}}`)
+
+func initPayPal(t *testing.T, settings processors.PaymentSettings) *processors.PayPal {
+	t.Helper()
+
+	connector := &processors.PayPal{}
+	if err := connector.Init(settings); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return connector
+}
+
+func paypalHeaders() http.Header {
+	headers := http.Header{}
+	headers.Set("Paypal-Auth-Algo", "SHA256withRSA")
+	headers.Set("Paypal-Cert-Url", "https://api.paypal.com/v1/notifications/certs/CERT-1")
+	headers.Set("Paypal-Transmission-Id", "transmission-1")
+	headers.Set("Paypal-Transmission-Sig", "signature")
+	headers.Set("Paypal-Transmission-Time", "2024-06-20T10:00:00Z")
+	return headers
+}
+
+func TestPayPalWebhookVerification(t *testing.T) {
+	fake := processortest.NewFakePayPal(t)
+	connector := initPayPal(t, fake.Settings())
+
+	event, err := connector.ParseWebhook(context.Background(), approvedNotification, paypalHeaders())
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	if event.Type != processors.EventPaymentApproved || event.PrivateId != "ORDER-1" {
+		t.Errorf("event = %+v, want ORDER-1 approved", event)
+	}
+
+	for _, header := range []string{"Paypal-Transmission-Id", "Paypal-Transmission-Sig"} {
+		headers := paypalHeaders()
+		headers.Del(header)
+		if _, err := connector.ParseWebhook(context.Background(), approvedNotification, headers); !errors.Is(err, processors.ErrInvalidSignature) {
+			t.Errorf("ParseWebhook without %s = %v, want ErrInvalidSignature", header, err)
+		}
+	}
+
+	fake.WebhookVerification = "FAILURE"
+	if _, err := connector.ParseWebhook(context.Background(), approvedNotification, paypalHeaders()); !errors.Is(err, processors.ErrInvalidSignature) {
+		t.Errorf("ParseWebhook with a failed verification = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestPayPalWebhookOfAnotherWebhook(t *testing.T) {
+	fake := processortest.NewFakePayPal(t)
+	settings := fake.Settings()
+	settings.Credentials["webhook_id"] = "other-webhook-id"
+	connector := initPayPal(t, settings)
+
+	if _, err := connector.ParseWebhook(context.Background(), approvedNotification, paypalHeaders()); !errors.Is(err, processors.ErrInvalidSignature) {
+		t.Errorf("ParseWebhook for another webhook id = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestPayPalWebhookNotConfigured(t *testing.T) {
+	fake := processortest.NewFakePayPal(t)
+	settings := fake.Settings()
+	delete(settings.Credentials, "webhook_id")
+	connector := initPayPal(t, settings)
+
+	if _, err := connector.ParseWebhook(context.Background(), approvedNotification, paypalHeaders()); !errors.Is(err, processors.ErrWebhookNotConfigured) {
+		t.Errorf("ParseWebhook without a webhook id = %v, want ErrWebhookNotConfigured", err)
+	}
+}
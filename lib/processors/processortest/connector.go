@@ -0,0 +1,585 @@
+package processortest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+type Harness struct {
+	Connector          processors.PaymentConnector
+	Complete           func(privateId string)
+	FailNext           func(status int, body string)
+	FailRefund         func(n int)
+	DeclineNext        func()
+	ExpireCredentials  func()
+	CredentialRequests func() int
+	Reference          func(privateId string) string
+}
+
+type HarnessFactory func(t *testing.T) Harness
+
+func StripeHarness(t *testing.T) Harness {
+	fake := NewFakeStripe(t)
+
+	connector := &processors.Stripe{}
+	if err := connector.Init(fake.Settings()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return Harness{
+		Connector: connector,
+		Complete:  fake.Complete,
+		FailNext:  fake.FailNext,
+		Reference: fake.Reference,
+		DeclineNext: func() {
+			fake.FailNext(http.StatusPaymentRequired, `{"error":{"type":"card_error","code":"card_declined","decline_code":"insufficient_funds","message":"Your card has insufficient funds."}}`)
+		},
+	}
+}
+
+func PayPalHarness(t *testing.T) Harness {
+	fake := NewFakePayPal(t)
+
+	connector := &processors.PayPal{}
+	if err := connector.Init(fake.Settings()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return Harness{
+		Connector:          connector,
+		Complete:           fake.Approve,
+		FailNext:           fake.FailNext,
+		FailRefund:         fake.FailRefund,
+		ExpireCredentials:  fake.ExpireToken,
+		CredentialRequests: fake.TokenRequests,
+		Reference:          fake.Reference,
+		DeclineNext: func() {
+			fake.FailNext(http.StatusUnprocessableEntity, `{"name":"UNPROCESSABLE_ENTITY","message":"The requested action could not be performed.","details":[{"issue":"INSTRUMENT_DECLINED","description":"The instrument presented was declined."}]}`)
+		},
+	}
+}
+
+func NewPayment() processors.Payment {
+	return processors.Payment{
+		Currency:    "USD",
+		Amount:      2500,
+		RedirectUrl: "https://merchant.test/success",
+		CancelUrl:   "https://merchant.test/cancel",
+		LineItems: []processors.LineItem{
+			{Name: "first", Amount: 1000, Quantity: 1},
+			{Name: "second", Amount: 1500, Quantity: 1},
+		},
+	}
+}
+
+func RunConnector(t *testing.T, factory HarnessFactory) {
+	t.Run("Create", func(t *testing.T) { testCreate(t, factory(t)) })
+	t.Run("CaptureBeforeCompletion", func(t *testing.T) { testCaptureBeforeCompletion(t, factory(t)) })
+	t.Run("CaptureAndRefund", func(t *testing.T) { testCaptureAndRefund(t, factory(t)) })
+	t.Run("RefundBeforeCapture", func(t *testing.T) { testRefundBeforeCapture(t, factory(t)) })
+	t.Run("RefundOverCaptured", func(t *testing.T) { testRefundOverCaptured(t, factory(t)) })
+	t.Run("UnknownPayment", func(t *testing.T) { testUnknownPayment(t, factory(t)) })
+	t.Run("ProcessorUnavailable", func(t *testing.T) { testProcessorUnavailable(t, factory(t)) })
+	t.Run("TransientFailure", func(t *testing.T) { testTransientFailure(t, factory(t)) })
+	t.Run("Declined", func(t *testing.T) { testDeclined(t, factory(t)) })
+	t.Run("Canceled", func(t *testing.T) { testCanceled(t, factory(t)) })
+	t.Run("AuthorizeAndCapture", func(t *testing.T) { testAuthorizeAndCapture(t, factory(t)) })
+	t.Run("AuthorizeAndVoid", func(t *testing.T) { testAuthorizeAndVoid(t, factory(t)) })
+	t.Run("VoidAfterCapture", func(t *testing.T) { testVoidAfterCapture(t, factory(t)) })
+	t.Run("PartialCaptures", func(t *testing.T) { testPartialCaptures(t, factory(t)) })
+	t.Run("RefundAcrossCaptures", func(t *testing.T) { testRefundAcrossCaptures(t, factory(t)) })
+	t.Run("PartialRefundFailure", func(t *testing.T) { testPartialRefundFailure(t, factory(t)) })
+	t.Run("MinorUnits", func(t *testing.T) { testMinorUnits(t, factory) })
+	t.Run("Breakdown", func(t *testing.T) { testBreakdown(t, factory(t)) })
+	t.Run("Reference", func(t *testing.T) { testReference(t, factory(t)) })
+	t.Run("IdempotentCreate", func(t *testing.T) { testIdempotentCreate(t, factory(t)) })
+	t.Run("IdempotentRefund", func(t *testing.T) { testIdempotentRefund(t, factory(t)) })
+	t.Run("ExpiredCredentials", func(t *testing.T) { testExpiredCredentials(t, factory(t)) })
+	t.Run("SharedCredentials", func(t *testing.T) { testSharedCredentials(t, factory(t)) })
+}
+
+func create(t *testing.T, harness Harness) *processors.PaymentDetail {
+	t.Helper()
+
+	return createWith(t, harness, NewPayment())
+}
+
+func createWith(t *testing.T, harness Harness, payment processors.Payment) *processors.PaymentDetail {
+	t.Helper()
+
+	detail, err := harness.Connector.Create(context.Background(), payment)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	return detail
+}
+
+func authorize(t *testing.T, harness Harness) *processors.PaymentDetail {
+	t.Helper()
+
+	payment := NewPayment()
+	payment.CaptureMethod = processors.CaptureManual
+
+	detail := createWith(t, harness, payment)
+	harness.Complete(detail.PrivateId)
+
+	return detail
+}
+
+func testCreate(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+
+	if detail.PrivateId == "" {
+		t.Error("Create returned an empty PrivateId")
+	}
+
+	if detail.RedirectUrl == "" {
+		t.Error("Create returned an empty RedirectUrl")
+	}
+
+	other := create(t, harness)
+	if other.PrivateId == detail.PrivateId {
+		t.Errorf("Create returned the same PrivateId twice: %s", detail.PrivateId)
+	}
+}
+
+func testCaptureBeforeCompletion(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+
+	captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{})
+	if !errors.Is(err, processors.ErrConflict) {
+		t.Errorf("Capture before completion = %+v, %v, want a conflict", captured, err)
+	}
+}
+
+func testCaptureAndRefund(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+	harness.Complete(detail.PrivateId)
+
+	captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{})
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	if captured.Amount != 2500 || !captured.Final {
+		t.Errorf("Capture = %+v, want a final capture of 2500", captured)
+	}
+
+	refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	if refund.Id == "" {
+		t.Error("Refund returned an empty Id")
+	}
+
+	if refund.Amount != 1000 || refund.Currency != "USD" || refund.Status != processors.RefundSucceeded {
+		t.Errorf("Refund = %+v, want a succeeded USD refund of 1000", refund)
+	}
+
+	second, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000})
+	if err != nil {
+		t.Fatalf("second Refund: %v", err)
+	}
+
+	if second.Id == refund.Id {
+		t.Errorf("Refund returned the same Id twice: %s", refund.Id)
+	}
+}
+
+func testRefundBeforeCapture(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+
+	_, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000})
+	if !errors.Is(err, processors.ErrConflict) {
+		t.Errorf("Refund before capture = %v, want a conflict", err)
+	}
+}
+
+func testRefundOverCaptured(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+	harness.Complete(detail.PrivateId)
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	if _, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 2000}); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	if refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000}); !errors.Is(err, processors.ErrConflict) {
+		t.Errorf("Refund over the captured amount = %+v, %v, want a conflict", refund, err)
+	}
+}
+
+func testUnknownPayment(t *testing.T, harness Harness) {
+	if captured, err := harness.Connector.Capture(context.Background(), "unknown", processors.CaptureRequest{}); !errors.Is(err, processors.ErrNotFound) {
+		t.Errorf("Capture(unknown) = %+v, %v, want not found", captured, err)
+	}
+
+	if _, err := harness.Connector.Refund(context.Background(), "unknown", processors.PartialRefund{Amount: 1000}); !errors.Is(err, processors.ErrNotFound) {
+		t.Errorf("Refund(unknown) = %v, want not found", err)
+	}
+}
+
+func testProcessorUnavailable(t *testing.T, harness Harness) {
+	create(t, harness)
+	harness.FailNext(http.StatusServiceUnavailable, `{"message":"service unavailable"}`)
+
+	_, err := harness.Connector.Create(context.Background(), NewPayment())
+	if !errors.Is(err, processors.ErrUnavailable) {
+		t.Errorf("Create while the processor was unavailable = %v, want processor_unavailable", err)
+	}
+}
+
+func testCanceled(t *testing.T, harness Harness) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := harness.Connector.Create(ctx, NewPayment())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Create with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func testTransientFailure(t *testing.T, harness Harness) {
+	payment := NewPayment()
+	payment.IdempotencyKey = "create-after-outage"
+
+	harness.FailNext(http.StatusServiceUnavailable, `{"message":"service unavailable"}`)
+	harness.FailNext(http.StatusTooManyRequests, `{"message":"too many requests"}`)
+
+	if _, err := harness.Connector.Create(context.Background(), payment); err != nil {
+		t.Errorf("Create after transient failures = %v, want a retried success", err)
+	}
+}
+
+func testDeclined(t *testing.T, harness Harness) {
+	harness.DeclineNext()
+
+	_, err := harness.Connector.Create(context.Background(), NewPayment())
+	if !errors.Is(err, processors.ErrDeclined) {
+		t.Fatalf("Create = %v, want declined", err)
+	}
+
+	var processorErr *processors.ProcessorError
+	if !errors.As(err, &processorErr) || processorErr.Code == "" {
+		t.Errorf("Create error = %#v, want the processor code attached", err)
+	}
+}
+
+func testExpiredCredentials(t *testing.T, harness Harness) {
+	if harness.ExpireCredentials == nil {
+		t.Skip("connector has no expiring credentials")
+	}
+
+	create(t, harness)
+	harness.ExpireCredentials()
+	create(t, harness)
+
+	if harness.CredentialRequests != nil && harness.CredentialRequests() != 2 {
+		t.Errorf("credential requests = %d, want 2", harness.CredentialRequests())
+	}
+}
+
+func testSharedCredentials(t *testing.T, harness Harness) {
+	if harness.CredentialRequests == nil {
+		t.Skip("connector has no expiring credentials")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := harness.Connector.Create(context.Background(), NewPayment())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Create: %v", err)
+		}
+	}
+
+	if harness.CredentialRequests() != 1 {
+		t.Errorf("credential requests = %d, want 1 shared by concurrent calls", harness.CredentialRequests())
+	}
+}
+
+func testIdempotentCreate(t *testing.T, harness Harness) {
+	payment := NewPayment()
+	payment.IdempotencyKey = "create-once"
+
+	first, err := harness.Connector.Create(context.Background(), payment)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	retried, err := harness.Connector.Create(context.Background(), payment)
+	if err != nil {
+		t.Fatalf("retried Create: %v", err)
+	}
+
+	if retried.PrivateId != first.PrivateId {
+		t.Errorf("retried Create PrivateId = %q, want %q", retried.PrivateId, first.PrivateId)
+	}
+}
+
+func testIdempotentRefund(t *testing.T, harness Harness) {
+	detail := create(t, harness)
+	harness.Complete(detail.PrivateId)
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	refund := processors.PartialRefund{Amount: 1000, IdempotencyKey: "refund-once"}
+	first, err := harness.Connector.Refund(context.Background(), detail.PrivateId, refund)
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	retried, err := harness.Connector.Refund(context.Background(), detail.PrivateId, refund)
+	if err != nil {
+		t.Fatalf("retried Refund: %v", err)
+	}
+
+	if retried.Id != first.Id {
+		t.Errorf("retried Refund Id = %q, want %q", retried.Id, first.Id)
+	}
+}
+
+func testAuthorizeAndCapture(t *testing.T, harness Harness) {
+	detail := authorize(t, harness)
+
+	captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{})
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	if captured.Id == "" || captured.Amount != 2500 {
+		t.Errorf("Capture = %+v, want a capture of 2500", captured)
+	}
+
+	if _, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000}); err != nil {
+		t.Errorf("Refund after authorized capture: %v", err)
+	}
+}
+
+func testAuthorizeAndVoid(t *testing.T, harness Harness) {
+	detail := authorize(t, harness)
+
+	voided, err := harness.Connector.Void(context.Background(), detail.PrivateId, processors.VoidRequest{})
+	if err != nil || !voided {
+		t.Fatalf("Void = %v, %v, want true", voided, err)
+	}
+
+	if captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{}); err == nil {
+		t.Errorf("Capture after Void = %+v, want an error", captured)
+	}
+}
+
+func testVoidAfterCapture(t *testing.T, harness Harness) {
+	detail := authorize(t, harness)
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{}); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	if voided, err := harness.Connector.Void(context.Background(), detail.PrivateId, processors.VoidRequest{}); !errors.Is(err, processors.ErrConflict) || voided {
+		t.Errorf("Void after Capture = %v, %v, want a conflict", voided, err)
+	}
+}
+
+func testPartialCaptures(t *testing.T, harness Harness) {
+	detail := authorize(t, harness)
+
+	first, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1000})
+	if err != nil {
+		t.Fatalf("first Capture: %v", err)
+	}
+
+	if first.Amount != 1000 || first.Final {
+		t.Errorf("first Capture = %+v, want a non-final capture of 1000", first)
+	}
+
+	second, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1000, Final: true})
+	if err != nil {
+		t.Fatalf("second Capture: %v", err)
+	}
+
+	if second.Amount != 1000 || !second.Final {
+		t.Errorf("second Capture = %+v, want a final capture of 1000", second)
+	}
+
+	if second.Id == first.Id {
+		t.Errorf("Capture returned the same Id twice: %s", first.Id)
+	}
+}
+
+func testRefundAcrossCaptures(t *testing.T, harness Harness) {
+	detail := authorize(t, harness)
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1000}); err != nil {
+		t.Fatalf("first Capture: %v", err)
+	}
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1500, Final: true}); err != nil {
+		t.Fatalf("second Capture: %v", err)
+	}
+
+	refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 2000})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	if refund.Amount != 2000 || refund.Status != processors.RefundSucceeded {
+		t.Errorf("Refund = %+v, want a succeeded refund of 2000", refund)
+	}
+
+	if _, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 500}); err != nil {
+		t.Fatalf("Refund of the balance: %v", err)
+	}
+
+	if refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1}); !errors.Is(err, processors.ErrConflict) {
+		t.Errorf("Refund over the captured amount = %+v, %v, want a conflict", refund, err)
+	}
+}
+
+func testPartialRefundFailure(t *testing.T, harness Harness) {
+	if harness.FailRefund == nil {
+		t.Skip("connector refunds in a single call")
+	}
+
+	detail := authorize(t, harness)
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1000}); err != nil {
+		t.Fatalf("first Capture: %v", err)
+	}
+
+	if _, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{Amount: 1500, Final: true}); err != nil {
+		t.Fatalf("second Capture: %v", err)
+	}
+
+	harness.FailRefund(2)
+	refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 2000})
+
+	var partial *processors.PartialRefundError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Refund failing on the second capture = %+v, %v, want a PartialRefundError", refund, err)
+	}
+
+	if partial.Refund.Amount != 1000 || partial.Refund.Id == "" {
+		t.Errorf("partial refund = %+v, want the 1000 refunded from the first capture", partial.Refund)
+	}
+
+	if _, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1500}); err != nil {
+		t.Errorf("Refund of the balance left: %v", err)
+	}
+}
+
+func testMinorUnits(t *testing.T, factory HarnessFactory) {
+	amounts := []struct {
+		currency string
+		amount   int64
+		refund   int64
+	}{
+		{"USD", 1099, 599},
+		{"JPY", 1500, 7},
+		{"KWD", 12345, 1001},
+	}
+
+	for _, example := range amounts {
+		t.Run(example.currency, func(t *testing.T) {
+			harness := factory(t)
+
+			payment := NewPayment()
+			payment.Currency = example.currency
+			payment.Amount = example.amount
+			payment.LineItems = []processors.LineItem{{Name: "single", Amount: example.amount, Quantity: 1}}
+
+			detail := createWith(t, harness, payment)
+			harness.Complete(detail.PrivateId)
+
+			captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{})
+			if err != nil {
+				t.Fatalf("Capture: %v", err)
+			}
+
+			if captured.Amount != example.amount {
+				t.Errorf("Capture amount = %d, want %d", captured.Amount, example.amount)
+			}
+
+			refund, err := harness.Connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: example.refund})
+			if err != nil {
+				t.Fatalf("Refund: %v", err)
+			}
+
+			if refund.Amount != example.refund || refund.Currency != example.currency {
+				t.Errorf("Refund = %+v, want %d %s", refund, example.refund, example.currency)
+			}
+		})
+	}
+}
+
+func testBreakdown(t *testing.T, harness Harness) {
+	payment := NewPayment()
+	payment.LineItems = []processors.LineItem{
+		{Name: "first", Amount: 1000, Quantity: 2, Tax: 80, Sku: "SKU-1", Description: "first item"},
+	}
+	payment.Shipping = &processors.Shipping{
+		Name:   "Jane Doe",
+		Amount: 500,
+		Address: processors.Address{
+			Line1:      "1 Main St",
+			City:       "Springfield",
+			State:      "IL",
+			PostalCode: "62701",
+			Country:    "US",
+		},
+	}
+	payment.Discount = 300
+	payment.Amount = payment.Total()
+
+	detail := createWith(t, harness, payment)
+	harness.Complete(detail.PrivateId)
+
+	captured, err := harness.Connector.Capture(context.Background(), detail.PrivateId, processors.CaptureRequest{})
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	if captured.Amount != 2360 {
+		t.Errorf("Capture amount = %d, want 2360", captured.Amount)
+	}
+}
+
+func testReference(t *testing.T, harness Harness) {
+	payment := NewPayment()
+	payment.ReferenceId = "order-1001"
+	payment.Description = "Order 1001"
+	payment.Customer = &processors.Customer{Name: "Jane Doe", Email: "jane@example.com"}
+	payment.Metadata = map[string]string{"cart": "c-42"}
+
+	detail := createWith(t, harness, payment)
+	if harness.Reference == nil {
+		return
+	}
+
+	if reference := harness.Reference(detail.PrivateId); reference != payment.ReferenceId {
+		t.Errorf("processor reference = %q, want %q", reference, payment.ReferenceId)
+	}
+}
@@ -0,0 +1,86 @@
+package processortest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type failure struct {
+	status int
+	body   string
+}
+
+type fakeServer struct {
+	mu       sync.Mutex
+	failures []failure
+	requests int
+	replays  map[string]*httptest.ResponseRecorder
+}
+
+func (f *fakeServer) FailNext(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = append(f.failures, failure{status: status, body: body})
+}
+
+func (f *fakeServer) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+func (f *fakeServer) injectFailure(w http.ResponseWriter) bool {
+	f.requests++
+
+	if len(f.failures) == 0 {
+		return false
+	}
+
+	next := f.failures[0]
+	f.failures = f.failures[1:]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(next.status)
+	w.Write([]byte(next.body))
+	return true
+}
+
+func (f *fakeServer) idempotent(w http.ResponseWriter, r *http.Request, header string, route http.HandlerFunc) {
+	key := r.Header.Get(header)
+	if r.Method != http.MethodPost || key == "" {
+		route(w, r)
+		return
+	}
+
+	if f.replays == nil {
+		f.replays = map[string]*httptest.ResponseRecorder{}
+	}
+
+	recorded, found := f.replays[key]
+	if !found {
+		recorded = httptest.NewRecorder()
+		route(recorded, r)
+		f.replays[key] = recorded
+	}
+
+	for name, values := range recorded.Header() {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(recorded.Code)
+	w.Write(recorded.Body.Bytes())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func segments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
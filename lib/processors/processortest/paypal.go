@@ -0,0 +1,622 @@
+package processortest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"payment-processor.gary94746/main/lib/money"
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+type paypalOrder struct {
+	status              string
+	intent              string
+	currency            string
+	amount              int64
+	authorizationId     string
+	authorizationStatus string
+	captures            []paypalCapture
+	refunds             []paypalRefund
+	reference           string
+}
+
+type paypalRefund struct {
+	id        string
+	captureId string
+	amount    int64
+}
+
+type paypalCapture struct {
+	id     string
+	amount int64
+	final  bool
+}
+
+type FakePayPal struct {
+	fakeServer
+	Server              *httptest.Server
+	ClientId            string
+	Secret              string
+	WebhookId           string
+	WebhookVerification string
+	token               string
+	tokenRequests       int
+	orders              map[string]*paypalOrder
+	counter             int
+	refundsUntilFailure int
+}
+
+func NewFakePayPal(t testing.TB) *FakePayPal {
+	fake := &FakePayPal{
+		ClientId:            "fake-client-id",
+		Secret:              "fake-secret",
+		WebhookId:           "fake-webhook-id",
+		WebhookVerification: "SUCCESS",
+		orders:              map[string]*paypalOrder{},
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.Server.Close)
+
+	return fake
+}
+
+func (f *FakePayPal) Settings() processors.PaymentSettings {
+	return processors.PaymentSettings{
+		Credentials: map[string]string{
+			"client_id":           f.ClientId,
+			"client_token":        f.Secret,
+			"base_url":            f.Server.URL,
+			"webhook_id":          f.WebhookId,
+			"retry_base_delay_ms": "1",
+			"retry_max_delay_ms":  "10",
+		},
+	}
+}
+
+func (f *FakePayPal) Approve(orderId string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, found := f.orders[orderId]
+	if found && order.status == "CREATED" {
+		order.status = "APPROVED"
+	}
+}
+
+func (f *FakePayPal) Reference(orderId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, found := f.orders[orderId]
+	if !found {
+		return ""
+	}
+
+	return order.reference
+}
+
+func (f *FakePayPal) ExpireToken() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.token = ""
+}
+
+// FailRefund makes the nth capture refund from now fail, leaving the ones
+// before it in place.
+func (f *FakePayPal) FailRefund(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.refundsUntilFailure = n
+}
+
+func (f *FakePayPal) TokenRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.tokenRequests
+}
+
+func (f *FakePayPal) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.injectFailure(w) {
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/oauth2/token" {
+		f.issueToken(w, r)
+		return
+	}
+
+	if f.token == "" || r.Header.Get("Authorization") != "Bearer "+f.token {
+		paypalError(w, http.StatusUnauthorized, "AUTHENTICATION_FAILURE", "", "Authentication failed due to invalid authentication credentials or a missing Authorization header.")
+		return
+	}
+
+	f.idempotent(w, r, "PayPal-Request-Id", f.route)
+}
+
+func (f *FakePayPal) route(w http.ResponseWriter, r *http.Request) {
+	path := segments(r.URL.Path)
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/checkout/orders":
+		f.createOrder(w, r)
+	case r.Method == http.MethodGet && len(path) == 4 && path[1] == "checkout" && path[2] == "orders":
+		f.getOrder(w, path[3])
+	case r.Method == http.MethodPost && len(path) == 5 && path[1] == "checkout" && path[2] == "orders" && path[4] == "capture":
+		f.captureOrder(w, path[3])
+	case r.Method == http.MethodPost && len(path) == 5 && path[1] == "checkout" && path[2] == "orders" && path[4] == "authorize":
+		f.authorizeOrder(w, path[3])
+	case r.Method == http.MethodPost && len(path) == 5 && path[1] == "payments" && path[2] == "authorizations":
+		f.updateAuthorization(w, r, path[3], path[4])
+	case r.Method == http.MethodGet && len(path) == 4 && path[1] == "payments" && path[2] == "captures":
+		f.getCapture(w, path[3])
+	case r.Method == http.MethodPost && len(path) == 5 && path[1] == "payments" && path[2] == "captures" && path[4] == "refund":
+		f.refundCapture(w, r, path[3])
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/notifications/verify-webhook-signature":
+		f.verifyWebhook(w, r)
+	default:
+		paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "", "The specified resource does not exist.")
+	}
+}
+
+// verifyWebhook answers FAILURE for notifications of another webhook, as
+// PayPal does.
+func (f *FakePayPal) verifyWebhook(w http.ResponseWriter, r *http.Request) {
+	var verification processors.VerifyWebhookSignature
+	if err := json.NewDecoder(r.Body).Decode(&verification); err != nil {
+		paypalError(w, http.StatusBadRequest, "INVALID_REQUEST", "", "Request is not well-formed.")
+		return
+	}
+
+	status := f.WebhookVerification
+	if verification.WebhookId != f.WebhookId || len(verification.WebhookEvent) == 0 {
+		status = "FAILURE"
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"verification_status": status})
+}
+
+func (f *FakePayPal) issueToken(w http.ResponseWriter, r *http.Request) {
+	f.tokenRequests++
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != f.ClientId || password != f.Secret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "Client Authentication failed",
+		})
+		return
+	}
+
+	f.counter++
+	f.token = fmt.Sprintf("A21-fake-%d", f.counter)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": f.token,
+		"token_type":   "Bearer",
+		"expires_in":   32400,
+	})
+}
+
+func (f *FakePayPal) createOrder(w http.ResponseWriter, r *http.Request) {
+	var order processors.Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil || len(order.PurchaseUnits) == 0 {
+		paypalError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON", "Request is not well-formed, syntactically incorrect, or violates schema.")
+		return
+	}
+
+	amount, err := orderAmount(order.PurchaseUnits[0])
+	if err != nil {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", err.Error(), "The amount does not match the breakdown or has an invalid precision.")
+		return
+	}
+
+	f.counter++
+	orderId := fmt.Sprintf("ORDER-FAKE-%d", f.counter)
+	f.orders[orderId] = &paypalOrder{
+		status:    "CREATED",
+		intent:    order.Intent,
+		currency:  amount.Currency,
+		amount:    amount.Amount,
+		reference: order.PurchaseUnits[0].ReferenceId,
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":     orderId,
+		"status": "CREATED",
+		"links": []map[string]string{
+			{"href": f.Server.URL + "/v2/checkout/orders/" + orderId, "rel": "self", "method": "GET"},
+			{"href": f.Server.URL + "/checkoutnow?token=" + orderId, "rel": "approve", "method": "GET"},
+		},
+	})
+}
+
+func (f *FakePayPal) getOrder(w http.ResponseWriter, orderId string) {
+	order, found := f.orders[orderId]
+	if !found {
+		paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f.orderDetail(orderId, order))
+}
+
+func (f *FakePayPal) captureOrder(w http.ResponseWriter, orderId string) {
+	order, found := f.orders[orderId]
+	if !found {
+		paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+		return
+	}
+
+	if order.intent != "CAPTURE" {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ACTION_DOES_NOT_MATCH_INTENT", "Order was created with an intent to 'AUTHORIZE'.")
+		return
+	}
+
+	if order.status != "APPROVED" {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED", "Payer has not yet approved the Order for payment.")
+		return
+	}
+
+	f.counter++
+	order.status = "COMPLETED"
+	order.captures = append(order.captures, paypalCapture{
+		id:     fmt.Sprintf("CAPTURE-FAKE-%d", f.counter),
+		amount: order.amount,
+		final:  true,
+	})
+
+	writeJSON(w, http.StatusCreated, f.orderDetail(orderId, order))
+}
+
+func (f *FakePayPal) authorizeOrder(w http.ResponseWriter, orderId string) {
+	order, found := f.orders[orderId]
+	if !found {
+		paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+		return
+	}
+
+	if order.intent != "AUTHORIZE" {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ACTION_DOES_NOT_MATCH_INTENT", "Order was created with an intent to 'CAPTURE'.")
+		return
+	}
+
+	if order.status != "APPROVED" {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED", "Payer has not yet approved the Order for payment.")
+		return
+	}
+
+	f.counter++
+	order.status = "COMPLETED"
+	order.authorizationId = fmt.Sprintf("AUTHORIZATION-FAKE-%d", f.counter)
+	order.authorizationStatus = "CREATED"
+
+	writeJSON(w, http.StatusCreated, f.orderDetail(orderId, order))
+}
+
+func (f *FakePayPal) updateAuthorization(w http.ResponseWriter, r *http.Request, authorizationId string, action string) {
+	for _, order := range f.orders {
+		if order.authorizationId != authorizationId {
+			continue
+		}
+
+		if order.authorizationStatus != "CREATED" && order.authorizationStatus != "PARTIALLY_CAPTURED" {
+			paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "AUTHORIZATION_ALREADY_"+order.authorizationStatus, "Authorization is no longer valid.")
+			return
+		}
+
+		switch action {
+		case "capture":
+			f.captureAuthorization(w, r, order)
+		case "void":
+			order.authorizationStatus = "VOIDED"
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "", "The specified resource does not exist.")
+		}
+		return
+	}
+
+	paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+}
+
+func (f *FakePayPal) captureAuthorization(w http.ResponseWriter, r *http.Request, order *paypalOrder) {
+	var payload processors.AuthorizationCapture
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		paypalError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON", "Request is not well-formed, syntactically incorrect, or violates schema.")
+		return
+	}
+
+	captured := order.captured()
+	amount := order.amount - captured
+	if payload.Amount != nil {
+		parsed, err := money.Parse(payload.Amount.Value, order.currency)
+		if err != nil {
+			paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "DECIMAL_PRECISION", "The value of the field should not be more than the allowed decimal precision.")
+			return
+		}
+
+		amount = parsed.Amount
+	}
+
+	if amount <= 0 || captured+amount > order.amount {
+		paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "MAX_CAPTURE_AMOUNT_EXCEEDED", "Capture amount exceeds allowable limit.")
+		return
+	}
+
+	f.counter++
+	capture := paypalCapture{
+		id:     fmt.Sprintf("CAPTURE-FAKE-%d", f.counter),
+		amount: amount,
+		final:  payload.FinalCapture || captured+amount == order.amount,
+	}
+	order.captures = append(order.captures, capture)
+
+	order.authorizationStatus = "PARTIALLY_CAPTURED"
+	if capture.final {
+		order.authorizationStatus = "CAPTURED"
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":            capture.id,
+		"status":        "COMPLETED",
+		"amount":        order.value(capture.amount),
+		"final_capture": capture.final,
+	})
+}
+
+func (order *paypalOrder) value(amount int64) map[string]string {
+	return map[string]string{
+		"currency_code": order.currency,
+		"value":         money.New(amount, order.currency).Decimal(),
+	}
+}
+
+func (order *paypalOrder) captured() int64 {
+	var captured int64
+	for _, capture := range order.captures {
+		captured += capture.amount
+	}
+
+	return captured
+}
+
+func orderAmount(unit processors.PurchaseUnits) (money.Money, error) {
+	currency := unit.Amount.CurrencyCode
+	amount, err := money.Parse(unit.Amount.Value, currency)
+	if err != nil {
+		return money.Money{}, errors.New("DECIMAL_PRECISION")
+	}
+
+	if len(unit.Items) == 0 {
+		return amount, nil
+	}
+
+	var items, taxes int64
+	for _, item := range unit.Items {
+		unitAmount, err := money.Parse(item.UnitAmount.Value, currency)
+		if err != nil {
+			return money.Money{}, errors.New("DECIMAL_PRECISION")
+		}
+
+		quantity, err := strconv.ParseInt(item.Quantity, 10, 64)
+		if err != nil {
+			return money.Money{}, errors.New("INVALID_PARAMETER_VALUE")
+		}
+
+		tax, err := optionalValue(item.Tax, currency)
+		if err != nil {
+			return money.Money{}, err
+		}
+
+		items += unitAmount.Amount * quantity
+		taxes += tax * quantity
+	}
+
+	breakdown := unit.Amount.Breakdown
+	itemTotal, err := money.Parse(breakdown.ItemTotal.Value, currency)
+	if err != nil || itemTotal.Amount != items {
+		return money.Money{}, errors.New("ITEM_TOTAL_MISMATCH")
+	}
+
+	taxTotal, err := optionalValue(breakdown.TaxTotal, currency)
+	if err != nil || taxTotal != taxes {
+		return money.Money{}, errors.New("TAX_TOTAL_MISMATCH")
+	}
+
+	shipping, err := optionalValue(breakdown.Shipping, currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	discount, err := optionalValue(breakdown.Discount, currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	if amount.Amount != itemTotal.Amount+taxTotal+shipping-discount {
+		return money.Money{}, errors.New("AMOUNT_MISMATCH")
+	}
+
+	return amount, nil
+}
+
+func optionalValue(amount *processors.Amount, currency string) (int64, error) {
+	if amount == nil {
+		return 0, nil
+	}
+
+	value, err := money.Parse(amount.Value, currency)
+	if err != nil {
+		return 0, errors.New("DECIMAL_PRECISION")
+	}
+
+	return value.Amount, nil
+}
+
+func (order *paypalOrder) hasCapture(captureId string) bool {
+	return order.capture(captureId) != nil
+}
+
+func (order *paypalOrder) capture(captureId string) *paypalCapture {
+	for i := range order.captures {
+		if order.captures[i].id == captureId {
+			return &order.captures[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *FakePayPal) refundDetail(order *paypalOrder, refund paypalRefund) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     refund.id,
+		"status": "COMPLETED",
+		"amount": order.value(refund.amount),
+		"links": []map[string]string{
+			{"href": f.Server.URL + "/v2/payments/refunds/" + refund.id, "rel": "self", "method": "GET"},
+			{"href": f.Server.URL + "/v2/payments/captures/" + refund.captureId, "rel": "up", "method": "GET"},
+		},
+	}
+}
+
+func (f *FakePayPal) getCapture(w http.ResponseWriter, captureId string) {
+	for orderId, order := range f.orders {
+		if !order.hasCapture(captureId) {
+			continue
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     captureId,
+			"status": "COMPLETED",
+			"supplementary_data": map[string]interface{}{
+				"related_ids": map[string]string{"order_id": orderId},
+			},
+		})
+		return
+	}
+
+	paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+}
+
+func (f *FakePayPal) refundCapture(w http.ResponseWriter, r *http.Request, captureId string) {
+	var payload processors.Refund
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		paypalError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON", "Request is not well-formed, syntactically incorrect, or violates schema.")
+		return
+	}
+
+	if f.refundsUntilFailure > 0 {
+		f.refundsUntilFailure--
+		if f.refundsUntilFailure == 0 {
+			paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "REFUND_FAILED_INSUFFICIENT_FUNDS", "Capture could not be refunded due to insufficient funds.")
+			return
+		}
+	}
+
+	for _, order := range f.orders {
+		if !order.hasCapture(captureId) {
+			continue
+		}
+
+		captured := order.capture(captureId).amount
+		var refunded int64
+		for _, refund := range order.refunds {
+			if refund.captureId == captureId {
+				refunded += refund.amount
+			}
+		}
+
+		amount := captured - refunded
+		if payload.Amount.Value != "" {
+			parsed, err := money.Parse(payload.Amount.Value, order.currency)
+			if err != nil {
+				paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "DECIMAL_PRECISION", "The value of the field should not be more than the allowed decimal precision.")
+				return
+			}
+
+			amount = parsed.Amount
+		}
+
+		if amount <= 0 || refunded+amount > captured {
+			paypalError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "REFUND_AMOUNT_EXCEEDED", "The refund amount must be less than or equal to the capture amount that has not yet been refunded.")
+			return
+		}
+
+		f.counter++
+		refund := paypalRefund{id: fmt.Sprintf("REFUND-FAKE-%d", f.counter), captureId: captureId, amount: amount}
+		order.refunds = append(order.refunds, refund)
+
+		detail := f.refundDetail(order, refund)
+		detail["note_to_payer"] = payload.NoteToPayer
+		writeJSON(w, http.StatusCreated, detail)
+		return
+	}
+
+	paypalError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+}
+
+func (f *FakePayPal) orderDetail(orderId string, order *paypalOrder) map[string]interface{} {
+	amount := order.value(order.amount)
+
+	captures := []map[string]interface{}{}
+	for _, capture := range order.captures {
+		captures = append(captures, map[string]interface{}{
+			"id":            capture.id,
+			"status":        "COMPLETED",
+			"amount":        order.value(capture.amount),
+			"final_capture": capture.final,
+		})
+	}
+
+	refunds := []map[string]interface{}{}
+	for _, refund := range order.refunds {
+		refunds = append(refunds, f.refundDetail(order, refund))
+	}
+
+	authorizations := []map[string]interface{}{}
+	if order.authorizationId != "" {
+		authorizations = append(authorizations, map[string]interface{}{
+			"id":     order.authorizationId,
+			"status": order.authorizationStatus,
+			"amount": amount,
+		})
+	}
+
+	return map[string]interface{}{
+		"id":     orderId,
+		"intent": order.intent,
+		"status": order.status,
+		"purchase_units": []map[string]interface{}{{
+			"reference_id": "default",
+			"amount":       amount,
+			"payments": map[string]interface{}{
+				"authorizations": authorizations,
+				"captures":       captures,
+				"refunds":        refunds,
+			},
+		}},
+	}
+}
+
+func paypalError(w http.ResponseWriter, status int, name string, issue string, message string) {
+	body := map[string]interface{}{
+		"name":    name,
+		"message": message,
+	}
+
+	if issue != "" {
+		body["details"] = []map[string]string{{"issue": issue, "description": message}}
+	}
+
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,488 @@
+package processortest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+type stripeIntent struct {
+	status        string
+	captureMethod string
+	multicapture  bool
+	currency      string
+	amount        int64
+	received      int64
+	refunded      int64
+	charges       int
+	reference     string
+	refunds       []map[string]interface{}
+}
+
+type stripeSession struct {
+	intentId string
+	url      string
+}
+
+type FakeStripe struct {
+	fakeServer
+	Server        *httptest.Server
+	Token         string
+	WebhookSecret string
+	intents       map[string]*stripeIntent
+	sessions      map[string]*stripeSession
+	coupons       map[string]int64
+	counter       int
+}
+
+func NewFakeStripe(t testing.TB) *FakeStripe {
+	fake := &FakeStripe{
+		Token:         "sk_test_fake",
+		WebhookSecret: "whsec_fake",
+		intents:       map[string]*stripeIntent{},
+		sessions:      map[string]*stripeSession{},
+		coupons:       map[string]int64{},
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.Server.Close)
+
+	return fake
+}
+
+func (f *FakeStripe) Settings() processors.PaymentSettings {
+	return processors.PaymentSettings{
+		Credentials: map[string]string{
+			"token":               f.Token,
+			"base_url":            f.Server.URL,
+			"webhook_secret":      f.WebhookSecret,
+			"retry_base_delay_ms": "1",
+			"retry_max_delay_ms":  "10",
+		},
+	}
+}
+
+// Complete pays the checkout session or PaymentIntent with the given id.
+func (f *FakeStripe) Complete(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, found := f.intents[f.intentId(id)]
+	if !found {
+		return
+	}
+
+	if intent.captureMethod == "manual" {
+		intent.status = "requires_capture"
+		return
+	}
+
+	intent.status = "succeeded"
+	intent.received = intent.amount
+	intent.charges++
+}
+
+// Sign returns the headers Stripe sends with a webhook payload.
+func (f *FakeStripe) Sign(payload []byte) http.Header {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(f.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+
+	headers := http.Header{}
+	headers.Set("Stripe-Signature", "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	return headers
+}
+
+// IntentId returns the PaymentIntent of a checkout session.
+func (f *FakeStripe) IntentId(sessionId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.intentId(sessionId)
+}
+
+func (f *FakeStripe) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.injectFailure(w) {
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+f.Token {
+		stripeError(w, http.StatusUnauthorized, "invalid_request_error", "", "Invalid API Key provided")
+		return
+	}
+
+	f.idempotent(w, r, "Idempotency-Key", f.route)
+}
+
+func (f *FakeStripe) route(w http.ResponseWriter, r *http.Request) {
+	path := segments(r.URL.Path)
+	switch {
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "checkout" && path[1] == "sessions":
+		f.createSession(w, r)
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "checkout" && path[1] == "sessions":
+		f.listSessions(w, r)
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "checkout" && path[1] == "sessions":
+		f.getSession(w, path[2])
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "payment_intents":
+		f.getIntent(w, path[1])
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "payment_intents" && path[2] == "capture":
+		f.captureIntent(w, r, path[1])
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "payment_intents" && path[2] == "cancel":
+		f.cancelIntent(w, path[1])
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "coupons":
+		f.createCoupon(w, r)
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "refunds":
+		f.createRefund(w, r)
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "refunds":
+		f.listRefunds(w, r)
+	default:
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "Unrecognized request URL")
+	}
+}
+
+func (f *FakeStripe) Reference(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, found := f.intents[f.intentId(id)]
+	if !found {
+		return ""
+	}
+
+	return intent.reference
+}
+
+func (f *FakeStripe) createSession(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+
+	if r.PostForm.Get("success_url") == "" || r.PostForm.Get("cancel_url") == "" {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: success_url")
+		return
+	}
+
+	for key := range r.PostForm {
+		if strings.HasPrefix(key, "payment_intent_data[payment_method_options]") {
+			stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_unknown", "Received unknown parameter: "+key)
+			return
+		}
+	}
+
+	multicapture := r.PostForm.Get("payment_method_options[card][request_multicapture]")
+	if multicapture != "" && multicapture != "if_available" && multicapture != "never" {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "Invalid payment_method_options[card][request_multicapture]")
+		return
+	}
+
+	var amount int64
+	for index := 0; ; index++ {
+		prefix := fmt.Sprintf("line_items[%d]", index)
+		if r.PostForm.Get(prefix+"[quantity]") == "" {
+			break
+		}
+
+		// Stripe removed the inline amount, currency, name and description
+		// of line items in API version 2022-08-01.
+		for _, legacy := range []string{"[amount]", "[currency]", "[name]", "[description]"} {
+			if _, found := r.PostForm[prefix+legacy]; found {
+				stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_unknown", "Received unknown parameter: "+prefix+legacy)
+				return
+			}
+		}
+
+		if r.PostForm.Get(prefix+"[price_data][product_data][name]") == "" {
+			stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: "+prefix+"[price_data][product_data][name]")
+			return
+		}
+
+		unitAmount, _ := strconv.ParseInt(r.PostForm.Get(prefix+"[price_data][unit_amount]"), 10, 64)
+		quantity, _ := strconv.ParseInt(r.PostForm.Get(prefix+"[quantity]"), 10, 64)
+		amount += unitAmount * quantity
+	}
+
+	if amount == 0 {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: line_items")
+		return
+	}
+
+	shipping, _ := strconv.ParseInt(r.PostForm.Get("shipping_options[0][shipping_rate_data][fixed_amount][amount]"), 10, 64)
+	amount += shipping
+
+	if couponId := r.PostForm.Get("discounts[0][coupon]"); couponId != "" {
+		discount, found := f.coupons[couponId]
+		if !found {
+			stripeError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such coupon: "+couponId)
+			return
+		}
+
+		amount -= discount
+	}
+
+	f.counter++
+	intentId := fmt.Sprintf("pi_fake_%d", f.counter)
+	sessionId := fmt.Sprintf("cs_fake_%d", f.counter)
+	f.intents[intentId] = &stripeIntent{
+		status:        "requires_payment_method",
+		captureMethod: r.PostForm.Get("payment_intent_data[capture_method]"),
+		multicapture:  multicapture == "if_available",
+		currency:      strings.ToLower(r.PostForm.Get("line_items[0][price_data][currency]")),
+		amount:        amount,
+		reference:     r.PostForm.Get("client_reference_id"),
+	}
+
+	f.sessions[sessionId] = &stripeSession{intentId: intentId, url: f.Server.URL + "/pay/" + sessionId}
+
+	writeJSON(w, http.StatusOK, f.sessionDetail(sessionId))
+}
+
+func (f *FakeStripe) getSession(w http.ResponseWriter, sessionId string) {
+	if _, found := f.sessions[sessionId]; !found {
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such checkout.session: "+sessionId)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f.sessionDetail(sessionId))
+}
+
+func (f *FakeStripe) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := []map[string]interface{}{}
+	for sessionId, session := range f.sessions {
+		if session.intentId == r.URL.Query().Get("payment_intent") {
+			sessions = append(sessions, f.sessionDetail(sessionId))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":   "list",
+		"data":     sessions,
+		"has_more": false,
+	})
+}
+
+func (f *FakeStripe) sessionDetail(sessionId string) map[string]interface{} {
+	session := f.sessions[sessionId]
+	intent := f.intents[session.intentId]
+
+	status, paymentStatus := "open", "unpaid"
+	if intent.status == "succeeded" || intent.status == "requires_capture" {
+		status = "complete"
+	}
+	if intent.status == "succeeded" {
+		paymentStatus = "paid"
+	}
+
+	// Like Stripe, the PaymentIntent is only exposed once the session is
+	// completed.
+	var intentId interface{}
+	if status == "complete" {
+		intentId = session.intentId
+	}
+
+	return map[string]interface{}{
+		"id":             sessionId,
+		"object":         "checkout.session",
+		"url":            session.url,
+		"status":         status,
+		"payment_status": paymentStatus,
+		"payment_intent": intentId,
+	}
+}
+
+func (f *FakeStripe) intentId(id string) string {
+	if session, found := f.sessions[id]; found {
+		return session.intentId
+	}
+
+	return id
+}
+
+func (f *FakeStripe) createCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+
+	amountOff, err := strconv.ParseInt(r.PostForm.Get("amount_off"), 10, 64)
+	if err != nil || amountOff <= 0 || r.PostForm.Get("currency") == "" {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_missing", "Missing required param: amount_off")
+		return
+	}
+
+	f.counter++
+	couponId := fmt.Sprintf("coupon_fake_%d", f.counter)
+	f.coupons[couponId] = amountOff
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         couponId,
+		"amount_off": amountOff,
+		"currency":   strings.ToLower(r.PostForm.Get("currency")),
+		"duration":   r.PostForm.Get("duration"),
+	})
+}
+
+func (f *FakeStripe) getIntent(w http.ResponseWriter, intentId string) {
+	intent, found := f.intents[intentId]
+	if !found {
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent: "+intentId)
+		return
+	}
+
+	latestCharge := ""
+	if intent.charges > 0 {
+		latestCharge = fmt.Sprintf("ch_fake_%s_%d", intentId, intent.charges)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":              intentId,
+		"status":          intent.status,
+		"amount":          intent.amount,
+		"amount_received": intent.received,
+		"latest_charge":   latestCharge,
+	})
+}
+
+func (f *FakeStripe) captureIntent(w http.ResponseWriter, r *http.Request, intentId string) {
+	if err := r.ParseForm(); err != nil {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+
+	intent, found := f.intents[intentId]
+	if !found {
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent: "+intentId)
+		return
+	}
+
+	if intent.status != "requires_capture" {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "payment_intent_unexpected_state", "This PaymentIntent could not be captured because it has a status of "+intent.status)
+		return
+	}
+
+	amount := intent.amount - intent.received
+	if value := r.PostForm.Get("amount_to_capture"); value != "" {
+		amount, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	if amount <= 0 || intent.received+amount > intent.amount {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "amount_too_large", "Amount to capture is greater than the amount capturable")
+		return
+	}
+
+	isFinal := r.PostForm.Get("final_capture") != "false"
+	if !isFinal && !intent.multicapture {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", "final_capture requires payment_method_options[card][request_multicapture]")
+		return
+	}
+
+	intent.received += amount
+	intent.charges++
+	if isFinal || intent.received == intent.amount {
+		intent.status = "succeeded"
+	}
+
+	f.getIntent(w, intentId)
+}
+
+func (f *FakeStripe) cancelIntent(w http.ResponseWriter, intentId string) {
+	intent, found := f.intents[intentId]
+	if !found {
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent: "+intentId)
+		return
+	}
+
+	if intent.status == "succeeded" || intent.status == "canceled" {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "payment_intent_unexpected_state", "This PaymentIntent could not be canceled because it has a status of "+intent.status)
+		return
+	}
+
+	if intent.received > 0 {
+		intent.status = "succeeded"
+		f.getIntent(w, intentId)
+		return
+	}
+
+	intent.status = "canceled"
+	f.getIntent(w, intentId)
+}
+
+func (f *FakeStripe) createRefund(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "parameter_invalid", err.Error())
+		return
+	}
+
+	intentId := r.PostForm.Get("payment_intent")
+	intent, found := f.intents[intentId]
+	if !found {
+		stripeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such payment_intent: "+intentId)
+		return
+	}
+
+	if intent.received == 0 {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "charge_not_refundable", "This PaymentIntent has not been charged")
+		return
+	}
+
+	amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		amount = intent.received - intent.refunded
+	}
+
+	if intent.refunded+amount > intent.received {
+		stripeError(w, http.StatusBadRequest, "invalid_request_error", "charge_already_refunded", "Refund amount is greater than unrefunded amount")
+		return
+	}
+
+	intent.refunded += amount
+	f.counter++
+
+	refund := map[string]interface{}{
+		"id":             fmt.Sprintf("re_fake_%d", f.counter),
+		"object":         "refund",
+		"amount":         amount,
+		"currency":       intent.currency,
+		"payment_intent": intentId,
+		"status":         "succeeded",
+		"metadata":       map[string]string{"reason": r.PostForm.Get("metadata[reason]")},
+	}
+	intent.refunds = append(intent.refunds, refund)
+
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func (f *FakeStripe) listRefunds(w http.ResponseWriter, r *http.Request) {
+	refunds := []map[string]interface{}{}
+	if intent, found := f.intents[r.URL.Query().Get("payment_intent")]; found {
+		refunds = intent.refunds
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":   "list",
+		"data":     refunds,
+		"has_more": false,
+	})
+}
+
+func stripeError(w http.ResponseWriter, status int, errorType string, code string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{
+			"type":    errorType,
+			"code":    code,
+			"message": message,
+		},
+	})
+}
@@ -0,0 +1,19 @@
+package processors
+
+import "fmt"
+
+const (
+	ProcessorStripe = "stripe"
+	ProcessorPayPal = "paypal"
+)
+
+type Registry map[string]PaymentConnector
+
+func (r Registry) Get(name string) (PaymentConnector, error) {
+	connector, found := r[name]
+	if !found {
+		return nil, fmt.Errorf("processor %q is not registered", name)
+	}
+
+	return connector, nil
+}
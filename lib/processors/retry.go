@@ -0,0 +1,200 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpAttempts = expvar.NewMap("processor_http_attempts")
+	httpRetries  = expvar.NewMap("processor_http_retries")
+)
+
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func retryPolicyFrom(credentials map[string]string) RetryPolicy {
+	policy := RetryPolicy{
+		Attempts:  3,
+		BaseDelay: 200 * time.Millisecond,
+		MaxDelay:  5 * time.Second,
+	}
+
+	if attempts, err := strconv.Atoi(credentials["retry_attempts"]); err == nil && attempts > 0 {
+		policy.Attempts = attempts
+	}
+
+	if delay, err := strconv.Atoi(credentials["retry_base_delay_ms"]); err == nil && delay > 0 {
+		policy.BaseDelay = time.Duration(delay) * time.Millisecond
+	}
+
+	if delay, err := strconv.Atoi(credentials["retry_max_delay_ms"]); err == nil && delay > 0 {
+		policy.MaxDelay = time.Duration(delay) * time.Millisecond
+	}
+
+	return policy
+}
+
+// retryTransport retries requests that are safe to repeat, either because
+// the method is idempotent or because they carry an idempotency key, when
+// the processor answers 429/5xx or the connection fails.
+type retryTransport struct {
+	next              http.RoundTripper
+	policy            RetryPolicy
+	processor         string
+	idempotencyHeader string
+	log               *slog.Logger
+}
+
+func newRetryTransport(processor string, idempotencyHeader string, policy RetryPolicy, log *slog.Logger) *retryTransport {
+	return &retryTransport{
+		next:              http.DefaultTransport,
+		policy:            policy,
+		processor:         processor,
+		idempotencyHeader: idempotencyHeader,
+		log:               log,
+	}
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	retryable := t.retryable(request)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			request = request.Clone(ctx)
+			request.Body = body
+		}
+
+		response, err := t.next.RoundTrip(request)
+		httpAttempts.Add(t.processor, 1)
+
+		if !retryable || attempt >= t.policy.Attempts || !shouldRetry(ctx, response, err) {
+			if attempt > 1 {
+				t.log.Info("processor request finished after retries", "processor", t.processor,
+					"method", request.Method, "path", request.URL.Path, "attempts", attempt)
+			}
+
+			return response, err
+		}
+
+		delay := t.backoff(attempt, response)
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Until(deadline) < delay {
+			return response, err
+		}
+
+		status := 0
+		if response != nil {
+			status = response.StatusCode
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		httpRetries.Add(t.processor, 1)
+		t.log.Warn("retrying processor request", "processor", t.processor, "method", request.Method,
+			"path", request.URL.Path, "attempt", attempt, "status", status, "delay", delay.String())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *retryTransport) retryable(request *http.Request) bool {
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	if isOk, _ := request.Context().Value(repeatableKey{}).(bool); isOk {
+		return true
+	}
+
+	return request.Header.Get(t.idempotencyHeader) != ""
+}
+
+type repeatableKey struct{}
+
+// repeatable marks requests without side effects on the processor, such as
+// token grants, as safe to retry regardless of their method.
+func repeatable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, repeatableKey{}, true)
+}
+
+func (t *retryTransport) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if delay, found := retryAfter(response.Header.Get("Retry-After")); found {
+			if delay > t.policy.MaxDelay {
+				delay = t.policy.MaxDelay
+			}
+
+			return delay
+		}
+	}
+
+	delay := t.policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func shouldRetry(ctx context.Context, response *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+
+		return delay, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,154 @@
+package processors
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers status, with headers, to the first failures requests
+// and 200 afterwards.
+func flakyServer(t *testing.T, failures int64, status int, headers map[string]string) (*httptest.Server, *int64) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= failures {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func testTransport(policy RetryPolicy) *retryTransport {
+	return newRetryTransport("test", "Idempotency-Key", policy, slog.Default())
+}
+
+func send(t *testing.T, transport http.RoundTripper, ctx context.Context, method string, url string, key string) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if key != "" {
+		request.Header.Set("Idempotency-Key", key)
+	}
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	response.Body.Close()
+
+	return response
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusTooManyRequests, map[string]string{"Retry-After": "30"})
+	transport := testTransport(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond})
+
+	started := time.Now()
+	response := send(t, transport, context.Background(), http.MethodGet, server.URL, "")
+	elapsed := time.Since(started)
+
+	if response.StatusCode != http.StatusOK || atomic.LoadInt64(requests) != 2 {
+		t.Errorf("response = %d after %d requests, want 200 after 2", response.StatusCode, atomic.LoadInt64(requests))
+	}
+
+	if elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("retry waited %s, want Retry-After capped at 50ms", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		value string
+		delay time.Duration
+		found bool
+	}{
+		{"", 0, false},
+		{"2", 2 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{now.Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+
+	for _, c := range cases {
+		if delay, found := retryAfter(c.value); delay != c.delay || found != c.found {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", c.value, delay, found, c.delay, c.found)
+		}
+	}
+
+	delay, found := retryAfter(now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	if !found || delay <= 55*time.Second || delay > time.Minute {
+		t.Errorf("retryAfter of a date a minute away = %s, %v, want about a minute", delay, found)
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	transport := testTransport(RetryPolicy{Attempts: 100, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second})
+
+	for attempt := 1; attempt <= 80; attempt++ {
+		expected := 5 * time.Second
+		if attempt <= 5 {
+			expected = 200 * time.Millisecond << (attempt - 1)
+		}
+
+		for i := 0; i < 20; i++ {
+			if delay := transport.backoff(attempt, nil); delay < expected/2 || delay > expected {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, delay, expected/2, expected)
+			}
+		}
+	}
+}
+
+func TestRetryOnlyRepeatableRequests(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	cases := []struct {
+		name     string
+		method   string
+		key      string
+		requests int64
+	}{
+		{"post", http.MethodPost, "", 1},
+		{"post with an idempotency key", http.MethodPost, "key-1", 3},
+		{"get", http.MethodGet, "", 3},
+	}
+
+	for _, c := range cases {
+		server, requests := flakyServer(t, 10, http.StatusServiceUnavailable, nil)
+		response := send(t, testTransport(policy), context.Background(), c.method, server.URL, c.key)
+
+		if response.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt64(requests) != c.requests {
+			t.Errorf("%s: %d after %d requests, want 503 after %d", c.name, response.StatusCode, atomic.LoadInt64(requests), c.requests)
+		}
+	}
+}
+
+func TestRetryStopsBeforeTheDeadline(t *testing.T) {
+	server, requests := flakyServer(t, 10, http.StatusServiceUnavailable, map[string]string{"Retry-After": "1"})
+	transport := testTransport(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	response := send(t, transport, ctx, http.MethodGet, server.URL, "")
+	if response.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt64(requests) != 1 {
+		t.Errorf("%d after %d requests, want the first 503 returned without waiting", response.StatusCode, atomic.LoadInt64(requests))
+	}
+}
@@ -2,6 +2,7 @@ package processors
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,19 +12,28 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// stripeApiVersion pins the shape of Stripe responses to the one this
+// connector is written against, whatever the account default is.
+const stripeApiVersion = "2024-06-20"
+
 type Stripe struct {
-	client   *http.Client
-	token    string
-	log      slog.Logger
-	basePath string
+	client           *http.Client
+	token            string
+	log              slog.Logger
+	basePath         string
+	webhookSecret    string
+	webhookTolerance time.Duration
+	timeouts         Timeouts
 }
 
 func (s *Stripe) doRequest(request *http.Request) (*http.Response, error) {
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	request.Header.Add("Authorization", "Bearer "+s.token)
+	request.Header.Set("Stripe-Version", stripeApiVersion)
 
 	response, err := s.client.Do(request)
 
@@ -39,37 +49,107 @@ func (s *Stripe) Init(settings PaymentSettings) error {
 	s.basePath = "https://api.stripe.com/v1"
 	s.token = settings.Credentials["token"]
 
+	baseUrl := settings.Credentials["base_url"]
+	if baseUrl != "" {
+		s.basePath = baseUrl
+	}
+
+	s.webhookSecret = settings.Credentials["webhook_secret"]
+	s.webhookTolerance = seconds(settings.Credentials["webhook_tolerance"], 5*time.Minute)
+	s.timeouts = timeoutsFrom(settings.Credentials)
+
 	s.client = &http.Client{
-		Timeout: 60 * time.Second,
+		Transport: newRetryTransport("stripe", "Idempotency-Key", retryPolicyFrom(settings.Credentials), &s.log),
 	}
 
 	return nil
 }
 
-func (s *Stripe) Create(payment Payment) (*PaymentDetail, error) {
+func (s *Stripe) Create(ctx context.Context, payment Payment) (*PaymentDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
 	form := url.Values{}
-	for index, item := range payment.LineItems {
-		form.Add(fmt.Sprintf("line_items[%d][amount]", index), strconv.Itoa(int(item.Amount)))
-		form.Add(fmt.Sprintf("line_items[%d][currency]", index), payment.Currency)
-		form.Add(fmt.Sprintf("line_items[%d][name]", index), item.Name)
-		form.Add(fmt.Sprintf("line_items[%d][quantity]", index), strconv.Itoa(int(item.Quantity)))
+	items := append([]LineItem{}, payment.LineItems...)
+	if payment.TaxTotal() > 0 {
+		items = append(items, LineItem{Name: "Tax", Amount: payment.TaxTotal(), Quantity: 1})
+	}
+
+	for index, item := range items {
+		prefix := fmt.Sprintf("line_items[%d]", index)
+		form.Add(prefix+"[price_data][unit_amount]", strconv.Itoa(int(item.Amount)))
+		form.Add(prefix+"[price_data][currency]", payment.Currency)
+		form.Add(prefix+"[price_data][product_data][name]", item.Name)
+		if item.Description != "" {
+			form.Add(prefix+"[price_data][product_data][description]", item.Description)
+		}
+		form.Add(prefix+"[quantity]", strconv.Itoa(int(item.Quantity)))
+	}
+
+	if payment.ShippingAmount() > 0 {
+		form.Add("shipping_options[0][shipping_rate_data][type]", "fixed_amount")
+		form.Add("shipping_options[0][shipping_rate_data][display_name]", "Shipping")
+		form.Add("shipping_options[0][shipping_rate_data][fixed_amount][amount]", strconv.Itoa(int(payment.ShippingAmount())))
+		form.Add("shipping_options[0][shipping_rate_data][fixed_amount][currency]", payment.Currency)
+	}
+
+	if payment.Shipping != nil && !payment.Shipping.Address.IsZero() {
+		address := payment.Shipping.Address
+		form.Add("payment_intent_data[shipping][name]", payment.Shipping.Name)
+		form.Add("payment_intent_data[shipping][address][line1]", address.Line1)
+		form.Add("payment_intent_data[shipping][address][line2]", address.Line2)
+		form.Add("payment_intent_data[shipping][address][city]", address.City)
+		form.Add("payment_intent_data[shipping][address][state]", address.State)
+		form.Add("payment_intent_data[shipping][address][postal_code]", address.PostalCode)
+		form.Add("payment_intent_data[shipping][address][country]", address.Country)
+	}
+
+	if payment.Discount > 0 {
+		couponId, err := s.createCoupon(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+
+		form.Add("discounts[0][coupon]", couponId)
+	}
+
+	if payment.ReferenceId != "" {
+		form.Add("client_reference_id", payment.ReferenceId)
+	}
+
+	if payment.Description != "" {
+		form.Add("payment_intent_data[description]", payment.Description)
+	}
+
+	if payment.Customer != nil && payment.Customer.Email != "" {
+		form.Add("customer_email", payment.Customer.Email)
+	}
+
+	for key, value := range payment.Metadata {
+		form.Add("metadata["+key+"]", value)
+		form.Add("payment_intent_data[metadata]["+key+"]", value)
 	}
 
 	form.Add("cancel_url", payment.CancelUrl)
 	form.Add("success_url", payment.RedirectUrl)
 	form.Add("mode", "payment")
+	if payment.CaptureMethod == CaptureManual {
+		form.Add("payment_intent_data[capture_method]", "manual")
+		form.Add("payment_method_options[card][request_multicapture]", "if_available")
+	}
 
-	request, err := http.NewRequest(http.MethodPost, s.basePath+"/checkout/sessions", bytes.NewBuffer([]byte(form.Encode())))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.basePath+"/checkout/sessions", bytes.NewBuffer([]byte(form.Encode())))
 
 	if err != nil {
 		s.log.Error("error creating the request", "err", err.Error())
 		return nil, errors.New("error creating the request")
 	}
+	setIdempotencyKey(request, "Idempotency-Key", payment.IdempotencyKey)
 
 	response, err := s.doRequest(request)
 	if err != nil {
-		s.log.Error("error on request", "status", response.StatusCode)
-		return nil, errors.New("error on request: " + err.Error())
+		s.log.Error("error on request", "err", err.Error())
+		return nil, unavailableError("stripe", err)
 	}
 	defer response.Body.Close()
 
@@ -83,7 +163,7 @@ func (s *Stripe) Create(payment Payment) (*PaymentDetail, error) {
 	isOk := response.StatusCode == http.StatusOK
 	if !isOk {
 		s.log.Warn("request fails", "status", response.StatusCode, "body", string(decoded))
-		return nil, errors.New("Error requesting " + response.Status)
+		return nil, stripeError(response.StatusCode, decoded)
 	}
 
 	var checkout CheckoutResponse
@@ -95,38 +175,187 @@ func (s *Stripe) Create(payment Payment) (*PaymentDetail, error) {
 	}
 
 	return &PaymentDetail{
-		PrivateId:   checkout.PaymentIntent,
+		PrivateId:   checkout.Id,
 		RedirectUrl: checkout.Url,
 	}, nil
 }
 
-func (s *Stripe) Capture(id string) (bool, error) {
-	intent, err := s.getPaymentIntent(id)
+func (s *Stripe) Capture(ctx context.Context, id string, capture CaptureRequest) (*CaptureResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Capture)
+	defer cancel()
+
+	id, err := s.paymentIntentId(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	intent, err := s.getPaymentIntent(ctx, id)
 	if err != nil {
-		return false, errors.New("error getting sessionId")
+		return nil, err
 	}
 
 	isPaid := intent.Status == "succeeded"
-	if !isPaid {
-		return false, errors.New("payment intent is not paid")
+	if isPaid {
+		return &CaptureResponse{
+			Id:     intent.LatestCharge,
+			Amount: intent.AmountReceived,
+			Final:  true,
+		}, nil
+	}
+
+	isAuthorized := intent.Status == "requires_capture"
+	if !isAuthorized {
+		return nil, &ProcessorError{
+			Kind:      ErrorConflict,
+			Processor: "stripe",
+			Code:      intent.Status,
+			Message:   "payment intent is not paid",
+		}
+	}
+
+	form := url.Values{}
+	if capture.Amount > 0 {
+		form.Add("amount_to_capture", strconv.Itoa(int(capture.Amount)))
+	}
+	if capture.Amount > 0 && !capture.Final {
+		form.Add("final_capture", "false")
+	}
+
+	captured, err := s.updatePaymentIntent(ctx, id, "capture", form, capture.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := capture.Amount
+	if amount == 0 {
+		amount = captured.AmountReceived - intent.AmountReceived
+	}
+
+	return &CaptureResponse{
+		Id:     captured.LatestCharge,
+		Amount: amount,
+		Final:  captured.Status == "succeeded",
+	}, nil
+}
+
+func (s *Stripe) Void(ctx context.Context, id string, void VoidRequest) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Void)
+	defer cancel()
+
+	id, err := s.paymentIntentId(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	form := url.Values{}
+	form.Add("cancellation_reason", "requested_by_customer")
+
+	_, err = s.updatePaymentIntent(ctx, id, "cancel", form, void.IdempotencyKey)
+	if err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
 
-func (s *Stripe) Refund(paymentId string, refund PartialRefund) (*RefundResponse, error) {
+func (s *Stripe) createCoupon(ctx context.Context, payment Payment) (string, error) {
+	form := url.Values{}
+	form.Add("amount_off", strconv.Itoa(int(payment.Discount)))
+	form.Add("currency", payment.Currency)
+	form.Add("duration", "once")
+	form.Add("max_redemptions", "1")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.basePath+"/coupons", bytes.NewBuffer([]byte(form.Encode())))
+	if err != nil {
+		return "", errors.New("error creating the request")
+	}
+
+	if payment.IdempotencyKey != "" {
+		setIdempotencyKey(request, "Idempotency-Key", "coupon-"+payment.IdempotencyKey)
+	}
+
+	response, err := s.doRequest(request)
+	if err != nil {
+		return "", unavailableError("stripe", err)
+	}
+	defer response.Body.Close()
+
+	rawPayload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", errors.New("error reading the payload")
+	}
+
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		s.log.Warn("coupon creation fails", "status", response.StatusCode, "body", string(rawPayload))
+		return "", stripeError(response.StatusCode, rawPayload)
+	}
+
+	var coupon CouponResponse
+	if err := json.Unmarshal(rawPayload, &coupon); err != nil {
+		return "", errors.New("error parsing to json")
+	}
+
+	return coupon.Id, nil
+}
+
+func (s *Stripe) updatePaymentIntent(ctx context.Context, intentId string, action string, form url.Values, idempotencyKey string) (*PaymentIntentResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.basePath+"/payment_intents/"+intentId+"/"+action, bytes.NewBuffer([]byte(form.Encode())))
+	if err != nil {
+		return nil, errors.New("error creating the request")
+	}
+	setIdempotencyKey(request, "Idempotency-Key", idempotencyKey)
+
+	response, err := s.doRequest(request)
+	if err != nil {
+		return nil, unavailableError("stripe", err)
+	}
+	defer response.Body.Close()
+
+	rawPayload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New("error reading the payload")
+	}
+
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		s.log.Warn("payment intent "+action+" fails", "status", response.StatusCode, "body", string(rawPayload))
+		return nil, stripeError(response.StatusCode, rawPayload)
+	}
+
+	var intent PaymentIntentResponse
+	if err := json.Unmarshal(rawPayload, &intent); err != nil {
+		return nil, errors.New("error parsing to json")
+	}
+
+	return &intent, nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, paymentId string, refund PartialRefund) (*RefundResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Refund)
+	defer cancel()
+
+	intentId, err := s.paymentIntentId(ctx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+
 	form := url.Values{}
-	form.Add("payment_intent", paymentId)
+	form.Add("payment_intent", intentId)
 	form.Add("amount", strconv.Itoa(int(refund.Amount)))
+	if refund.Reason != "" {
+		form.Add("metadata[reason]", refund.Reason)
+	}
 
-	request, err := http.NewRequest(http.MethodPost, s.basePath+"/refunds", bytes.NewBuffer([]byte(form.Encode())))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.basePath+"/refunds", bytes.NewBuffer([]byte(form.Encode())))
 	if err != nil {
 		return nil, errors.New("error creating the request")
 	}
+	setIdempotencyKey(request, "Idempotency-Key", refund.IdempotencyKey)
 
 	response, err := s.doRequest(request)
 	if err != nil {
-		return nil, errors.New("error requesting refund")
+		return nil, unavailableError("stripe", err)
 	}
 
 	rawPayload, err := io.ReadAll(response.Body)
@@ -138,56 +367,161 @@ func (s *Stripe) Refund(paymentId string, refund PartialRefund) (*RefundResponse
 
 	isOk := response.StatusCode == http.StatusOK
 	if !isOk {
-		return nil, errors.New("response with status " + response.Status)
-	}
-
-	type CustomRefundResponse struct {
-		Id     string `json:"id"`
-		Amount int64  `json:"amount"`
+		s.log.Warn("refund fails", "status", response.StatusCode, "body", string(rawPayload))
+		return nil, stripeError(response.StatusCode, rawPayload)
 	}
 
-	var refundResponse CustomRefundResponse
+	var refundResponse StripeRefund
 	unmarshalError := json.Unmarshal(rawPayload, &refundResponse)
 	if unmarshalError != nil {
 		s.log.Error("decoding raw payload error", "message", string(rawPayload))
 		return nil, errors.New("error parsing the response: " + unmarshalError.Error())
 	}
 
+	return stripeRefundResponse(refundResponse), nil
+}
+
+func stripeError(status int, rawPayload []byte) error {
+	processorErr := &ProcessorError{
+		Kind:       statusKind(status),
+		Processor:  "stripe",
+		Message:    http.StatusText(status),
+		StatusCode: status,
+	}
+
+	var response StripeErrorResponse
+	if err := json.Unmarshal(rawPayload, &response); err != nil {
+		return processorErr
+	}
+
+	processorErr.Code = response.Error.Code
+	if response.Error.DeclineCode != "" {
+		processorErr.Code = response.Error.DeclineCode
+	}
+
+	if response.Error.Message != "" {
+		processorErr.Message = response.Error.Message
+	}
+
+	switch response.Error.Type {
+	case "card_error":
+		processorErr.Kind = ErrorDeclined
+	case "idempotency_error":
+		processorErr.Kind = ErrorConflict
+	}
+
+	switch response.Error.Code {
+	case "payment_intent_unexpected_state", "charge_already_captured", "charge_already_refunded", "charge_not_refundable":
+		processorErr.Kind = ErrorConflict
+	}
+
+	return processorErr
+}
+
+func stripeRefundResponse(refund StripeRefund) *RefundResponse {
+	status := RefundPending
+	switch refund.Status {
+	case "succeeded":
+		status = RefundSucceeded
+	case "failed", "canceled":
+		status = RefundFailed
+	}
+
 	return &RefundResponse{
-		Id:     refundResponse.Id,
-		Amount: strconv.Itoa(int(refundResponse.Amount)),
-	}, nil
+		Id:       refund.Id,
+		Amount:   refund.Amount,
+		Currency: strings.ToUpper(refund.Currency),
+		Status:   status,
+	}
 }
 
-func (s *Stripe) getPaymentIntent(intentId string) (*PaymentIntentResponse, error) {
-	request, err := http.NewRequest(http.MethodGet, s.basePath+"/payment_intents/"+intentId, nil)
+func (s *Stripe) getPaymentIntent(ctx context.Context, intentId string) (*PaymentIntentResponse, error) {
+	var intent PaymentIntentResponse
+	if err := s.get(ctx, "/payment_intents/"+intentId, &intent); err != nil {
+		return nil, err
+	}
+
+	return &intent, nil
+}
+
+// paymentIntentId resolves the PaymentIntent of a checkout session. Checkout
+// sessions only get one once the customer pays, so payments are stored with
+// the session id; older payments stored the PaymentIntent id itself.
+func (s *Stripe) paymentIntentId(ctx context.Context, id string) (string, error) {
+	if !strings.HasPrefix(id, "cs_") {
+		return id, nil
+	}
+
+	session, err := s.getCheckoutSession(ctx, id)
 	if err != nil {
-		return nil, errors.New("error creating request")
+		return "", err
 	}
 
-	response, err := s.doRequest(request)
+	if session.PaymentIntent == "" {
+		return "", &ProcessorError{
+			Kind:      ErrorConflict,
+			Processor: "stripe",
+			Code:      session.Status,
+			Message:   "checkout session is not paid",
+		}
+	}
+
+	return session.PaymentIntent, nil
+}
+
+func (s *Stripe) getCheckoutSession(ctx context.Context, sessionId string) (*CheckoutSessionObject, error) {
+	var session CheckoutSessionObject
+	if err := s.get(ctx, "/checkout/sessions/"+sessionId, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// checkoutSessionId returns the checkout session a PaymentIntent was created
+// by, or the PaymentIntent id when it was not created by one.
+func (s *Stripe) checkoutSessionId(ctx context.Context, intentId string) (string, error) {
+	var sessions struct {
+		Data []CheckoutSessionObject `json:"data"`
+	}
+	query := url.Values{"payment_intent": {intentId}, "limit": {"1"}}
+	if err := s.get(ctx, "/checkout/sessions?"+query.Encode(), &sessions); err != nil {
+		return "", err
+	}
+
+	if len(sessions.Data) == 0 {
+		return intentId, nil
+	}
+
+	return sessions.Data[0].Id, nil
+}
+
+func (s *Stripe) get(ctx context.Context, path string, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.basePath+path, nil)
 	if err != nil {
-		return nil, errors.New("Error requesting" + response.Status)
+		return errors.New("error creating request")
 	}
 
-	isOk := response.StatusCode == http.StatusOK
-	if !isOk {
-		return nil, errors.New("error getting session information " + response.Status)
+	response, err := s.doRequest(request)
+	if err != nil {
+		return unavailableError("stripe", err)
 	}
+	defer response.Body.Close()
 
 	rawPayload, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, errors.New("error decoding body")
+		return errors.New("error decoding body")
 	}
 
-	defer response.Body.Close()
+	isOk := response.StatusCode == http.StatusOK
+	if !isOk {
+		return stripeError(response.StatusCode, rawPayload)
+	}
 
-	var sessionDetail PaymentIntentResponse
-	unmarshalError := json.Unmarshal(rawPayload, &sessionDetail)
-	if unmarshalError != nil {
+	if err := json.Unmarshal(rawPayload, target); err != nil {
 		s.log.Error("decoding error", "message", string(rawPayload))
-		return nil, errors.New("error parsing to json")
+		return errors.New("error parsing to json")
 	}
 
-	return &sessionDetail, nil
+	return nil
 }
@@ -0,0 +1,11 @@
+package processors_test
+
+import (
+	"testing"
+
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+func TestStripe(t *testing.T) {
+	processortest.RunConnector(t, processortest.StripeHarness)
+}
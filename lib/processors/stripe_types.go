@@ -1,11 +1,58 @@
 package processors
 
+import "encoding/json"
+
 type CheckoutResponse struct {
 	Url           string `json:"url"`
 	Id            string `json:"id"`
 	PaymentIntent string `json:"payment_intent"`
 }
 type PaymentIntentResponse struct {
-	Id     string `json:"id"`
-	Status string `json:"status"`
+	Id             string `json:"id"`
+	Status         string `json:"status"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	LatestCharge   string `json:"latest_charge"`
+}
+
+type CouponResponse struct {
+	Id string `json:"id"`
+}
+
+type StripeErrorResponse struct {
+	Error struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+		Message     string `json:"message"`
+	} `json:"error"`
+}
+
+type StripeEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type CheckoutSessionObject struct {
+	Id            string `json:"id"`
+	Status        string `json:"status"`
+	PaymentIntent string `json:"payment_intent"`
+	PaymentStatus string `json:"payment_status"`
+}
+
+type ChargeObject struct {
+	Id             string `json:"id"`
+	PaymentIntent  string `json:"payment_intent"`
+	AmountRefunded int64  `json:"amount_refunded"`
+}
+
+type StripeRefund struct {
+	Id            string `json:"id"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	PaymentIntent string `json:"payment_intent"`
 }
@@ -0,0 +1,172 @@
+package processors
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var stripeIntentEvents = map[string]string{
+	"payment_intent.succeeded":                 EventPaymentCaptured,
+	"payment_intent.payment_failed":            EventPaymentFailed,
+	"payment_intent.amount_capturable_updated": EventPaymentAuthorized,
+	"payment_intent.canceled":                  EventPaymentVoided,
+}
+
+func (s *Stripe) ParseWebhook(ctx context.Context, payload []byte, headers http.Header) (*WebhookEvent, error) {
+	err := s.verifySignature(payload, headers.Get("Stripe-Signature"))
+	if err != nil {
+		s.log.Warn("stripe webhook rejected", "err", err.Error())
+		return nil, err
+	}
+
+	var event StripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, errors.New("error decoding the event")
+	}
+
+	webhookEvent := &WebhookEvent{Id: event.Id}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var session CheckoutSessionObject
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, errors.New("error decoding the checkout session")
+		}
+
+		if session.PaymentStatus == "paid" {
+			webhookEvent.Type = EventPaymentCaptured
+		}
+		webhookEvent.PrivateId = session.Id
+	case "payment_intent.succeeded", "payment_intent.payment_failed", "payment_intent.amount_capturable_updated", "payment_intent.canceled":
+		var intent PaymentIntentResponse
+		if err := json.Unmarshal(event.Data.Object, &intent); err != nil {
+			return nil, errors.New("error decoding the payment intent")
+		}
+
+		webhookEvent.Type = stripeIntentEvents[event.Type]
+		webhookEvent.PrivateId, err = s.checkoutSessionId(ctx, intent.Id)
+		if err != nil {
+			return nil, err
+		}
+	case "checkout.session.expired":
+		var session CheckoutSessionObject
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, errors.New("error decoding the checkout session")
+		}
+
+		webhookEvent.Type = EventPaymentExpired
+		webhookEvent.PrivateId = session.Id
+	case "charge.refunded":
+		// Charges no longer embed their refunds since API version
+		// 2022-11-15, so they are listed from the PaymentIntent.
+		var charge ChargeObject
+		if err := json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return nil, errors.New("error decoding the charge")
+		}
+
+		refunds, err := s.listRefunds(ctx, charge.PaymentIntent)
+		if err != nil {
+			return nil, err
+		}
+
+		webhookEvent.Type = EventPaymentRefunded
+		webhookEvent.PrivateId, err = s.checkoutSessionId(ctx, charge.PaymentIntent)
+		if err != nil {
+			return nil, err
+		}
+		for _, refund := range refunds {
+			webhookEvent.Refunds = append(webhookEvent.Refunds, *stripeRefundResponse(refund))
+		}
+	case "refund.created", "refund.updated", "charge.refund.updated":
+		var refund StripeRefund
+		if err := json.Unmarshal(event.Data.Object, &refund); err != nil {
+			return nil, errors.New("error decoding the refund")
+		}
+
+		webhookEvent.Type = EventPaymentRefunded
+		webhookEvent.PrivateId, err = s.checkoutSessionId(ctx, refund.PaymentIntent)
+		if err != nil {
+			return nil, err
+		}
+		webhookEvent.Refunds = []RefundResponse{*stripeRefundResponse(refund)}
+	default:
+		s.log.Info("stripe webhook ignored", "type", event.Type, "id", event.Id)
+	}
+
+	return webhookEvent, nil
+}
+
+func (s *Stripe) verifySignature(payload []byte, header string) error {
+	if s.webhookSecret == "" {
+		return ErrWebhookNotConfigured
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > s.webhookTolerance || age < -s.webhookTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(expected, decoded) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func (s *Stripe) listRefunds(ctx context.Context, intentId string) ([]StripeRefund, error) {
+	var refunds struct {
+		Data []StripeRefund `json:"data"`
+	}
+	query := url.Values{"payment_intent": {intentId}, "limit": {"100"}}
+	if err := s.get(ctx, "/refunds?"+query.Encode(), &refunds); err != nil {
+		return nil, err
+	}
+
+	return refunds.Data, nil
+}
@@ -0,0 +1,202 @@
+package processors_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/lib/processors"
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+func newStripe(t *testing.T) (*processors.Stripe, *processortest.FakeStripe) {
+	t.Helper()
+
+	fake := processortest.NewFakeStripe(t)
+	return initStripe(t, fake.Settings()), fake
+}
+
+func initStripe(t *testing.T, settings processors.PaymentSettings) *processors.Stripe {
+	t.Helper()
+
+	connector := &processors.Stripe{}
+	if err := connector.Init(settings); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return connector
+}
+
+func stripeSignature(secret string, timestamp time.Time, payload []byte) http.Header {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(payload)
+
+	headers := http.Header{}
+	headers.Set("Stripe-Signature", "t="+unix+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	return headers
+}
+
+func stripeEvent(t *testing.T, eventType string, object map[string]interface{}) []byte {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":   "evt_test",
+		"type": eventType,
+		"data": map[string]interface{}{"object": object},
+	})
+	if err != nil {
+		t.Fatalf("encoding the event: %v", err)
+	}
+
+	return payload
+}
+
+func TestStripeWebhookChargeRefunded(t *testing.T) {
+	connector, fake := newStripe(t)
+
+	detail, err := connector.Create(context.Background(), processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	fake.Complete(detail.PrivateId)
+
+	refund, err := connector.Refund(context.Background(), detail.PrivateId, processors.PartialRefund{Amount: 1000})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+
+	// Under the pinned API version the charge carries no refunds list.
+	payload := stripeEvent(t, "charge.refunded", map[string]interface{}{
+		"id":              "ch_test",
+		"payment_intent":  fake.IntentId(detail.PrivateId),
+		"amount_refunded": 1000,
+	})
+
+	event, err := connector.ParseWebhook(context.Background(), payload, fake.Sign(payload))
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+
+	if event.Type != processors.EventPaymentRefunded || event.PrivateId != detail.PrivateId {
+		t.Errorf("event = %+v, want payment.refunded for %s", event, detail.PrivateId)
+	}
+
+	if len(event.Refunds) != 1 || event.Refunds[0].Id != refund.Id || event.Refunds[0].Amount != 1000 {
+		t.Errorf("refunds = %+v, want %s of 1000", event.Refunds, refund.Id)
+	}
+}
+
+func TestStripeWebhookRefundUpdated(t *testing.T) {
+	connector, fake := newStripe(t)
+
+	detail, err := connector.Create(context.Background(), processortest.NewPayment())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	fake.Complete(detail.PrivateId)
+
+	for _, eventType := range []string{"refund.created", "refund.updated", "charge.refund.updated"} {
+		payload := stripeEvent(t, eventType, map[string]interface{}{
+			"id":             "re_test",
+			"object":         "refund",
+			"amount":         500,
+			"currency":       "usd",
+			"status":         "failed",
+			"payment_intent": fake.IntentId(detail.PrivateId),
+		})
+
+		event, err := connector.ParseWebhook(context.Background(), payload, fake.Sign(payload))
+		if err != nil {
+			t.Fatalf("ParseWebhook(%s): %v", eventType, err)
+		}
+
+		expected := processors.RefundResponse{Id: "re_test", Amount: 500, Currency: "USD", Status: processors.RefundFailed}
+		if event.PrivateId != detail.PrivateId || len(event.Refunds) != 1 || event.Refunds[0] != expected {
+			t.Errorf("%s = %+v, want %+v for %s", eventType, event, expected, detail.PrivateId)
+		}
+	}
+}
+
+func TestStripeWebhookSessionExpired(t *testing.T) {
+	connector, fake := newStripe(t)
+
+	payload := stripeEvent(t, "checkout.session.expired", map[string]interface{}{
+		"id":     "cs_expired",
+		"object": "checkout.session",
+		"status": "expired",
+	})
+
+	event, err := connector.ParseWebhook(context.Background(), payload, fake.Sign(payload))
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+
+	if event.Type != processors.EventPaymentExpired || event.PrivateId != "cs_expired" {
+		t.Errorf("event = %+v, want payment.expired for cs_expired", event)
+	}
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	connector, fake := newStripe(t)
+	payload := stripeEvent(t, "checkout.session.expired", map[string]interface{}{"id": "cs_signed"})
+	now := time.Now()
+	signed := stripeSignature(fake.WebhookSecret, now, payload).Get("Stripe-Signature")
+	valid := signed[strings.Index(signed, ",")+1:]
+
+	cases := []struct {
+		name    string
+		headers http.Header
+		payload []byte
+		valid   bool
+	}{
+		{"signed", stripeSignature(fake.WebhookSecret, now, payload), payload, true},
+		{"within tolerance", stripeSignature(fake.WebhookSecret, now.Add(-4*time.Minute), payload), payload, true},
+		{"too old", stripeSignature(fake.WebhookSecret, now.Add(-6*time.Minute), payload), payload, false},
+		{"from the future", stripeSignature(fake.WebhookSecret, now.Add(6*time.Minute), payload), payload, false},
+		{"another secret", stripeSignature("whsec_other", now, payload), payload, false},
+		{"tampered body", stripeSignature(fake.WebhookSecret, now, payload), stripeEvent(t, "checkout.session.expired", map[string]interface{}{"id": "cs_other"}), false},
+		{"several signatures", http.Header{"Stripe-Signature": {"t=" + strconv.FormatInt(now.Unix(), 10) + ",v1=00ff," + valid}}, payload, true},
+		{"no timestamp", http.Header{"Stripe-Signature": {valid}}, payload, false},
+		{"not hex", http.Header{"Stripe-Signature": {"t=" + strconv.FormatInt(now.Unix(), 10) + ",v1=zz"}}, payload, false},
+		{"missing", http.Header{}, payload, false},
+	}
+
+	for _, c := range cases {
+		_, err := connector.ParseWebhook(context.Background(), c.payload, c.headers)
+		if c.valid && err != nil {
+			t.Errorf("%s: ParseWebhook = %v, want nil", c.name, err)
+		}
+		if !c.valid && !errors.Is(err, processors.ErrInvalidSignature) {
+			t.Errorf("%s: ParseWebhook = %v, want ErrInvalidSignature", c.name, err)
+		}
+	}
+
+	settings := fake.Settings()
+	settings.Credentials["webhook_tolerance"] = "900"
+	lenient := initStripe(t, settings)
+	if _, err := lenient.ParseWebhook(context.Background(), payload, stripeSignature(fake.WebhookSecret, now.Add(-10*time.Minute), payload)); err != nil {
+		t.Errorf("ParseWebhook within a 900 second tolerance = %v, want nil", err)
+	}
+}
+
+func TestStripeWebhookNotConfigured(t *testing.T) {
+	fake := processortest.NewFakeStripe(t)
+	settings := fake.Settings()
+	delete(settings.Credentials, "webhook_secret")
+	connector := initStripe(t, settings)
+
+	payload := stripeEvent(t, "checkout.session.expired", map[string]interface{}{"id": "cs_unsigned"})
+	if _, err := connector.ParseWebhook(context.Background(), payload, fake.Sign(payload)); !errors.Is(err, processors.ErrWebhookNotConfigured) {
+		t.Errorf("ParseWebhook without a secret = %v, want ErrWebhookNotConfigured", err)
+	}
+}
@@ -0,0 +1,37 @@
+package processors
+
+import (
+	"strconv"
+	"time"
+)
+
+const defaultTimeout = 60 * time.Second
+
+type Timeouts struct {
+	Create  time.Duration
+	Capture time.Duration
+	Refund  time.Duration
+	Void    time.Duration
+}
+
+// timeoutsFrom reads per-operation deadlines in seconds from the settings,
+// falling back to "timeout" and then to defaultTimeout.
+func timeoutsFrom(credentials map[string]string) Timeouts {
+	fallback := seconds(credentials["timeout"], defaultTimeout)
+
+	return Timeouts{
+		Create:  seconds(credentials["create_timeout"], fallback),
+		Capture: seconds(credentials["capture_timeout"], fallback),
+		Refund:  seconds(credentials["refund_timeout"], fallback),
+		Void:    seconds(credentials["void_timeout"], fallback),
+	}
+}
+
+func seconds(value string, fallback time.Duration) time.Duration {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return fallback
+	}
+
+	return time.Duration(parsed) * time.Second
+}
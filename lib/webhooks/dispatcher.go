@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"payment-processor.gary94746/main/lib/database"
+)
+
+var deliveries = expvar.NewMap("webhook_deliveries")
+
+type Settings struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+	BatchSize    int
+	// AllowPrivateNetworks lets deliveries reach internal addresses, for
+	// local development only.
+	AllowPrivateNetworks bool
+}
+
+func SettingsFrom(values map[string]string) Settings {
+	settings := Settings{
+		MaxAttempts:  8,
+		BaseDelay:    seconds(values["base_delay"], 30*time.Second),
+		MaxDelay:     seconds(values["max_delay"], 6*time.Hour),
+		PollInterval: seconds(values["poll_interval"], 5*time.Second),
+		Timeout:      seconds(values["timeout"], 10*time.Second),
+		BatchSize:    50,
+
+		AllowPrivateNetworks: values["allow_private_networks"] == "true",
+	}
+
+	if attempts, err := strconv.Atoi(values["max_attempts"]); err == nil && attempts > 0 {
+		settings.MaxAttempts = attempts
+	}
+
+	return settings
+}
+
+// Envelope is the body posted to subscribers.
+type Envelope struct {
+	Id        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Dispatcher delivers queued events to their subscriptions. Failed
+// deliveries are retried with exponential backoff and end up dead after
+// MaxAttempts, where they stay until the event is replayed.
+type Dispatcher struct {
+	store    database.WebhookStore
+	client   *http.Client
+	settings Settings
+	log      *slog.Logger
+	now      func() time.Time
+}
+
+func NewDispatcher(store database.WebhookStore, settings Settings) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		client:   newClient(settings),
+		settings: settings,
+		log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		now:      time.Now,
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.settings.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			d.log.Error("error delivering webhooks", "detail", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every delivery that is due and returns how many were sent.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	ctx = database.Unscoped(ctx)
+	sent := 0
+	for {
+		// The deliveries of a batch are sent in parallel, so the lease only
+		// has to outlive a single send.
+		lease := d.settings.Timeout * 2
+		claimed, err := d.store.ClaimDeliveries(ctx, d.now(), lease, d.settings.BatchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		errs := make([]error, len(claimed))
+		var wg sync.WaitGroup
+		for index, delivery := range claimed {
+			wg.Add(1)
+			go func(index int, delivery database.WebhookDelivery) {
+				defer wg.Done()
+				errs[index] = d.deliver(ctx, delivery)
+			}(index, delivery)
+		}
+		wg.Wait()
+
+		var failed error
+		for _, err := range errs {
+			if err != nil {
+				failed = err
+				continue
+			}
+			sent++
+		}
+
+		if failed != nil {
+			return sent, failed
+		}
+
+		if len(claimed) < d.settings.BatchSize {
+			return sent, nil
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.WebhookDelivery) error {
+	subscription, err := d.store.FindSubscription(ctx, delivery.SubscriptionId)
+	if err != nil {
+		return err
+	}
+
+	event, err := d.store.FindEvent(ctx, delivery.EventId)
+	if err != nil {
+		return err
+	}
+
+	statusCode, err := d.send(ctx, subscription, event)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = database.DeliverySucceeded
+	case delivery.Attempts >= d.settings.MaxAttempts:
+		delivery.Status = database.DeliveryDead
+		delivery.LastError = err.Error()
+		d.log.Warn("webhook delivery is dead", "delivery", delivery.Id, "event", event.Id, "url", subscription.Url, "attempts", delivery.Attempts)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = d.now().Add(d.backoff(delivery.Attempts))
+	}
+
+	deliveries.Add(delivery.Status, 1)
+	return d.store.UpdateDelivery(ctx, delivery)
+}
+
+func (d *Dispatcher) send(ctx context.Context, subscription *database.WebhookSubscription, event *database.WebhookEvent) (int, error) {
+	body, err := json.Marshal(Envelope{
+		Id:        event.Id,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(IdHeader, event.Id)
+	request.Header.Set(EventHeader, event.Type)
+	request.Header.Set(SignatureHeader, Sign(subscription.Secret, d.now(), body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, errors.New("subscriber answered " + response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.settings.BaseDelay << (attempts - 1)
+	if delay <= 0 || delay > d.settings.MaxDelay {
+		delay = d.settings.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func seconds(value string, fallback time.Duration) time.Duration {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return fallback
+	}
+
+	return time.Duration(parsed) * time.Second
+}
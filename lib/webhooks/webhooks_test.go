@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payment-processor.gary94746/main/lib/database"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1"}`)
+	header := Sign(secret, now, payload)
+	valid := strings.TrimPrefix(header, "t=1700000000,")
+
+	cases := []struct {
+		name    string
+		header  string
+		payload []byte
+		now     time.Time
+		valid   bool
+	}{
+		{"signed", header, payload, now, true},
+		{"within tolerance", header, payload, now.Add(4 * time.Minute), true},
+		{"too old", header, payload, now.Add(6 * time.Minute), false},
+		{"from the future", header, payload, now.Add(-6 * time.Minute), false},
+		{"several signatures", "t=1700000000,v1=deadbeef," + valid, payload, now, true},
+		{"no matching signature", "t=1700000000,v1=deadbeef", payload, now, false},
+		{"tampered body", header, []byte(`{"id":"evt_2"}`), now, false},
+		{"no timestamp", valid, payload, now, false},
+		{"no signature", "t=1700000000", payload, now, false},
+		{"empty", "", payload, now, false},
+	}
+
+	for _, c := range cases {
+		err := Verify(secret, c.header, c.payload, 5*time.Minute, c.now)
+		if c.valid && err != nil {
+			t.Errorf("%s: Verify = %v, want nil", c.name, err)
+		}
+		if !c.valid && !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidSignature", c.name, err)
+		}
+	}
+
+	if err := Verify("whsec_other", header, payload, 5*time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify with another secret = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{settings: Settings{BaseDelay: 30 * time.Second, MaxDelay: time.Hour}}
+
+	for attempts := 1; attempts <= 80; attempts++ {
+		expected := time.Hour
+		if attempts <= 7 {
+			expected = 30 * time.Second << (attempts - 1)
+		}
+
+		for i := 0; i < 20; i++ {
+			if delay := d.backoff(attempts); delay < expected/2 || delay > expected {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempts, delay, expected/2, expected)
+			}
+		}
+	}
+}
+
+// clock is a settable time for the dispatcher.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestDispatcher(t *testing.T, settings Settings, url string) (*Dispatcher, *database.InMemory, *clock) {
+	t.Helper()
+
+	store := database.NewInMemory()
+	ctx := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant", Mode: database.ModeLive})
+	_, err := store.SaveSubscription(ctx, database.WebhookSubscription{Url: url, Events: []string{"payment.captured"}, Secret: "whsec_test"})
+	if err != nil {
+		t.Fatalf("SaveSubscription: %v", err)
+	}
+
+	_, err = store.SaveEvent(ctx, database.WebhookEvent{Type: "payment.captured", PaymentId: "payment-1", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("SaveEvent: %v", err)
+	}
+
+	dispatcher := NewDispatcher(store, settings)
+	now := &clock{now: time.Now()}
+	dispatcher.now = now.Now
+
+	return dispatcher, store, now
+}
+
+func deliveriesOf(t *testing.T, store *database.InMemory) []database.WebhookDelivery {
+	t.Helper()
+
+	found, err := store.ListDeliveries(database.Unscoped(context.Background()), "")
+	if err != nil || len(found) != 1 {
+		t.Fatalf("ListDeliveries = %+v, %v, want one delivery", found, err)
+	}
+
+	return found
+}
+
+func testSettings() Settings {
+	return Settings{
+		MaxAttempts:          3,
+		BaseDelay:            time.Minute,
+		MaxDelay:             time.Hour,
+		Timeout:              5 * time.Second,
+		BatchSize:            10,
+		AllowPrivateNetworks: true,
+	}
+}
+
+func TestDeadAfterMaxAttempts(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		// The dispatcher signs with its own clock, which runs hours ahead.
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("whsec_test", r.Header.Get(SignatureHeader), body, 24*time.Hour, time.Now()); err != nil {
+			t.Errorf("delivery signature: %v", err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dispatcher, store, now := newTestDispatcher(t, testSettings(), server.URL)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if _, err := dispatcher.DeliverDue(context.Background()); err != nil {
+			t.Fatalf("DeliverDue: %v", err)
+		}
+
+		delivery := deliveriesOf(t, store)[0]
+		if delivery.Attempts != attempt || delivery.LastStatusCode != http.StatusInternalServerError {
+			t.Fatalf("delivery = %+v, want attempt %d answered 500", delivery, attempt)
+		}
+
+		if attempt < 3 && (delivery.Status != database.DeliveryPending || !delivery.NextAttemptAt.After(now.Now())) {
+			t.Fatalf("delivery = %+v, want it pending for a later retry", delivery)
+		}
+
+		if sent, _ := dispatcher.DeliverDue(context.Background()); sent != 0 {
+			t.Fatalf("DeliverDue before the backoff sent %d, want 0", sent)
+		}
+
+		now.Add(time.Hour)
+	}
+
+	if delivery := deliveriesOf(t, store)[0]; delivery.Status != database.DeliveryDead {
+		t.Errorf("delivery = %+v, want dead after 3 attempts", delivery)
+	}
+
+	if sent, _ := dispatcher.DeliverDue(context.Background()); sent != 0 || atomic.LoadInt64(&calls) != 3 {
+		t.Errorf("dead delivery sent again: %d sent, %d calls", sent, atomic.LoadInt64(&calls))
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+	}))
+	defer server.Close()
+
+	dispatcher, store, now := newTestDispatcher(t, testSettings(), server.URL)
+
+	// Another dispatcher claims the delivery and never reports back.
+	claimed, err := store.ClaimDeliveries(context.Background(), now.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimDeliveries = %+v, %v, want one delivery", claimed, err)
+	}
+
+	now.Add(30 * time.Second)
+	if sent, err := dispatcher.DeliverDue(context.Background()); err != nil || sent != 0 {
+		t.Fatalf("DeliverDue during the lease = %d, %v, want nothing sent", sent, err)
+	}
+
+	now.Add(time.Minute)
+	if sent, err := dispatcher.DeliverDue(context.Background()); err != nil || sent != 1 {
+		t.Fatalf("DeliverDue after the lease = %d, %v, want the delivery sent", sent, err)
+	}
+
+	if delivery := deliveriesOf(t, store)[0]; delivery.Status != database.DeliverySucceeded || atomic.LoadInt64(&calls) != 1 {
+		t.Errorf("delivery = %+v after %d calls, want it succeeded once", delivery, atomic.LoadInt64(&calls))
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	refused := []string{"127.0.0.1", "::1", "10.0.0.1", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"fe80::1", "fc00::1", "100.64.0.1", "0.0.0.0", "::", "224.0.0.1", "198.18.0.1"}
+	for _, address := range refused {
+		if IsPublic(net.ParseIP(address)) {
+			t.Errorf("IsPublic(%s) = true, want false", address)
+		}
+	}
+
+	for _, address := range []string{"93.184.216.34", "8.8.8.8", "2606:4700::1111"} {
+		if !IsPublic(net.ParseIP(address)) {
+			t.Errorf("IsPublic(%s) = false, want true", address)
+		}
+	}
+}
+
+func TestPrivateTargetsAreRefused(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+	}))
+	defer server.Close()
+
+	settings := testSettings()
+	settings.AllowPrivateNetworks = false
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	// localhost is a name, so it is only caught once it resolves.
+	for _, url := range []string{server.URL, "http://localhost:" + port} {
+		dispatcher, store, _ := newTestDispatcher(t, settings, url)
+		if _, err := dispatcher.DeliverDue(context.Background()); err != nil {
+			t.Fatalf("DeliverDue: %v", err)
+		}
+
+		delivery := deliveriesOf(t, store)[0]
+		if delivery.Status != database.DeliveryPending || !strings.Contains(delivery.LastError, ErrPrivateTarget.Error()) {
+			t.Errorf("%s: delivery = %+v, want it refused as a private target", url, delivery)
+		}
+	}
+
+	if received := atomic.LoadInt64(&calls); received != 0 {
+		t.Errorf("private server received %d deliveries, want 0", received)
+	}
+}
+
+func TestRedirectsAreNotFollowed(t *testing.T) {
+	var followed int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&followed, 1)
+	}))
+	defer target.Close()
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	dispatcher, store, _ := newTestDispatcher(t, testSettings(), redirect.URL)
+	if _, err := dispatcher.DeliverDue(context.Background()); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	delivery := deliveriesOf(t, store)[0]
+	if delivery.LastStatusCode != http.StatusTemporaryRedirect || delivery.Status != database.DeliveryPending || atomic.LoadInt64(&followed) != 0 {
+		t.Errorf("delivery = %+v with %d followed, want the redirect answered as a failure", delivery, atomic.LoadInt64(&followed))
+	}
+}
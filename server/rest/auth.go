@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/app/services"
+	"payment-processor.gary94746/main/lib/database"
+)
+
+type verifyKey func(ctx context.Context, value string) (*database.ApiKey, error)
+
+// authenticate accepts requests carrying an active API key of one of kinds
+// and scopes them to the key's merchant and mode.
+func authenticate(verify verifyKey, kinds ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key, err := verify(ctx.Request.Context(), bearerToken(ctx))
+		if errors.Is(err, services.ErrInvalidApiKey) {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			abortWithError(ctx, http.StatusUnauthorized, errorAuthentication, "a valid API key is required in the Authorization header")
+			return
+		}
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, errorInternal, "error verifying the API key")
+			return
+		}
+
+		if !acceptsKind(kinds, key.Kind) {
+			abortWithError(ctx, http.StatusForbidden, errorPermission, "this request requires a secret API key")
+			return
+		}
+
+		scope := database.Scope{MerchantId: key.MerchantId, Mode: key.Mode}
+		ctx.Request = ctx.Request.WithContext(database.WithScope(ctx.Request.Context(), scope))
+		ctx.Next()
+	}
+}
+
+// admin protects the merchant management routes with ADMIN_API_KEY and lets
+// them act on every merchant.
+func admin(adminKey string) gin.HandlerFunc {
+	expected := sha256.Sum256([]byte(adminKey))
+	return func(ctx *gin.Context) {
+		given := sha256.Sum256([]byte(bearerToken(ctx)))
+		if subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			abortWithError(ctx, http.StatusUnauthorized, errorAuthentication, "a valid admin key is required in the Authorization header")
+			return
+		}
+
+		ctx.Request = ctx.Request.WithContext(database.Unscoped(ctx.Request.Context()))
+		ctx.Next()
+	}
+}
+
+func bearerToken(ctx *gin.Context) string {
+	scheme, token, found := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func acceptsKind(kinds []string, kind string) bool {
+	for _, accepted := range kinds {
+		if accepted == kind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"payment-processor.gary94746/main/lib/database"
+)
+
+func TestAuthenticateRequiresAnActiveKey(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	secret := merchant.keys[database.ModeLive][database.KeySecret]
+
+	cases := []struct {
+		name   string
+		header string
+	}{
+		{"no header", ""},
+		{"unknown key", "Bearer sk_live_unknown"},
+		{"another scheme", "Basic " + secret.Key},
+		{"no scheme", secret.Key},
+		{"admin key", "Bearer " + testAdminKey},
+	}
+
+	for _, c := range cases {
+		request, _ := http.NewRequest(http.MethodGet, "/api/v1/processor/payment/", nil)
+		if c.header != "" {
+			request.Header.Set("Authorization", c.header)
+		}
+
+		response := serve(api, request)
+		if response.Code != http.StatusUnauthorized || response.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: %d %s, want 401 with a Bearer challenge", c.name, response.Code, response.Body)
+		}
+	}
+
+	if response := api.request(http.MethodGet, "/api/v1/processor/payment/", secret.Key, ""); response.Code != http.StatusOK {
+		t.Fatalf("active key = %d %s, want 200", response.Code, response.Body)
+	}
+
+	revoked := api.request(http.MethodDelete, "/api/admin/merchants/"+merchant.id+"/keys/"+secret.Id, testAdminKey, "")
+	if revoked.Code >= http.StatusBadRequest {
+		t.Fatalf("revoking = %d %s", revoked.Code, revoked.Body)
+	}
+
+	response := api.request(http.MethodGet, "/api/v1/processor/payment/", secret.Key, "")
+	var failure ErrorResponse
+	decode(t, response, &failure)
+	if response.Code != http.StatusUnauthorized || failure.Error.Type != errorAuthentication {
+		t.Errorf("revoked key = %d %s, want 401 authentication", response.Code, response.Body)
+	}
+}
+
+func TestPublishableKeysAreRefused(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	id := api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, ReferenceId: "order-1"})
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/api/v1/processor/payment/", ""},
+		{http.MethodPost, "/api/v1/processor/payment/", `{}`},
+		{http.MethodGet, "/api/v1/processor/payment/" + id, ""},
+		{http.MethodGet, "/api/v1/processor/payment/reference/order-1", ""},
+		{http.MethodPost, "/api/v1/processor/payment/" + id + "/capture", ""},
+		{http.MethodPost, "/api/v1/processor/payment/" + id + "/refund", ""},
+		{http.MethodPost, "/api/v1/processor/payment/" + id + "/void", ""},
+		{http.MethodGet, "/api/v1/webhooks/subscriptions", ""},
+		{http.MethodPost, "/api/v1/webhooks/subscriptions", `{}`},
+	}
+
+	for _, mode := range []string{database.ModeLive, database.ModeTest} {
+		key := merchant.keys[mode][database.KeyPublishable].Key
+		for _, route := range routes {
+			response := api.request(route.method, route.path, key, route.body)
+			var failure ErrorResponse
+			decode(t, response, &failure)
+			if response.Code != http.StatusForbidden || failure.Error.Type != errorPermission {
+				t.Errorf("%s %s with a %s publishable key = %d %s, want 403 permission", route.method, route.path, mode, response.Code, response.Body)
+			}
+		}
+	}
+}
+
+func TestMerchantsOnlySeeTheirPayments(t *testing.T) {
+	api := newTestApi(t)
+	acme := api.merchant(t, "Acme")
+	globex := api.merchant(t, "Globex")
+
+	id := api.seed(t, acme, database.Payment{Currency: "USD", Amount: 1000, ReferenceId: "order-1"})
+	api.seed(t, globex, database.Payment{Currency: "USD", Amount: 2000})
+
+	others := map[string]string{
+		"another merchant":             globex.keys[database.ModeLive][database.KeySecret].Key,
+		"the same merchant's test key": acme.keys[database.ModeTest][database.KeySecret].Key,
+	}
+
+	for name, key := range others {
+		for _, path := range []string{"/api/v1/processor/payment/" + id, "/api/v1/processor/payment/reference/order-1"} {
+			response := api.request(http.MethodGet, path, key, "")
+			if response.Code != http.StatusNotFound {
+				t.Errorf("%s: GET %s = %d %s, want 404", name, path, response.Code, response.Body)
+			}
+		}
+
+		for _, action := range []string{"capture", "refund", "void"} {
+			response := api.request(http.MethodPost, "/api/v1/processor/payment/"+id+"/"+action, key, "")
+			if response.Code != http.StatusNotFound {
+				t.Errorf("%s: %s = %d %s, want 404", name, action, response.Code, response.Body)
+			}
+		}
+
+		response := api.request(http.MethodGet, "/api/v1/processor/payment/", key, "")
+		var page database.PaymentPage
+		decode(t, response, &page)
+		for _, payment := range page.Payments {
+			if payment.Id == id {
+				t.Errorf("%s: list includes the payment of Acme", name)
+			}
+		}
+	}
+
+	response := api.request(http.MethodGet, "/api/v1/processor/payment/reference/order-1", acme.keys[database.ModeLive][database.KeySecret].Key, "")
+	if response.Code != http.StatusOK {
+		t.Errorf("owner: GET by reference = %d %s, want 200", response.Code, response.Body)
+	}
+}
+
+func TestAdminRequiresTheAdminKey(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	path := "/api/admin/merchants/" + merchant.id + "/keys"
+
+	for name, key := range map[string]string{
+		"no key":              "",
+		"wrong key":           testAdminKey + "x",
+		"merchant secret key": merchant.keys[database.ModeLive][database.KeySecret].Key,
+	} {
+		response := api.request(http.MethodGet, path, key, "")
+		if response.Code != http.StatusUnauthorized || response.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: %d %s, want 401 with a Bearer challenge", name, response.Code, response.Body)
+		}
+	}
+
+	if response := api.request(http.MethodGet, path, testAdminKey, ""); response.Code != http.StatusOK {
+		t.Errorf("admin key = %d %s, want 200", response.Code, response.Body)
+	}
+}
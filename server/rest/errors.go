@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/app/services"
+	"payment-processor.gary94746/main/lib/processors"
+)
+
+const (
+	errorInvalidRequest = "invalid_request"
+	errorNotFound       = "not_found"
+	errorConflict       = "conflict"
+	errorAuthentication = "authentication_error"
+	errorPermission     = "permission_denied"
+	errorInternal       = "internal_error"
+)
+
+var processorStatus = map[processors.ErrorKind]int{
+	processors.ErrorDeclined:       http.StatusPaymentRequired,
+	processors.ErrorInvalidRequest: http.StatusUnprocessableEntity,
+	processors.ErrorAuthentication: http.StatusBadGateway,
+	processors.ErrorRateLimited:    http.StatusTooManyRequests,
+	processors.ErrorUnavailable:    http.StatusServiceUnavailable,
+	processors.ErrorNotFound:       http.StatusNotFound,
+	processors.ErrorConflict:       http.StatusConflict,
+}
+
+func abortWithError(ctx *gin.Context, status int, errorType string, message string) {
+	ctx.AbortWithStatusJSON(status, ErrorResponse{
+		Error: ErrorDetail{Type: errorType, Message: message},
+	})
+}
+
+func badRequest(ctx *gin.Context, err error) {
+	abortWithError(ctx, http.StatusBadRequest, errorInvalidRequest, err.Error())
+}
+
+func respondError(ctx *gin.Context, err error) {
+	status, detail := errorResponse(err)
+	ctx.JSON(status, ErrorResponse{Error: detail})
+}
+
+func errorResponse(err error) (int, ErrorDetail) {
+	detail := ErrorDetail{Type: errorInternal, Message: err.Error()}
+
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		detail.Type = errorInvalidRequest
+		detail.Fields = validationErr.Fields
+		return http.StatusUnprocessableEntity, detail
+	}
+
+	var processorErr *processors.ProcessorError
+	if errors.As(err, &processorErr) {
+		detail.Type = string(processorErr.Kind)
+		detail.Code = processorErr.Code
+		detail.Processor = processorErr.Processor
+		if processorErr.Message != "" {
+			detail.Message = processorErr.Message
+		}
+
+		status, isOk := processorStatus[processorErr.Kind]
+		if !isOk {
+			status = http.StatusBadGateway
+		}
+
+		return status, detail
+	}
+
+	var transitionErr *services.TransitionError
+	if errors.As(err, &transitionErr) || errors.Is(err, services.ErrDuplicateReference) {
+		detail.Type = errorConflict
+		return http.StatusConflict, detail
+	}
+
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound), errors.Is(err, services.ErrSubscriptionNotFound),
+		errors.Is(err, services.ErrEventNotFound), errors.Is(err, services.ErrMerchantNotFound),
+		errors.Is(err, services.ErrApiKeyNotFound), errors.Is(err, services.ErrUnknownProcessor):
+		detail.Type = errorNotFound
+		return http.StatusNotFound, detail
+	case errors.Is(err, services.ErrPaymentNotRefundable), errors.Is(err, services.ErrRefundExceedsBalance),
+		errors.Is(err, services.ErrCaptureExceedsAmount), errors.Is(err, services.ErrPartialCaptureManual):
+		detail.Type = errorInvalidRequest
+		return http.StatusUnprocessableEntity, detail
+	case errors.Is(err, services.ErrTestModeUnavailable):
+		detail.Type = errorPermission
+		return http.StatusForbidden, detail
+	case errors.Is(err, processors.ErrInvalidSignature), errors.Is(err, processors.ErrWebhookNotConfigured),
+		errors.Is(err, services.ErrInvalidCursor), errors.Is(err, services.ErrWebhooksNotSupported):
+		detail.Type = errorInvalidRequest
+		return http.StatusBadRequest, detail
+	}
+
+	return http.StatusInternalServerError, detail
+}
@@ -0,0 +1,164 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/app/services"
+	"payment-processor.gary94746/main/lib/database"
+	"payment-processor.gary94746/main/lib/processors"
+	"payment-processor.gary94746/main/lib/processors/processortest"
+)
+
+func TestRespondError(t *testing.T) {
+	declined := &processors.ProcessorError{
+		Kind:      processors.ErrorDeclined,
+		Processor: "stripe",
+		Code:      "insufficient_funds",
+		Message:   "Your card has insufficient funds.",
+	}
+
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		detail ErrorDetail
+	}{
+		{"declined", declined, http.StatusPaymentRequired,
+			ErrorDetail{Type: "declined", Code: "insufficient_funds", Message: "Your card has insufficient funds.", Processor: "stripe"}},
+		{"wrapped processor error", fmt.Errorf("capturing: %w", declined), http.StatusPaymentRequired,
+			ErrorDetail{Type: "declined", Code: "insufficient_funds", Message: "Your card has insufficient funds.", Processor: "stripe"}},
+		{"processor invalid request", &processors.ProcessorError{Kind: processors.ErrorInvalidRequest, Processor: "paypal", Code: "INVALID_PARAMETER_VALUE", Message: "bad"},
+			http.StatusUnprocessableEntity, ErrorDetail{Type: "invalid_request", Code: "INVALID_PARAMETER_VALUE", Message: "bad", Processor: "paypal"}},
+		{"processor authentication", &processors.ProcessorError{Kind: processors.ErrorAuthentication, Processor: "stripe", Message: "bad key"},
+			http.StatusBadGateway, ErrorDetail{Type: "authentication", Message: "bad key", Processor: "stripe"}},
+		{"processor rate limited", &processors.ProcessorError{Kind: processors.ErrorRateLimited, Processor: "stripe", Message: "slow down"},
+			http.StatusTooManyRequests, ErrorDetail{Type: "rate_limited", Message: "slow down", Processor: "stripe"}},
+		{"processor unavailable", &processors.ProcessorError{Kind: processors.ErrorUnavailable, Processor: "paypal", Code: "circuit_open", Message: "down"},
+			http.StatusServiceUnavailable, ErrorDetail{Type: "processor_unavailable", Code: "circuit_open", Message: "down", Processor: "paypal"}},
+		{"processor not found", &processors.ProcessorError{Kind: processors.ErrorNotFound, Processor: "stripe", Message: "missing"},
+			http.StatusNotFound, ErrorDetail{Type: "not_found", Message: "missing", Processor: "stripe"}},
+		{"processor conflict", &processors.ProcessorError{Kind: processors.ErrorConflict, Processor: "stripe", Message: "busy"},
+			http.StatusConflict, ErrorDetail{Type: "conflict", Message: "busy", Processor: "stripe"}},
+		{"processor error without a message", &processors.ProcessorError{Kind: processors.ErrorUnavailable, Processor: "stripe", Err: errors.New("timeout")},
+			http.StatusServiceUnavailable, ErrorDetail{Type: "processor_unavailable", Message: "stripe processor_unavailable: timeout", Processor: "stripe"}},
+		{"unknown processor kind", &processors.ProcessorError{Kind: "odd", Message: "odd"},
+			http.StatusBadGateway, ErrorDetail{Type: "odd", Message: "odd"}},
+		{"partial refund", &processors.PartialRefundError{Refund: processors.RefundResponse{Amount: 1000, Currency: "USD"}, Err: declined},
+			http.StatusPaymentRequired, ErrorDetail{Type: "declined", Code: "insufficient_funds", Message: "Your card has insufficient funds.", Processor: "stripe"}},
+		{"transition", &services.TransitionError{PaymentId: "payment-1", From: "voided", To: "captured"},
+			http.StatusConflict, ErrorDetail{Type: errorConflict, Message: "payment payment-1 cannot move from voided to captured"}},
+		{"duplicate reference", services.ErrDuplicateReference, http.StatusConflict,
+			ErrorDetail{Type: errorConflict, Message: services.ErrDuplicateReference.Error()}},
+		{"payment not found", services.ErrPaymentNotFound, http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: services.ErrPaymentNotFound.Error()}},
+		{"subscription not found", services.ErrSubscriptionNotFound, http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: services.ErrSubscriptionNotFound.Error()}},
+		{"event not found", services.ErrEventNotFound, http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: services.ErrEventNotFound.Error()}},
+		{"merchant not found", services.ErrMerchantNotFound, http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: services.ErrMerchantNotFound.Error()}},
+		{"api key not found", services.ErrApiKeyNotFound, http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: services.ErrApiKeyNotFound.Error()}},
+		{"unknown processor", fmt.Errorf("%w: %q", services.ErrUnknownProcessor, "adyen"), http.StatusNotFound,
+			ErrorDetail{Type: errorNotFound, Message: `processor not exists: "adyen"`}},
+		{"not refundable", services.ErrPaymentNotRefundable, http.StatusUnprocessableEntity,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrPaymentNotRefundable.Error()}},
+		{"refund exceeds balance", services.ErrRefundExceedsBalance, http.StatusUnprocessableEntity,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrRefundExceedsBalance.Error()}},
+		{"capture exceeds amount", services.ErrCaptureExceedsAmount, http.StatusUnprocessableEntity,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrCaptureExceedsAmount.Error()}},
+		{"partial capture", services.ErrPartialCaptureManual, http.StatusUnprocessableEntity,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrPartialCaptureManual.Error()}},
+		{"test mode", services.ErrTestModeUnavailable, http.StatusForbidden,
+			ErrorDetail{Type: errorPermission, Message: services.ErrTestModeUnavailable.Error()}},
+		{"invalid signature", processors.ErrInvalidSignature, http.StatusBadRequest,
+			ErrorDetail{Type: errorInvalidRequest, Message: processors.ErrInvalidSignature.Error()}},
+		{"webhook not configured", processors.ErrWebhookNotConfigured, http.StatusBadRequest,
+			ErrorDetail{Type: errorInvalidRequest, Message: processors.ErrWebhookNotConfigured.Error()}},
+		{"webhooks not supported", services.ErrWebhooksNotSupported, http.StatusBadRequest,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrWebhooksNotSupported.Error()}},
+		{"invalid cursor", services.ErrInvalidCursor, http.StatusBadRequest,
+			ErrorDetail{Type: errorInvalidRequest, Message: services.ErrInvalidCursor.Error()}},
+		{"unexpected", errors.New("disk full"), http.StatusInternalServerError,
+			ErrorDetail{Type: errorInternal, Message: "disk full"}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		respondError(ctx, c.err)
+
+		var response ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: decoding %s: %v", c.name, recorder.Body, err)
+		}
+
+		if recorder.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", c.name, recorder.Code, c.status)
+		}
+
+		if fmt.Sprint(response.Error) != fmt.Sprint(c.detail) {
+			t.Errorf("%s: error = %+v, want %+v", c.name, response.Error, c.detail)
+		}
+	}
+}
+
+func TestRespondValidationError(t *testing.T) {
+	s := &services.Services{Database: database.NewInMemory()}
+	ctx := database.WithScope(context.Background(), database.Scope{MerchantId: "merchant", Mode: database.ModeLive})
+
+	unbalanced := processortest.NewPayment()
+	unbalanced.Amount++
+
+	empty := processortest.NewPayment()
+	empty.LineItems = nil
+	empty.Metadata = map[string]string{strings.Repeat("k", services.MaxMetadataKeyLength+1): "value"}
+
+	cases := []struct {
+		name    string
+		payment processors.Payment
+		fields  []string
+	}{
+		{"amount", unbalanced, []string{"amount"}},
+		{"line items and metadata", empty, []string{"lineItems", "metadata"}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, c := range cases {
+		_, err := s.CreatePayment(ctx, c.payment)
+
+		recorder := httptest.NewRecorder()
+		gctx, _ := gin.CreateTestContext(recorder)
+		respondError(gctx, err)
+
+		var response ErrorResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: decoding %s: %v", c.name, recorder.Body, err)
+		}
+
+		if recorder.Code != http.StatusUnprocessableEntity || response.Error.Type != errorInvalidRequest {
+			t.Errorf("%s: %d %s, want 422 invalid_request", c.name, recorder.Code, response.Error.Type)
+		}
+
+		fields := []string{}
+		for _, field := range response.Error.Fields {
+			if field.Message == "" {
+				t.Errorf("%s: field %s has no message", c.name, field.Field)
+			}
+			fields = append(fields, strings.SplitN(field.Field, "[", 2)[0])
+		}
+
+		if strings.Join(fields, ",") != strings.Join(c.fields, ",") {
+			t.Errorf("%s: fields = %+v, want %v", c.name, response.Error.Fields, c.fields)
+		}
+	}
+}
@@ -1,30 +1,101 @@
 package rest
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"payment-processor.gary94746/main/lib/database"
 	"payment-processor.gary94746/main/lib/processors"
 )
 
 func (api ApiRest) getPayment(ctx *gin.Context) {
 	paymentId := ctx.Param("id")
-	payment, err := api.services.GetPayment(paymentId)
+	payment, err := api.services.GetPayment(ctx.Request.Context(), paymentId)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(ctx, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, payment)
 }
 
+func (api ApiRest) getPaymentByReference(ctx *gin.Context) {
+	payment, err := api.services.GetPaymentByReference(ctx.Request.Context(), ctx.Param("reference"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, payment)
+}
+
+func (api ApiRest) listPayments(ctx *gin.Context) {
+	var query PaymentQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	page, err := api.services.ListPayments(ctx.Request.Context(), database.PaymentFilter{
+		Status:        query.Status,
+		Processor:     query.Processor,
+		Currency:      query.Currency,
+		MinAmount:     query.MinAmount,
+		MaxAmount:     query.MaxAmount,
+		CreatedAfter:  query.CreatedAfter,
+		CreatedBefore: query.CreatedBefore,
+		Metadata:      ctx.QueryMap("metadata"),
+		Order:         database.SortOrder(query.Order),
+		Cursor:        query.Cursor,
+		Limit:         query.Limit,
+	})
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
 func (api ApiRest) capturePayment(ctx *gin.Context) {
+	var body Capture
+	if err := bindOptionalJSON(ctx, &body); err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	final := true
+	if body.Final != nil {
+		final = *body.Final
+	}
+
 	paymentId := ctx.Param("id")
+	capture, err := api.services.CapturePayment(ctx.Request.Context(), paymentId, processors.CaptureRequest{
+		Amount:         body.Amount,
+		Final:          final,
+		IdempotencyKey: processorKey(ctx),
+	})
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
 
-	errors := api.services.CapturePayment(paymentId)
-	if errors != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": errors.Error(),
-		})
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": capture,
+	})
+}
+
+func (api ApiRest) voidPayment(ctx *gin.Context) {
+	paymentId := ctx.Param("id")
+
+	err := api.services.VoidPayment(ctx.Request.Context(), paymentId, processors.VoidRequest{
+		IdempotencyKey: processorKey(ctx),
+	})
+	if err != nil {
+		respondError(ctx, err)
 		return
 	}
 
@@ -34,30 +105,69 @@ func (api ApiRest) capturePayment(ctx *gin.Context) {
 func (api ApiRest) createPayment(ctx *gin.Context) {
 	var body Payment
 	if err := ctx.ShouldBindJSON(&body); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badRequest(ctx, err)
 		return
 	}
 
 	var items []processors.LineItem
 	for _, item := range body.LineItems {
 		items = append(items, processors.LineItem{
-			Name:     item.Name,
-			Amount:   item.Amount,
-			Quantity: item.Quantity,
+			Name:        item.Name,
+			Amount:      item.Amount,
+			Quantity:    item.Quantity,
+			Sku:         item.Sku,
+			Description: item.Description,
+			Tax:         item.Tax,
 		})
 	}
 
+	var shipping *processors.Shipping
+	if body.Shipping != nil {
+		address := body.Shipping.Address
+		shipping = &processors.Shipping{
+			Name:   body.Shipping.Name,
+			Amount: body.Shipping.Amount,
+			Address: processors.Address{
+				Line1:      address.Line1,
+				Line2:      address.Line2,
+				City:       address.City,
+				State:      address.State,
+				PostalCode: address.PostalCode,
+				Country:    address.Country,
+			},
+		}
+	}
+
+	var customer *processors.Customer
+	if body.Customer != nil {
+		customer = &processors.Customer{
+			Name:  body.Customer.Name,
+			Email: body.Customer.Email,
+			Phone: body.Customer.Phone,
+		}
+	}
+
 	paymentPayload := processors.Payment{
-		Currency:    body.Currency,
-		Amount:      body.Amount,
-		RedirectUrl: body.RedirectUrl,
-		CancelUrl:   body.CancelUrl,
-		LineItems:   items,
+		Currency:       body.Currency,
+		Amount:         body.Amount,
+		RedirectUrl:    body.RedirectUrl,
+		CancelUrl:      body.CancelUrl,
+		LineItems:      items,
+		Shipping:       shipping,
+		Discount:       body.Discount,
+		Processor:      body.Processor,
+		CaptureMethod:  body.CaptureMethod,
+		ReferenceId:    body.ReferenceId,
+		Description:    body.Description,
+		Customer:       customer,
+		Metadata:       body.Metadata,
+		IdempotencyKey: processorKey(ctx),
 	}
 
-	payment, err := api.services.CreatePayment(paymentPayload)
+	payment, err := api.services.CreatePayment(ctx.Request.Context(), paymentPayload)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{})
+		respondError(ctx, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
@@ -67,21 +177,21 @@ func (api ApiRest) createPayment(ctx *gin.Context) {
 
 func (api ApiRest) refundPayment(ctx *gin.Context) {
 	var body PartialRefund
-	if err := ctx.ShouldBindJSON(&body); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindOptionalJSON(ctx, &body); err != nil {
+		badRequest(ctx, err)
 		return
 	}
 
 	refundPayload := processors.PartialRefund{
-		Amount: body.Amount,
+		Amount:         body.Amount,
+		Reason:         body.Reason,
+		IdempotencyKey: processorKey(ctx),
 	}
 	paymentId, _ := ctx.Params.Get("id")
-	refund, err := api.services.RefundPayment(paymentId, refundPayload)
+	refund, err := api.services.RefundPayment(ctx.Request.Context(), paymentId, refundPayload)
 
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": err.Error(),
-		})
+		respondError(ctx, err)
 		return
 	}
 
@@ -89,3 +199,158 @@ func (api ApiRest) refundPayment(ctx *gin.Context) {
 		"data": refund,
 	})
 }
+
+func (api ApiRest) receiveWebhook(ctx *gin.Context) {
+	api.handleWebhook(ctx, database.ModeLive)
+}
+
+func (api ApiRest) receiveTestWebhook(ctx *gin.Context) {
+	api.handleWebhook(ctx, database.ModeTest)
+}
+
+func (api ApiRest) handleWebhook(ctx *gin.Context, mode string) {
+	payload, err := ctx.GetRawData()
+	if err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	err = api.services.HandleWebhook(ctx.Request.Context(), mode, ctx.Param("processor"), payload, ctx.Request.Header)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (api ApiRest) createSubscription(ctx *gin.Context) {
+	var body Subscription
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	subscription, err := api.services.CreateSubscription(ctx.Request.Context(), database.WebhookSubscription{
+		Url:    body.Url,
+		Events: body.Events,
+		Secret: body.Secret,
+	})
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"data":   subscription,
+		"secret": subscription.Secret,
+	})
+}
+
+func (api ApiRest) listSubscriptions(ctx *gin.Context) {
+	subscriptions, err := api.services.ListSubscriptions(ctx.Request.Context())
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": subscriptions})
+}
+
+func (api ApiRest) deleteSubscription(ctx *gin.Context) {
+	if err := api.services.DeleteSubscription(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{})
+}
+
+func (api ApiRest) listDeliveries(ctx *gin.Context) {
+	deliveries, err := api.services.ListDeliveries(ctx.Request.Context(), ctx.Query("status"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": deliveries})
+}
+
+func (api ApiRest) replayEvent(ctx *gin.Context) {
+	if err := api.services.ReplayEvent(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{})
+}
+
+func (api ApiRest) createMerchant(ctx *gin.Context) {
+	var body Merchant
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	merchant, keys, err := api.services.CreateMerchant(ctx.Request.Context(), body.Name)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"data": merchant,
+		"keys": keys,
+	})
+}
+
+func (api ApiRest) listApiKeys(ctx *gin.Context) {
+	keys, err := api.services.ListApiKeys(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+func (api ApiRest) issueApiKey(ctx *gin.Context) {
+	var body ApiKey
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		badRequest(ctx, err)
+		return
+	}
+
+	key, err := api.services.IssueApiKey(ctx.Request.Context(), ctx.Param("id"), body.Kind, body.Mode)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"data": key})
+}
+
+func (api ApiRest) revokeApiKey(ctx *gin.Context) {
+	if err := api.services.RevokeApiKey(ctx.Request.Context(), ctx.Param("id"), ctx.Param("keyId")); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{})
+}
+
+// bindOptionalJSON binds the request body into obj when there is one. The
+// body is read rather than trusting ContentLength, which is -1 for chunked and
+// HTTP/2 requests.
+func bindOptionalJSON(ctx *gin.Context, obj interface{}) error {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	return binding.JSON.BindBody(body, obj)
+}
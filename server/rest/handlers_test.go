@@ -0,0 +1,268 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/app/services"
+	"payment-processor.gary94746/main/lib/database"
+)
+
+const testAdminKey = "admin-key"
+
+// testApi serves every route of the API on an in-memory store.
+type testApi struct {
+	router   *gin.Engine
+	store    *database.InMemory
+	services *services.Services
+}
+
+// testMerchant is a merchant of testApi with the values of its keys by
+// mode and kind, e.g. keys["live"]["secret"].
+type testMerchant struct {
+	id   string
+	keys map[string]map[string]services.IssuedApiKey
+}
+
+func newTestApi(t *testing.T) *testApi {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := database.NewInMemory()
+	api := ApiRest{
+		database: store,
+		services: &services.Services{Database: store, Webhooks: store, Merchants: store},
+	}
+
+	r := gin.New()
+	api.routes(r, testAdminKey)
+
+	return &testApi{router: r, store: store, services: api.services}
+}
+
+func (a *testApi) merchant(t *testing.T, name string) testMerchant {
+	t.Helper()
+
+	merchant, issued, err := a.services.CreateMerchant(database.Unscoped(context.Background()), name)
+	if err != nil {
+		t.Fatalf("CreateMerchant: %v", err)
+	}
+
+	keys := map[string]map[string]services.IssuedApiKey{}
+	for _, key := range issued {
+		if keys[key.Mode] == nil {
+			keys[key.Mode] = map[string]services.IssuedApiKey{}
+		}
+		keys[key.Mode][key.Kind] = key
+	}
+
+	return testMerchant{id: merchant.Id, keys: keys}
+}
+
+// seed stores payment as a live payment of merchant.
+func (a *testApi) seed(t *testing.T, merchant testMerchant, payment database.Payment) string {
+	t.Helper()
+
+	ctx := database.WithScope(context.Background(), database.Scope{MerchantId: merchant.id, Mode: database.ModeLive})
+	id, err := a.store.Save(ctx, payment)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	return id
+}
+
+func (a *testApi) request(method string, path string, key string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	return serve(a, request)
+}
+
+func serve(a *testApi, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	a.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decode(t *testing.T, recorder *httptest.ResponseRecorder, target interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), target); err != nil {
+		t.Fatalf("decoding %s: %v", recorder.Body, err)
+	}
+}
+
+func TestListPaymentsQuery(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	key := merchant.keys[database.ModeLive][database.KeySecret].Key
+
+	for i := 0; i < 3; i++ {
+		api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, Status: "created"})
+	}
+
+	cases := []struct {
+		name   string
+		query  string
+		status int
+		count  int
+	}{
+		{"no filters", "", http.StatusOK, 3},
+		{"limit", "limit=2", http.StatusOK, 2},
+		{"largest limit", "limit=100", http.StatusOK, 3},
+		{"ascending", "order=asc", http.StatusOK, 3},
+		{"zero limit", "limit=0", http.StatusOK, 3},
+		{"limit over the maximum", "limit=101", http.StatusBadRequest, 0},
+		{"negative limit", "limit=-1", http.StatusBadRequest, 0},
+		{"limit is not a number", "limit=ten", http.StatusBadRequest, 0},
+		{"unknown order", "order=newest", http.StatusBadRequest, 0},
+		{"cursor is not base64", "cursor=" + url.QueryEscape("not a cursor!"), http.StatusBadRequest, 0},
+		{"cursor without an id", "cursor=MTcwMDAwMDAwMA", http.StatusBadRequest, 0},
+		{"cursor too long", "cursor=" + strings.Repeat("a", 201), http.StatusBadRequest, 0},
+	}
+
+	for _, c := range cases {
+		response := api.request(http.MethodGet, "/api/v1/processor/payment/?"+c.query, key, "")
+		if response.Code != c.status {
+			t.Errorf("%s: status = %d %s, want %d", c.name, response.Code, response.Body, c.status)
+			continue
+		}
+
+		if c.status != http.StatusOK {
+			var failure ErrorResponse
+			decode(t, response, &failure)
+			if failure.Error.Type != errorInvalidRequest {
+				t.Errorf("%s: error = %+v, want invalid_request", c.name, failure.Error)
+			}
+			continue
+		}
+
+		var page database.PaymentPage
+		decode(t, response, &page)
+		if len(page.Payments) != c.count {
+			t.Errorf("%s: %d payments, want %d", c.name, len(page.Payments), c.count)
+		}
+	}
+}
+
+func TestListPaymentsPages(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	key := merchant.keys[database.ModeLive][database.KeySecret].Key
+
+	for i := 0; i < 3; i++ {
+		api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, Status: "created"})
+	}
+
+	for _, order := range []string{"asc", "desc"} {
+		seen := map[string]bool{}
+		cursor := ""
+		for pages := 1; ; pages++ {
+			response := api.request(http.MethodGet, "/api/v1/processor/payment/?limit=2&order="+order+"&cursor="+cursor, key, "")
+			if response.Code != http.StatusOK {
+				t.Fatalf("%s page %d = %d %s, want 200", order, pages, response.Code, response.Body)
+			}
+
+			var page database.PaymentPage
+			decode(t, response, &page)
+			for _, payment := range page.Payments {
+				if seen[payment.Id] {
+					t.Errorf("%s page %d repeats payment %s", order, pages, payment.Id)
+				}
+				seen[payment.Id] = true
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			if pages == 3 {
+				t.Fatalf("%s: still paging after %d pages", order, pages)
+			}
+			cursor = url.QueryEscape(page.NextCursor)
+		}
+
+		if len(seen) != 3 {
+			t.Errorf("%s: paged through %d payments, want 3", order, len(seen))
+		}
+	}
+}
+
+func TestListPaymentsByMetadata(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	key := merchant.keys[database.ModeLive][database.KeySecret].Key
+
+	first := api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, Metadata: map[string]string{"order": "A-1", "channel": "web"}})
+	api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, Metadata: map[string]string{"order": "A-2", "channel": "web"}})
+	api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000})
+
+	cases := []struct {
+		query string
+		count int
+	}{
+		{"metadata[order]=A-1", 1},
+		{"metadata[channel]=web", 2},
+		{"metadata[order]=A-1&metadata[channel]=web", 1},
+		{"metadata[order]=A-1&metadata[channel]=store", 0},
+		{"metadata[order]=A-3", 0},
+		{"metadata=A-1", 3},
+	}
+
+	for _, c := range cases {
+		response := api.request(http.MethodGet, "/api/v1/processor/payment/?"+c.query, key, "")
+		if response.Code != http.StatusOK {
+			t.Errorf("%s: status = %d %s, want 200", c.query, response.Code, response.Body)
+			continue
+		}
+
+		var page database.PaymentPage
+		decode(t, response, &page)
+		if len(page.Payments) != c.count {
+			t.Errorf("%s: %d payments, want %d", c.query, len(page.Payments), c.count)
+		}
+		if c.count == 1 && page.Payments[0].Id != first {
+			t.Errorf("%s: found %s, want %s", c.query, page.Payments[0].Id, first)
+		}
+	}
+}
+
+func TestGetPaymentByReference(t *testing.T) {
+	api := newTestApi(t)
+	merchant := api.merchant(t, "Acme")
+	key := merchant.keys[database.ModeLive][database.KeySecret].Key
+
+	id := api.seed(t, merchant, database.Payment{Currency: "USD", Amount: 1000, ReferenceId: "order/42"})
+
+	response := api.request(http.MethodGet, "/api/v1/processor/payment/reference/"+url.PathEscape("order/42"), key, "")
+	var payment database.Payment
+	if response.Code == http.StatusOK {
+		decode(t, response, &payment)
+	}
+	if response.Code != http.StatusOK || payment.Id != id {
+		t.Errorf("known reference = %d %s, want payment %s", response.Code, response.Body, id)
+	}
+
+	for _, reference := range []string{"order-43", "ORDER%2F42"} {
+		response := api.request(http.MethodGet, "/api/v1/processor/payment/reference/"+reference, key, "")
+		var failure ErrorResponse
+		decode(t, response, &failure)
+		if response.Code != http.StatusNotFound || failure.Error.Type != errorNotFound {
+			t.Errorf("reference %s = %d %s, want 404 not_found", reference, response.Code, response.Body)
+		}
+	}
+}
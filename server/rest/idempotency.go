@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/lib/database"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+const (
+	// idempotencyLease bounds how long a key stays reserved by a request that
+	// never finished, such as one whose process crashed.
+	idempotencyLease = 10 * time.Minute
+	// idempotencyRetention is how long completed responses are replayed.
+	idempotencyRetention = 24 * time.Hour
+)
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+func idempotency(store database.IdempotencyStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyHeader)
+		if key == "" || ctx.Request.Method != http.MethodPost {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, errorInvalidRequest, "error reading the request body")
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := store.ReserveIdempotencyKey(ctx.Request.Context(), key, fingerprint, time.Now().Add(idempotencyLease))
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, errorInternal, "error reserving the idempotency key")
+			return
+		}
+
+		if record != nil {
+			replay(ctx, record, fingerprint)
+			return
+		}
+
+		detached := context.Background()
+		if scope, isOk := database.ScopeFrom(ctx.Request.Context()); isOk {
+			detached = database.WithScope(detached, scope)
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				store.ReleaseIdempotencyKey(detached, key)
+				panic(recovered)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			store.ReleaseIdempotencyKey(detached, key)
+			return
+		}
+
+		store.CompleteIdempotencyKey(detached, key, status, writer.body.Bytes(), time.Now().Add(idempotencyRetention))
+	}
+}
+
+// processorKey is the Idempotency-Key forwarded to processors. Every merchant
+// shares the same processor accounts, so the key is derived from the merchant
+// and mode to keep equal keys of different merchants apart.
+func processorKey(ctx *gin.Context) string {
+	key := ctx.GetHeader(idempotencyHeader)
+	scope, isOk := database.ScopeFrom(ctx.Request.Context())
+	if key == "" || !isOk {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(scope.MerchantId + "/" + scope.Mode + "/" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func replay(ctx *gin.Context, record *database.IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		abortWithError(ctx, http.StatusUnprocessableEntity, errorInvalidRequest, "Idempotency-Key was already used with a different request")
+		return
+	}
+
+	if !record.Completed {
+		abortWithError(ctx, http.StatusConflict, errorConflict, "a request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	ctx.Header("Idempotent-Replayed", "true")
+	ctx.Data(record.StatusCode, "application/json; charset=utf-8", record.Body)
+	ctx.Abort()
+}
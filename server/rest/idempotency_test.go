@@ -0,0 +1,162 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"payment-processor.gary94746/main/lib/database"
+)
+
+// idempotentRouter serves handler behind the idempotency middleware, scoped
+// to the merchant named in the Merchant header as authenticate would.
+func idempotentRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(gin.RecoveryWithWriter(io.Discard))
+	r.Use(func(ctx *gin.Context) {
+		scope := database.Scope{MerchantId: ctx.GetHeader("Merchant"), Mode: database.ModeLive}
+		ctx.Request = ctx.Request.WithContext(database.WithScope(ctx.Request.Context(), scope))
+	})
+	r.Use(idempotency(database.NewInMemory()))
+	r.POST("/payments", handler)
+	r.POST("/refunds", handler)
+
+	return r
+}
+
+func post(r http.Handler, path string, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Merchant", "merchant-1")
+	if key != "" {
+		request.Header.Set(idempotencyHeader, key)
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func counting(calls *int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		call := atomic.AddInt64(calls, 1)
+		ctx.JSON(http.StatusCreated, gin.H{"call": call})
+	}
+}
+
+func TestIdempotencyReplaysCompletedResponses(t *testing.T) {
+	var calls int64
+	r := idempotentRouter(counting(&calls))
+
+	first := post(r, "/payments", "key-1", `{"amount":2500}`)
+	second := post(r, "/payments", "key-1", `{"amount":2500}`)
+
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("responses = %d %s and %d %s, want the first one replayed", first.Code, first.Body, second.Code, second.Body)
+	}
+
+	if second.Header().Get("Idempotent-Replayed") != "true" || calls != 1 {
+		t.Errorf("handler ran %d times, replayed header %q, want once and true", calls, second.Header().Get("Idempotent-Replayed"))
+	}
+
+	if response := post(r, "/payments", "", `{"amount":2500}`); response.Body.String() == first.Body.String() {
+		t.Errorf("request without a key = %s, want the handler to run again", response.Body)
+	}
+}
+
+func TestIdempotencyKeyInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := idempotentRouter(func(ctx *gin.Context) {
+		close(started)
+		<-release
+		ctx.JSON(http.StatusCreated, gin.H{"id": "payment-1"})
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(r, "/payments", "key-1", `{}`) }()
+	<-started
+
+	if response := post(r, "/payments", "key-1", `{}`); response.Code != http.StatusConflict {
+		t.Errorf("repeat while in flight = %d %s, want 409", response.Code, response.Body)
+	}
+
+	close(release)
+	if response := <-done; response.Code != http.StatusCreated {
+		t.Errorf("first request = %d %s, want 201", response.Code, response.Body)
+	}
+}
+
+func TestIdempotencyKeyReusedForAnotherRequest(t *testing.T) {
+	var calls int64
+	r := idempotentRouter(counting(&calls))
+
+	post(r, "/payments", "key-1", `{"amount":2500}`)
+
+	if response := post(r, "/payments", "key-1", `{"amount":3000}`); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key with another body = %d %s, want 422", response.Code, response.Body)
+	}
+
+	if response := post(r, "/refunds", "key-1", `{"amount":2500}`); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key on another path = %d %s, want 422", response.Code, response.Body)
+	}
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+}
+
+func TestIdempotencyKeyReleasedAfterFailures(t *testing.T) {
+	failures := map[string]func(ctx *gin.Context){
+		"server error": func(ctx *gin.Context) { ctx.JSON(http.StatusBadGateway, gin.H{}) },
+		"rate limited": func(ctx *gin.Context) { ctx.JSON(http.StatusTooManyRequests, gin.H{}) },
+		"panic":        func(ctx *gin.Context) { panic("handler failed") },
+	}
+
+	for name, fail := range failures {
+		var calls int64
+		r := idempotentRouter(func(ctx *gin.Context) {
+			if atomic.AddInt64(&calls, 1) == 1 {
+				fail(ctx)
+				return
+			}
+			ctx.JSON(http.StatusCreated, gin.H{"call": calls})
+		})
+
+		if response := post(r, "/payments", "key-1", `{}`); response.Code < http.StatusTooManyRequests {
+			t.Fatalf("%s: first request = %d, want a failure", name, response.Code)
+		}
+
+		response := post(r, "/payments", "key-1", `{}`)
+		if response.Code != http.StatusCreated || calls != 2 {
+			t.Errorf("%s: retry = %d %s after %d calls, want the handler to run again", name, response.Code, response.Body, calls)
+		}
+	}
+}
+
+func TestIdempotencyKeysArePerMerchant(t *testing.T) {
+	var calls int64
+	r := idempotentRouter(counting(&calls))
+
+	for merchant := 1; merchant <= 2; merchant++ {
+		request := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(`{}`))
+		request.Header.Set("Merchant", "merchant-"+strconv.Itoa(merchant))
+		request.Header.Set(idempotencyHeader, "key-1")
+
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		if response.Code != http.StatusCreated || response.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("merchant-%d = %d, replayed %q, want its own response", merchant, response.Code, response.Header().Get("Idempotent-Replayed"))
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want once per merchant", calls)
+	}
+}
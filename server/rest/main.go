@@ -1,61 +1,270 @@
 package rest
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"payment-processor.gary94746/main/app/services"
 	"payment-processor.gary94746/main/lib/database"
 	"payment-processor.gary94746/main/lib/processors"
+	"payment-processor.gary94746/main/lib/webhooks"
 )
 
 type ApiRest struct {
 	database database.Database
-	services services.Services
+	services *services.Services
 }
 
 func (ar ApiRest) Serve() error {
 	godotenv.Load()
 
-	paypal := &processors.PayPal{}
-	stripe := &processors.Stripe{}
-	inMemory := database.InMemory{}
+	storage, err := openDatabase()
+	if err != nil {
+		return err
+	}
 
-	stripe.Init(processors.PaymentSettings{
-		Credentials: map[string]string{
-			"token": os.Getenv("STRIPE_TOKEN"),
-		},
-	})
-	paypal.Init(processors.PaymentSettings{
-		Credentials: map[string]string{
-			"client_id":    os.Getenv("PAYPAL_CLIENT_ID"),
-			"client_token": os.Getenv("PAYPAL_CLIENT_TOKEN"),
-			"mode":         os.Getenv("PAYPAL_MODE"),
-		},
-	})
+	stripeCredentials := stripeSettings("STRIPE_")
+	paypalCredentials := paypalSettings("PAYPAL_")
+	paypalCredentials["mode"] = os.Getenv("PAYPAL_MODE")
+
+	if stripeCredentials["webhook_secret"] == "" {
+		slog.Warn("STRIPE_WEBHOOK_SECRET is not set, stripe webhooks will be rejected")
+	}
+	if paypalCredentials["webhook_id"] == "" {
+		slog.Warn("PAYPAL_WEBHOOK_ID is not set, paypal webhooks will be rejected")
+	}
+	if os.Getenv("ADMIN_API_KEY") == "" {
+		slog.Warn("ADMIN_API_KEY is not set, merchants and api keys cannot be managed and /api/metrics is disabled")
+	}
+
+	// Test keys never reach live credentials: they use the sandbox accounts
+	// in the *_TEST_* variables, and are rejected when there are none.
+	testStripeCredentials := stripeSettings("STRIPE_TEST_")
+	testPayPalCredentials := paypalSettings("PAYPAL_TEST_")
+	testPayPalCredentials["mode"] = "SANDBOX"
+
+	// Sandbox breakers are named apart so their state does not overwrite the
+	// live one in /api/metrics.
+	testProcessors := processors.Registry{}
+	if testStripeCredentials["token"] != "" {
+		testProcessors[processors.ProcessorStripe] = newConnector(processors.ProcessorStripe+"_test", &processors.Stripe{}, testStripeCredentials)
+	}
+	if testPayPalCredentials["client_id"] != "" {
+		testProcessors[processors.ProcessorPayPal] = newConnector(processors.ProcessorPayPal+"_test", &processors.PayPal{}, testPayPalCredentials)
+	}
+	if len(testProcessors) == 0 {
+		slog.Warn("no sandbox credentials are set, test mode keys will be rejected")
+	}
+
+	webhookStore, _ := storage.(database.WebhookStore)
+	merchantStore, isOk := storage.(database.MerchantStore)
+	if !isOk {
+		return errors.New("the database does not support merchant accounts")
+	}
 
 	api := ApiRest{
-		database: inMemory,
-		services: services.Services{
-			Database:         inMemory,
-			PaymentProcessor: paypal,
+		database: storage,
+		services: &services.Services{
+			Database:  storage,
+			Webhooks:  webhookStore,
+			Merchants: merchantStore,
+			Processors: processors.Registry{
+				processors.ProcessorPayPal: newConnector(processors.ProcessorPayPal, &processors.PayPal{}, paypalCredentials),
+				processors.ProcessorStripe: newConnector(processors.ProcessorStripe, &processors.Stripe{}, stripeCredentials),
+			},
+			TestProcessors:    testProcessors,
+			DefaultProcessor:  defaultProcessor(),
+			FailoverProcessor: os.Getenv("FAILOVER_PROCESSOR"),
 		},
 	}
 
 	r := gin.Default()
+	api.routes(r, os.Getenv("ADMIN_API_KEY"))
+
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	if webhookStore != nil {
+		go webhooks.NewDispatcher(webhookStore, webhookSettings()).Run(requests)
+	}
+
+	server := &http.Server{
+		Addr:    ":3001",
+		Handler: r,
+		BaseContext: func(net.Listener) context.Context {
+			return requests
+		},
+	}
+
+	return serveUntilSignal(server, cancelRequests)
+}
+
+// routes registers every endpoint of the API on r. The admin routes are left
+// out when adminKey is empty.
+func (api ApiRest) routes(r *gin.Engine, adminKey string) {
+	// References may contain an escaped "/", which must stay in the
+	// :reference parameter rather than split the path.
+	r.UseRawPath = true
 
 	r.GET("/api/health", health)
 
-	processorV1Group := r.Group("/api/v1/processor/payment")
-	processorV1Group.GET("/:id", api.getPayment)
+	// Creating a payment sets its amount and redirect urls, so publishable
+	// keys, which are meant to be shipped to browsers, are not accepted.
+	secretKeys := authenticate(api.services.Authenticate, database.KeySecret)
+
+	processorV1Group := r.Group("/api/v1/processor/payment", secretKeys)
+	if store, ok := api.database.(database.IdempotencyStore); ok {
+		processorV1Group.Use(idempotency(store))
+	}
 	processorV1Group.POST("/", api.createPayment)
+	processorV1Group.GET("/", api.listPayments)
+	processorV1Group.GET("/:id", api.getPayment)
+	processorV1Group.GET("/reference/:reference", api.getPaymentByReference)
 	processorV1Group.POST("/:id/capture", api.capturePayment)
 	processorV1Group.POST("/:id/refund", api.refundPayment)
+	processorV1Group.POST("/:id/void", api.voidPayment)
+
+	webhookV1Group := r.Group("/api/v1/processor/webhook")
+	webhookV1Group.POST("/:processor", api.receiveWebhook)
+	webhookV1Group.POST("/:processor/test", api.receiveTestWebhook)
+
+	if adminKey != "" {
+		r.GET("/api/metrics", admin(adminKey), gin.WrapH(expvar.Handler()))
+
+		adminGroup := r.Group("/api/admin", admin(adminKey))
+		adminGroup.POST("/merchants", api.createMerchant)
+		adminGroup.GET("/merchants/:id/keys", api.listApiKeys)
+		adminGroup.POST("/merchants/:id/keys", api.issueApiKey)
+		adminGroup.DELETE("/merchants/:id/keys/:keyId", api.revokeApiKey)
+	}
+
+	if api.services.Webhooks != nil {
+		subscriptionsV1Group := r.Group("/api/v1/webhooks", secretKeys)
+		subscriptionsV1Group.POST("/subscriptions", api.createSubscription)
+		subscriptionsV1Group.GET("/subscriptions", api.listSubscriptions)
+		subscriptionsV1Group.DELETE("/subscriptions/:id", api.deleteSubscription)
+		subscriptionsV1Group.GET("/deliveries", api.listDeliveries)
+		subscriptionsV1Group.POST("/events/:id/replay", api.replayEvent)
+	}
+}
+
+func serveUntilSignal(server *http.Server, cancelRequests context.CancelFunc) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	cancelRequests()
+	if err != nil {
+		return server.Close()
+	}
+
+	return nil
+}
+
+func shutdownTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || seconds <= 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func stripeSettings(prefix string) map[string]string {
+	return withTransportSettings("STRIPE_", map[string]string{
+		"token":             os.Getenv(prefix + "TOKEN"),
+		"base_url":          os.Getenv(prefix + "BASE_URL"),
+		"webhook_secret":    os.Getenv(prefix + "WEBHOOK_SECRET"),
+		"webhook_tolerance": os.Getenv("STRIPE_WEBHOOK_TOLERANCE"),
+	})
+}
+
+func paypalSettings(prefix string) map[string]string {
+	return withTransportSettings("PAYPAL_", map[string]string{
+		"client_id":    os.Getenv(prefix + "CLIENT_ID"),
+		"client_token": os.Getenv(prefix + "CLIENT_TOKEN"),
+		"base_url":     os.Getenv(prefix + "BASE_URL"),
+		"webhook_id":   os.Getenv(prefix + "WEBHOOK_ID"),
+		"auto_capture": os.Getenv("PAYPAL_AUTO_CAPTURE"),
+	})
+}
+
+func newConnector(name string, connector processors.PaymentConnector, credentials map[string]string) processors.PaymentConnector {
+	connector.Init(processors.PaymentSettings{Credentials: credentials})
+	return processors.NewBreaker(name, connector, processors.BreakerSettingsFrom(credentials))
+}
+
+func withTransportSettings(prefix string, credentials map[string]string) map[string]string {
+	keys := []string{"timeout", "create_timeout", "capture_timeout", "refund_timeout", "void_timeout",
+		"retry_attempts", "retry_base_delay_ms", "retry_max_delay_ms",
+		"breaker_failures", "breaker_open_timeout", "breaker_half_open_requests"}
+
+	for _, key := range keys {
+		credentials[key] = os.Getenv(prefix + strings.ToUpper(key))
+	}
+
+	return credentials
+}
+
+func webhookSettings() webhooks.Settings {
+	values := map[string]string{}
+	for _, key := range []string{"max_attempts", "base_delay", "max_delay", "poll_interval", "timeout", "allow_private_networks"} {
+		values[key] = os.Getenv("WEBHOOK_" + strings.ToUpper(key))
+	}
+
+	return webhooks.SettingsFrom(values)
+}
+
+func openDatabase() (database.Database, error) {
+	driver := os.Getenv("DATABASE_DRIVER")
+
+	switch driver {
+	case "", "memory":
+		return database.NewInMemory(), nil
+	case "postgres":
+		return database.NewPostgres(os.Getenv("DATABASE_URL"))
+	case "sqlite":
+		return database.NewSQLite(os.Getenv("SQLITE_PATH"))
+	}
+
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
+
+func defaultProcessor() string {
+	processor := os.Getenv("DEFAULT_PROCESSOR")
+	if processor == "" {
+		return processors.ProcessorPayPal
+	}
 
-	error := r.Run(":3001")
-	return error
+	return processor
 }
 
 func health(ctx *gin.Context) {
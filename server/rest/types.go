@@ -1,30 +1,105 @@
 package rest
 
+import (
+	"time"
+
+	"payment-processor.gary94746/main/app/services"
+)
+
 type PartialRefund struct {
-	Amount int64 `json:"amount" binding:"required,number"`
+	Amount int64  `json:"amount" binding:"omitempty,number,min=1"`
+	Reason string `json:"reason" binding:"omitempty,max=500"`
+}
+
+type Capture struct {
+	Amount int64 `json:"amount" binding:"omitempty,number,min=1"`
+	Final  *bool `json:"final"`
 }
 
 type RefundResponse struct {
-	Id     string `json:"id"`
-	Amount string `json:"amount"`
+	Id       string `json:"id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
 }
 
 type LineItem struct {
-	Name     string `json:"name" binding:"required,min=1,max=400"`
-	Amount   int64  `json:"amount" binding:"required,number,min=1000"`
-	Quantity int32  `json:"quantity" binding:"required,number,min=1"`
+	Name        string `json:"name" binding:"required,min=1,max=400"`
+	Amount      int64  `json:"amount" binding:"required,number,min=1000"`
+	Quantity    int32  `json:"quantity" binding:"required,number,min=1"`
+	Sku         string `json:"sku" binding:"omitempty,max=127"`
+	Description string `json:"description" binding:"omitempty,max=127"`
+	Tax         int64  `json:"tax" binding:"omitempty,number,min=0"`
+}
+
+type Address struct {
+	Line1      string `json:"line1" binding:"omitempty,max=300"`
+	Line2      string `json:"line2" binding:"omitempty,max=300"`
+	City       string `json:"city" binding:"omitempty,max=120"`
+	State      string `json:"state" binding:"omitempty,max=300"`
+	PostalCode string `json:"postalCode" binding:"omitempty,max=60"`
+	Country    string `json:"country" binding:"omitempty,iso3166_1_alpha2"`
+}
+
+type Shipping struct {
+	Name    string  `json:"name" binding:"omitempty,max=300"`
+	Amount  int64   `json:"amount" binding:"omitempty,number,min=0"`
+	Address Address `json:"address"`
+}
+
+type Customer struct {
+	Name  string `json:"name" binding:"omitempty,max=300"`
+	Email string `json:"email" binding:"omitempty,email,max=254"`
+	Phone string `json:"phone" binding:"omitempty,max=40"`
 }
 
 type Payment struct {
-	Currency    string           `json:"currency" binding:"required,iso4217"`
-	Amount      int64            `json:"amount" binding:"required,number,min=1000"`
-	Status      string           `json:"status" binding:"-"`
-	RedirectUrl string           `json:"redirectUrl" binding:"required,url"`
-	CancelUrl   string           `json:"cancelUrl" binding:"required,url"`
-	PrivateId   string           `json:"privateId" binding:"-"`
-	LineItems   []LineItem       `json:"lineItems" binding:"required,gt=0,dive,lt=200,dive"`
-	Refunds     []RefundResponse `json:"refunds"`
-	Id          string           `json:"id" binding:"-"`
+	Currency      string            `json:"currency" binding:"required,iso4217"`
+	Amount        int64             `json:"amount" binding:"omitempty,number,min=1000"`
+	Status        string            `json:"status" binding:"-"`
+	RedirectUrl   string            `json:"redirectUrl" binding:"required,url"`
+	CancelUrl     string            `json:"cancelUrl" binding:"required,url"`
+	PrivateId     string            `json:"privateId" binding:"-"`
+	LineItems     []LineItem        `json:"lineItems" binding:"required,gt=0,dive,lt=200,dive"`
+	Shipping      *Shipping         `json:"shipping"`
+	Discount      int64             `json:"discount" binding:"omitempty,number,min=0"`
+	Refunds       []RefundResponse  `json:"refunds"`
+	Id            string            `json:"id" binding:"-"`
+	Processor     string            `json:"processor" binding:"omitempty,oneof=stripe paypal"`
+	CaptureMethod string            `json:"captureMethod" binding:"omitempty,oneof=automatic manual"`
+	ReferenceId   string            `json:"referenceId" binding:"omitempty,max=127"`
+	Description   string            `json:"description" binding:"omitempty,max=127"`
+	Customer      *Customer         `json:"customer"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+type PaymentQuery struct {
+	Status        string    `form:"status" binding:"omitempty,max=40"`
+	Processor     string    `form:"processor" binding:"omitempty,oneof=stripe paypal"`
+	Currency      string    `form:"currency" binding:"omitempty,iso4217"`
+	MinAmount     int64     `form:"minAmount" binding:"omitempty,number,min=1"`
+	MaxAmount     int64     `form:"maxAmount" binding:"omitempty,number,min=1"`
+	CreatedAfter  time.Time `form:"createdAfter" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore time.Time `form:"createdBefore" time_format:"2006-01-02T15:04:05Z07:00"`
+	Order         string    `form:"order" binding:"omitempty,oneof=asc desc"`
+	Cursor        string    `form:"cursor" binding:"omitempty,max=200"`
+	Limit         int       `form:"limit" binding:"omitempty,number,min=1,max=100"`
+}
+
+type Subscription struct {
+	Url    string   `json:"url" binding:"required,url,max=2000"`
+	Events []string `json:"events" binding:"required,gt=0,dive,required"`
+	Secret string   `json:"secret" binding:"omitempty,min=16,max=200"`
+}
+
+type Merchant struct {
+	Name string `json:"name" binding:"required,max=200"`
+}
+
+type ApiKey struct {
+	Kind string `json:"kind" binding:"required,oneof=secret publishable"`
+	Mode string `json:"mode" binding:"required,oneof=test live"`
 }
 
 type PaymentDetail struct {
@@ -33,3 +108,15 @@ type PaymentDetail struct {
 	RedirectUrl string `json:"redirectUrl"`
 	Status      string `json:"status"`
 }
+
+type ErrorDetail struct {
+	Type      string                `json:"type"`
+	Code      string                `json:"code,omitempty"`
+	Message   string                `json:"message"`
+	Processor string                `json:"processor,omitempty"`
+	Fields    []services.FieldError `json:"fields,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}